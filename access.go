@@ -0,0 +1,103 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// AccessSource is where a Get found the value of a key.
+type AccessSource int
+
+const (
+	// A MainCacheAccess is served from the main cache.
+	MainCacheAccess AccessSource = iota + 1
+
+	// A HotCacheAccess is served from the hot cache.
+	HotCacheAccess
+
+	// A PeerAccess is served by the peer owning the key.
+	PeerAccess
+
+	// A LoadAccess is served by the group's Getter.
+	LoadAccess
+
+	// A SharedAccess is served by the load of a concurrent or recent Get
+	// of the same key, see GetInfo.Follower.
+	SharedAccess
+)
+
+// String returns "main", "hot", "peer", "load" or "shared".
+func (s AccessSource) String() string {
+	switch s {
+	case MainCacheAccess:
+		return "main"
+	case HotCacheAccess:
+		return "hot"
+	case PeerAccess:
+		return "peer"
+	case LoadAccess:
+		return "load"
+	case SharedAccess:
+		return "shared"
+	default:
+		return "AccessSource(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// accessSource returns the AccessSource of a hit in the cache which.
+func accessSource(which CacheType) AccessSource {
+	if which == HotCache {
+		return HotCacheAccess
+	}
+	return MainCacheAccess
+}
+
+// An AccessEvent describes a Get, see GroupOptions.OnAccess.
+type AccessEvent struct {
+	// Context is the context of the Get, such as to find who made it.
+	Context context.Context
+
+	Key string
+
+	// Time is when the Get returned, from the group's Clock.
+	Time time.Time
+
+	// Source is where the value was found. It is zero if the Get failed
+	// before any was asked, such as when the key was known to be missing.
+	Source AccessSource
+
+	// Hit is true if the value was found in the main or hot cache.
+	Hit bool
+
+	// Err is the error the Get failed with, if any.
+	Err error
+}
+
+// access reports a Get to GroupOptions.OnAccess.
+func (g *Group) access(ctx context.Context, key string, info GetInfo, err error) {
+	g.opts.OnAccess(AccessEvent{
+		Context: ctx,
+		Key:     key,
+		Time:    g.opts.Clock(),
+		Source:  info.Source,
+		Hit:     info.CacheHit,
+		Err:     err,
+	})
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"sync"
+
+	"github.com/zeebo/xxh3"
+)
+
+const (
+	sketchDepth    = 4
+	sketchWidth    = 1 << 14
+	sketchMaxCount = 15
+)
+
+// tinyLFU is a TinyLFU style admission filter. A count-min sketch
+// estimates how often each key was requested, and a new entry which
+// would cause an eviction is only admitted when it was requested more
+// often than the entry it would replace. Counters are halved
+// periodically so the estimates favor recent popularity.
+type tinyLFU struct {
+	mu        sync.Mutex
+	seed      uint64 // of the key hashes
+	rows      [sketchDepth][]uint8
+	additions int
+	resetAt   int
+}
+
+func newTinyLFU(seed uint64) *tinyLFU {
+	f := &tinyLFU{seed: seed, resetAt: 10 * sketchWidth}
+	for i := range f.rows {
+		f.rows[i] = make([]uint8, sketchWidth)
+	}
+	return f
+}
+
+// record counts one request for key.
+func (f *tinyLFU) record(key string) {
+	h := xxh3.HashStringSeed(key, f.seed)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.rows {
+		if c := &f.rows[i][sketchIndex(h, i)]; *c < sketchMaxCount {
+			*c++
+		}
+	}
+	f.additions++
+	if f.additions >= f.resetAt {
+		f.reset()
+	}
+}
+
+// estimate returns the approximate number of requests recorded for key.
+func (f *tinyLFU) estimate(key string) uint8 {
+	h := xxh3.HashStringSeed(key, f.seed)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	min := uint8(sketchMaxCount)
+	for i := range f.rows {
+		if c := f.rows[i][sketchIndex(h, i)]; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// admit reports whether candidate should replace victim in the cache.
+func (f *tinyLFU) admit(candidate, victim string) bool {
+	return f.estimate(candidate) > f.estimate(victim)
+}
+
+func (f *tinyLFU) reset() {
+	for i := range f.rows {
+		for j := range f.rows[i] {
+			f.rows[i][j] >>= 1
+		}
+	}
+	f.additions /= 2
+}
+
+// sketchIndex derives the counter index of row i from a single hash
+// using double hashing.
+func sketchIndex(h uint64, i int) uint32 {
+	h1, h2 := uint32(h), uint32(h>>32)
+	return (h1 + uint32(i)*h2) & (sketchWidth - 1)
+}
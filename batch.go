@@ -0,0 +1,166 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/xdbbe/groupcache/v2/groupcachepb"
+)
+
+// batcher merges the gets of a peer which arrive within a window into
+// one multi-get per group, see HTTPPoolOptions.BatchWindow.
+type batcher struct {
+	window time.Duration
+	multi  func(ctx context.Context, in *pb.GetMultiRequest, fn func(key string, out *pb.GetResponse, err error)) error
+
+	mu      sync.Mutex
+	pending map[string]*batch // by group
+	closed  bool              // whether batches are sent right away
+
+	// flushes counts the batches which are pending or being sent, and
+	// idle is signaled when it drops to zero. Unlike a sync.WaitGroup,
+	// it may grow while close waits for it.
+	flushes int
+	idle    *sync.Cond
+}
+
+// batch is the keys of a group waiting for the window to end.
+type batch struct {
+	keys    []string
+	waiters map[string][]chan batchResult
+	timer   *time.Timer
+
+	// deadline is the latest deadline of the waiters, unless unbounded,
+	// when some waiter has none.
+	deadline  time.Time
+	unbounded bool
+}
+
+type batchResult struct {
+	out *pb.GetResponse
+	err error
+}
+
+// get adds key to the pending batch of group, starting one if needed,
+// and waits for its result.
+func (b *batcher) get(ctx context.Context, group, key string, out *pb.GetResponse) error {
+	ch := make(chan batchResult, 1)
+	b.mu.Lock()
+	if b.pending == nil {
+		b.pending = make(map[string]*batch)
+	}
+	bt, ok := b.pending[group]
+	if !ok {
+		bt = &batch{waiters: make(map[string][]chan batchResult)}
+		b.pending[group] = bt
+		b.flushes++
+		window := b.window
+		if b.closed {
+			window = 0
+		}
+		bt.timer = time.AfterFunc(window, func() { b.flush(group, bt) })
+	}
+	if _, ok := bt.waiters[key]; !ok {
+		bt.keys = append(bt.keys, key)
+	}
+	bt.waiters[key] = append(bt.waiters[key], ch)
+	if d, ok := ctx.Deadline(); !ok {
+		bt.unbounded = true
+	} else if d.After(bt.deadline) {
+		bt.deadline = d
+	}
+	b.mu.Unlock()
+
+	select {
+	case res := <-ch:
+		if res.err != nil {
+			return res.err
+		}
+		proto.Merge(out, res.out)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// flush sends the multi-get of bt. It is not tied to the context of any
+// one caller, since the others still wait for it if that caller gives
+// up, but it is given up once the last deadline of the callers passes.
+func (b *batcher) flush(group string, bt *batch) {
+	defer b.flushed()
+	b.mu.Lock()
+	delete(b.pending, group)
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if !bt.unbounded {
+		ctx, cancel = context.WithDeadline(ctx, bt.deadline)
+	}
+	b.mu.Unlock()
+	defer cancel()
+
+	req := &pb.GetMultiRequest{Group: proto.String(group), Keys: bt.keys}
+	err := b.multi(ctx, req, func(key string, out *pb.GetResponse, err error) {
+		for _, ch := range bt.waiters[key] {
+			ch <- batchResult{out: out, err: err}
+		}
+		delete(bt.waiters, key)
+	})
+	if err == nil {
+		err = fmt.Errorf("groupcache: multi-get response has no value for the key")
+	}
+	for _, chans := range bt.waiters {
+		for _, ch := range chans {
+			ch <- batchResult{err: err}
+		}
+	}
+}
+
+// flushed records that a batch was answered.
+func (b *batcher) flushed() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.flushes--; b.flushes == 0 && b.idle != nil {
+		b.idle.Broadcast()
+	}
+}
+
+// close sends the pending batches without waiting for their window to
+// end, as it does with later ones, and waits for them to be answered.
+func (b *batcher) close() {
+	b.mu.Lock()
+	b.closed = true
+	var early []string
+	for group, bt := range b.pending {
+		if bt.timer.Stop() {
+			early = append(early, group)
+		}
+	}
+	for _, group := range early {
+		go b.flush(group, b.pending[group])
+	}
+	if b.idle == nil {
+		b.idle = sync.NewCond(&b.mu)
+	}
+	for b.flushes > 0 {
+		b.idle.Wait()
+	}
+	b.mu.Unlock()
+}
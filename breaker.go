@@ -0,0 +1,139 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerWindow   = 10 * time.Second
+	defaultBreakerCooldown = 5 * time.Second
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// breaker is a circuit breaker around a group's Getter. It opens after
+// threshold consecutive failures within window, fails loads fast while
+// open, and lets a single probe through once cooldown has elapsed.
+type breaker struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	stats     *Stats
+	now       func() time.Time
+
+	mu           sync.Mutex
+	state        breakerState
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	probing      bool
+}
+
+func newBreaker(o GroupOptions, stats *Stats) *breaker {
+	b := &breaker{
+		threshold: o.BreakerThreshold,
+		window:    o.BreakerWindow,
+		cooldown:  o.BreakerCooldown,
+		stats:     stats,
+		now:       o.Clock,
+	}
+	if b.window == 0 {
+		b.window = defaultBreakerWindow
+	}
+	if b.cooldown == 0 {
+		b.cooldown = defaultBreakerCooldown
+	}
+	return b
+}
+
+// allow returns ErrCircuitOpen if the Getter must not be called.
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return &ErrCircuitOpen{Msg: "groupcache: circuit breaker is open"}
+		}
+		b.state = breakerHalfOpen
+		b.stats.BreakerHalfOpens.Add(1)
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing {
+			return &ErrCircuitOpen{Msg: "groupcache: circuit breaker is half-open"}
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// done records the outcome of a Getter call let through by allow.
+func (b *breaker) done(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if errors.Is(err, context.Canceled) {
+		// The caller went away, which says nothing about the Getter: a
+		// half-open breaker lets the next call probe it instead.
+		b.probing = false
+		return
+	}
+	failed := err != nil && !errors.Is(err, &ErrNotFound{})
+
+	if b.state == breakerHalfOpen {
+		b.probing = false
+		if failed {
+			b.open()
+			return
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		b.stats.BreakerCloses.Add(1)
+		return
+	}
+
+	if !failed {
+		b.failures = 0
+		return
+	}
+	now := b.now()
+	if b.failures == 0 || now.Sub(b.firstFailure) > b.window {
+		b.failures = 0
+		b.firstFailure = now
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.open()
+	}
+}
+
+func (b *breaker) open() {
+	b.state = breakerOpen
+	b.openedAt = b.now()
+	b.failures = 0
+	b.stats.BreakerOpens.Add(1)
+}
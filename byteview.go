@@ -20,7 +20,11 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/zeebo/xxh3"
 )
 
 // A ByteView holds an immutable view of bytes.
@@ -33,6 +37,120 @@ type ByteView struct {
 	// If b is non-nil, b is used, else s is used.
 	b []byte
 	s string
+
+	// e, if non-nil, holds what the cache knows of the view, such as
+	// when it expires. Views share it with the cache entry they were
+	// read from, so it must not be changed once set; see withEntry.
+	e *entry
+}
+
+// An entry holds what the cache records of a view besides its bytes.
+// Views which were never cached, and entries which record nothing, have
+// none, so that plain views stay small.
+type entry struct {
+	// expire, if non-zero, is when the view stops being cached.
+	expire time.Time
+
+	// added is when the view was added to the cache.
+	added time.Time
+
+	// version is the version the view was Set with.
+	version int64
+
+	// extra, if non-nil, holds what only some groups record.
+	extra *entryExtra
+}
+
+// entryExtra holds the entry fields which options of the group enable.
+type entryExtra struct {
+	// weight is the eviction weight of the view, see GroupOptions.Weight.
+	weight int
+
+	// source, if set, is the peer the view was fetched from.
+	source string
+
+	// derived, if non-nil, is what GroupOptions.Derive returned for the
+	// view when it was cached.
+	derived []byte
+
+	// tags are what GroupOptions.Tags returned for the view when it was
+	// cached.
+	tags []string
+}
+
+// noEntry is the entry of views without one. It is never changed.
+var noEntry entry
+
+func (v ByteView) entry() *entry {
+	if v.e != nil {
+		return v.e
+	}
+	return &noEntry
+}
+
+func (v ByteView) extra() *entryExtra {
+	if x := v.entry().extra; x != nil {
+		return x
+	}
+	return &entryExtra{}
+}
+
+// withEntry returns v with a copy of its entry changed by fn, so that
+// views sharing the entry are unaffected.
+func (v ByteView) withEntry(fn func(e *entry)) ByteView {
+	e := *v.entry()
+	if e.extra != nil {
+		x := *e.extra
+		e.extra = &x
+	}
+	fn(&e)
+	v.e = &e
+	return v
+}
+
+// setExtra changes the extra fields of e with fn, allocating them only
+// if fn sets any.
+func (e *entry) setExtra(fn func(x *entryExtra)) {
+	var x entryExtra
+	if e.extra != nil {
+		x = *e.extra
+	}
+	fn(&x)
+	if x.weight == 0 && x.source == "" && x.derived == nil && x.tags == nil {
+		e.extra = nil
+		return
+	}
+	e.extra = &x
+}
+
+// withVersion returns v with the version it was Set with and the peer
+// it was fetched from, if any.
+func (v ByteView) withVersion(version int64, source string) ByteView {
+	if version == 0 && source == "" && v.e == nil {
+		return v
+	}
+	return v.withEntry(func(e *entry) {
+		e.version = version
+		e.setExtra(func(x *entryExtra) { x.source = source })
+	})
+}
+
+func (v ByteView) added() time.Time { return v.entry().added }
+func (v ByteView) weight() int      { return v.extra().weight }
+func (v ByteView) source() string   { return v.extra().source }
+func (v ByteView) derived() []byte  { return v.extra().derived }
+func (v ByteView) tags() []string   { return v.extra().tags }
+
+// Version returns the version the view was Set with, or zero if it was
+// loaded by a Getter.
+func (v ByteView) Version() int64 {
+	return v.entry().version
+}
+
+// Expire returns the time the view expires from the cache, or the zero
+// time if it never expires.
+func (v ByteView) Expire() time.Time {
+	return v.entry().expire
 }
 
 // Len returns the view's length.
@@ -83,6 +201,44 @@ func (v ByteView) SliceFrom(from int) ByteView {
 	return ByteView{s: v.s[from:]}
 }
 
+// sliceRange returns length bytes of the view starting at offset,
+// clamped to the bounds of the view. A length of zero or less means
+// until the end.
+func (v ByteView) sliceRange(offset, length int64) ByteView {
+	n := int64(v.Len())
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > n {
+		offset = n
+	}
+	end := n
+	// Compared with n-offset, as offset+length may overflow.
+	if length > 0 && length < n-offset {
+		end = offset + length
+	}
+	return v.Slice(int(offset), int(end))
+}
+
+// ETag returns a tag identifying the contents of the view. Views with
+// equal contents have equal ETags. It is unseeded, so for a group with
+// a GroupOptions.HashSeed it differs from the ETags of GetIfModified,
+// ServeValue and peers, which are hashed with the seed.
+func (v ByteView) ETag() string {
+	return v.etagSeed(0)
+}
+
+// etagSeed is ETag for a group whose HashSeed is seed.
+func (v ByteView) etagSeed(seed uint64) string {
+	var h uint64
+	if v.b != nil {
+		h = xxh3.HashSeed(v.b, seed)
+	} else {
+		h = xxh3.HashStringSeed(v.s, seed)
+	}
+	return strconv.FormatUint(h, 16)
+}
+
 // Copy copies b into dest and returns the number of bytes copied.
 func (v ByteView) Copy(dest []byte) int {
 	if v.b != nil {
@@ -22,6 +22,7 @@ import (
 	"io"
 	"io/ioutil"
 	"testing"
+	"unsafe"
 )
 
 func TestByteView(t *testing.T) {
@@ -145,3 +146,24 @@ func min(a, b int) int {
 	}
 	return b
 }
+
+func TestByteViewEntry(t *testing.T) {
+	// Plain views carry a single pointer beside their bytes.
+	if got, want := unsafe.Sizeof(ByteView{}), unsafe.Sizeof([]byte(nil))+unsafe.Sizeof("")+unsafe.Sizeof(&entry{}); got != want {
+		t.Errorf("ByteView is %d bytes; want %d", got, want)
+	}
+
+	v := ByteView{s: "value"}.withVersion(3, "")
+	if v.Version() != 3 || v.e.extra != nil {
+		t.Errorf("withVersion(3) = version %d with extra %v; want 3 without extra", v.Version(), v.e.extra)
+	}
+	// Changing an entry copies it, leaving views sharing it alone.
+	w := v.withEntry(func(e *entry) {
+		e.version = 4
+		e.setExtra(func(x *entryExtra) { x.source = "peer" })
+	})
+	if v.Version() != 3 || v.source() != "" || w.Version() != 4 || w.source() != "peer" {
+		t.Errorf("views have versions %d and %d and sources %q and %q; want 3 and 4, and none and peer",
+			v.Version(), w.Version(), v.source(), w.source())
+	}
+}
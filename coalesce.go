@@ -0,0 +1,107 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// recentLoads remembers the values of loads which completed within the
+// last window, so that a miss arriving just after a flight finished can
+// reuse its value rather than loading the key again.
+type recentLoads struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu sync.Mutex
+	m  map[string]recentLoad
+}
+
+type recentLoad struct {
+	value  ByteView
+	expire time.Time
+}
+
+func newRecentLoads(window time.Duration, now func() time.Time) *recentLoads {
+	return &recentLoads{
+		window: window,
+		now:    now,
+		m:      make(map[string]recentLoad),
+	}
+}
+
+// get returns the value of a load of key which completed within the window.
+func (r *recentLoads) get(key string) (ByteView, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.m[key]
+	if !ok {
+		return ByteView{}, false
+	}
+	if !r.now().Before(l.expire) {
+		delete(r.m, key)
+		return ByteView{}, false
+	}
+	return l.value, true
+}
+
+// add records value as just loaded for key, until the window elapses.
+func (r *recentLoads) add(key string, value ByteView) {
+	expire := r.now().Add(r.window)
+	r.mu.Lock()
+	r.m[key] = recentLoad{value: value, expire: expire}
+	r.mu.Unlock()
+
+	time.AfterFunc(r.window, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if l, ok := r.m[key]; ok && l.expire == expire {
+			delete(r.m, key)
+		}
+	})
+}
+
+// forget drops key, such as when it is set or removed.
+func (r *recentLoads) forget(key string) {
+	r.mu.Lock()
+	delete(r.m, key)
+	r.mu.Unlock()
+}
+
+// forgetPrefix drops every key with prefix.
+func (r *recentLoads) forgetPrefix(prefix string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key := range r.m {
+		if strings.HasPrefix(key, prefix) {
+			delete(r.m, key)
+		}
+	}
+}
+
+// forgetFunc drops every load for which fn returns true.
+func (r *recentLoads) forgetFunc(fn func(key string, value ByteView) bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, l := range r.m {
+		if fn(key, l.value) {
+			delete(r.m, key)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCoalesceWindow(t *testing.T) {
+	clock := newFakeClock()
+	var loads int
+	// With no cache space, only the window can spare the second load.
+	g := newGroupOpts("coalesceWindowTest", 0, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		loads++
+		return dest.SetString("value")
+	}), NoPeers{}, &GroupOptions{CoalesceWindow: time.Second, Clock: clock.Now})
+
+	get := func() {
+		t.Helper()
+		var s string
+		if err := g.Get(context.Background(), "key", StringSink(&s)); err != nil || s != "value" {
+			t.Fatalf("Get = %q, %v", s, err)
+		}
+	}
+
+	get()
+	info, err := g.GetWithInfo(context.Background(), "key", StringSink(new(string)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loads != 1 {
+		t.Errorf("Getter ran %d times within the window; want 1", loads)
+	}
+	if !info.Follower {
+		t.Error("expected the second Get to reuse the first load")
+	}
+	if got := g.Stats.CoalescedLoads.Get(); got != 1 {
+		t.Errorf("CoalescedLoads = %d; want 1", got)
+	}
+
+	clock.Advance(time.Second)
+	get()
+	if loads != 2 {
+		t.Errorf("Getter ran %d times after the window; want 2", loads)
+	}
+
+	g.localRemove("key")
+	get()
+	if loads != 3 {
+		t.Errorf("Getter ran %d times after Remove; want 3", loads)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Codec converts values to and from the bytes which are cached.
+type Codec struct {
+	Marshal   func(v interface{}) ([]byte, error)
+	Unmarshal func(data []byte, v interface{}) error
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		"json": {Marshal: json.Marshal, Unmarshal: json.Unmarshal},
+		"proto": {
+			Marshal: func(v interface{}) ([]byte, error) {
+				m, ok := v.(proto.Message)
+				if !ok {
+					return nil, fmt.Errorf("groupcache: %T is not a proto.Message", v)
+				}
+				return proto.Marshal(m)
+			},
+			Unmarshal: func(data []byte, v interface{}) error {
+				m, ok := v.(proto.Message)
+				if !ok {
+					return fmt.Errorf("groupcache: %T is not a proto.Message", v)
+				}
+				return proto.Unmarshal(data, m)
+			},
+		},
+	}
+)
+
+// RegisterCodec makes a codec available to CodecSink and SetCodec by
+// name. The "json" and "proto" codecs are registered by default.
+// It panics if a codec is already registered under name.
+func RegisterCodec(name string, marshal func(v interface{}) ([]byte, error), unmarshal func(data []byte, v interface{}) error) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if _, dup := codecs[name]; dup {
+		panic("groupcache: RegisterCodec called twice for codec " + name)
+	}
+	codecs[name] = Codec{Marshal: marshal, Unmarshal: unmarshal}
+}
+
+func getCodec(name string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	return c, ok
+}
+
+// SetCodec marshals v with the codec registered as name and sets the
+// result as the value of dest. It is meant to be called by Getters.
+func SetCodec(dest Sink, name string, v interface{}) error {
+	c, ok := getCodec(name)
+	if !ok {
+		return fmt.Errorf("groupcache: unknown codec %q", name)
+	}
+	b, err := c.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if s, ok := dest.(*codecSink); ok {
+		// b is ours, so the sink can keep it without a copy.
+		return s.set(b)
+	}
+	return dest.SetBytes(b)
+}
+
+// CodecSink returns a Sink that unmarshals values into ptr with the
+// codec registered as name. It panics if no such codec is registered.
+func CodecSink(name string, ptr interface{}) Sink {
+	c, ok := getCodec(name)
+	if !ok {
+		panic("groupcache: unknown codec " + name)
+	}
+	return &codecSink{codec: c, dst: ptr}
+}
+
+var _ Sink = &codecSink{}
+
+type codecSink struct {
+	codec Codec
+	dst   interface{}
+	v     ByteView // encoded
+}
+
+func (s *codecSink) view() (ByteView, error) {
+	return s.v, nil
+}
+
+func (s *codecSink) setView(v ByteView) error {
+	if err := s.codec.Unmarshal(v.ByteSlice(), s.dst); err != nil {
+		return err
+	}
+	s.v = v
+	return nil
+}
+
+func (s *codecSink) Reset() {
+	s.v = ByteView{}
+}
+
+// set decodes b, which the sink takes ownership of.
+func (s *codecSink) set(b []byte) error {
+	if err := s.codec.Unmarshal(b, s.dst); err != nil {
+		return err
+	}
+	s.v = ByteView{b: b}
+	return nil
+}
+
+func (s *codecSink) SetBytes(b []byte) error {
+	return s.set(cloneBytes(b))
+}
+
+func (s *codecSink) SetString(v string) error {
+	return s.set([]byte(v))
+}
+
+func (s *codecSink) SetProto(m proto.Message) error {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return s.set(b)
+}
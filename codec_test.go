@@ -0,0 +1,97 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCodecSink(t *testing.T) {
+	RegisterCodec("test-csv",
+		func(v interface{}) ([]byte, error) {
+			fields, ok := v.([]string)
+			if !ok {
+				return nil, fmt.Errorf("unexpected %T", v)
+			}
+			return []byte(strings.Join(fields, ",")), nil
+		},
+		func(data []byte, v interface{}) error {
+			fields, ok := v.(*[]string)
+			if !ok {
+				return fmt.Errorf("unexpected %T", v)
+			}
+			*fields = strings.Split(string(data), ",")
+			return nil
+		})
+
+	var loads int
+	g := newGroup("codecSinkTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		loads++
+		return SetCodec(dest, "test-csv", []string{key, "b", "c"})
+	}), NoPeers{})
+
+	for i := 0; i < 2; i++ {
+		var fields []string
+		if err := g.Get(context.Background(), "a", CodecSink("test-csv", &fields)); err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"a", "b", "c"}; !reflect.DeepEqual(fields, want) {
+			t.Errorf("Get #%d = %q; want %q", i, fields, want)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("loaded %d times; want 1", loads)
+	}
+
+	// The cached bytes are the codec's encoding.
+	var s string
+	if err := g.Get(context.Background(), "a", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if s != "a,b,c" {
+		t.Errorf("cached %q; want a,b,c", s)
+	}
+}
+
+func TestCodecSinkJSON(t *testing.T) {
+	type user struct {
+		Name string
+		Age  int
+	}
+	g := newGroup("codecSinkJSONTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		if key == "missing" {
+			return &ErrNotFound{Msg: "not found"}
+		}
+		return SetCodec(dest, "json", user{Name: key, Age: 42})
+	}), NoPeers{})
+
+	var u user
+	if err := g.Get(context.Background(), "gopher", CodecSink("json", &u)); err != nil {
+		t.Fatal(err)
+	}
+	if u != (user{Name: "gopher", Age: 42}) {
+		t.Errorf("got %+v", u)
+	}
+	if err := g.Get(context.Background(), "missing", CodecSink("json", &u)); !errors.Is(err, &ErrNotFound{}) {
+		t.Errorf("expected ErrNotFound; got %v", err)
+	}
+}
@@ -18,65 +18,496 @@ limitations under the License.
 package consistenthash
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
 	"sort"
 	"strconv"
 
 	"github.com/zeebo/xxh3"
 )
 
+// Hash hashes data, which may be any bytes. Keys are hashed exactly as
+// given, so they need not be valid UTF-8 or any other encoding.
 type Hash func(data []byte) uint64
 
 type Map struct {
-	hash     Hash
 	replicas int
-	keys     []int // Sorted
-	hashMap  map[int]string
+
+	// hash hashes a string with the Map's Hash. The xxh3 hashes read
+	// the string in place, so that Get does not allocate, while other
+	// Hash functions get a copy they are free to modify.
+	hash func(s string) uint64
+	// hashBytes is hash for a byte slice. Like hash, it only copies the
+	// bytes for Hash functions other than xxh3.
+	hashBytes func(b []byte) uint64
+	// defaultHash is whether hash is unseeded xxh3.
+	defaultHash bool
+
+	keys    []uint64 // Sorted
+	owners  []string // owners[i] is the item owning keys[i]
+	hashMap map[uint64]string
+	nodes   map[string]bool // items on the ring
 }
 
 func New(replicas int, fn Hash) *Map {
 	m := &Map{
-		replicas: replicas,
-		hash:     fn,
-		hashMap:  make(map[int]string),
+		replicas:    replicas,
+		hash:        xxh3.HashString,
+		hashBytes:   xxh3.Hash,
+		defaultHash: true,
+		hashMap:     make(map[uint64]string),
+		nodes:       make(map[string]bool),
 	}
-	if m.hash == nil {
-		m.hash = xxh3.Hash
+	if fn != nil {
+		m.hash = func(s string) uint64 { return fn([]byte(s)) }
+		m.hashBytes = func(b []byte) uint64 { return fn(append([]byte(nil), b...)) }
+		m.defaultHash = false
 	}
 	return m
 }
 
+// NewSeeded creates a Map hashed with xxh3 using the given seed. Maps
+// with the same seed place keys identically, while different seeds give
+// alternate but reproducible placements.
+func NewSeeded(replicas int, seed uint64) *Map {
+	m := New(replicas, nil)
+	m.hash = func(s string) uint64 { return xxh3.HashStringSeed(s, seed) }
+	m.hashBytes = func(b []byte) uint64 { return xxh3.HashSeed(b, seed) }
+	// xxh3 with a zero seed is the default hash.
+	m.defaultHash = seed == 0
+	return m
+}
+
+// SuggestReplicas estimates how many replicas each of numNodes items
+// needs for the busiest item to get at most maxImbalance times the mean
+// share of keys, for a good hash function.
+//
+// An item's share of the ring is the sum of its replicas' arcs, so its
+// relative standard deviation is about 1/sqrt(replicas). The busiest of
+// numNodes items lies about sqrt(2 ln numNodes) deviations above the
+// mean; one more deviation is added as a margin. It fails if
+// maxImbalance is not greater than 1, or so close to 1 that the number
+// of replicas would overflow an int32.
+func SuggestReplicas(numNodes int, maxImbalance float64) (int, error) {
+	if !(maxImbalance > 1) {
+		return 0, fmt.Errorf("consistenthash: maxImbalance %v is not above 1", maxImbalance)
+	}
+	if numNodes <= 1 {
+		return 1, nil
+	}
+	z := math.Sqrt(2*math.Log(float64(numNodes))) + 1
+	e := maxImbalance - 1
+	replicas := math.Ceil(z * z / (e * e))
+	if replicas > math.MaxInt32 {
+		return 0, fmt.Errorf("consistenthash: maxImbalance %v needs too many replicas", maxImbalance)
+	}
+	return int(replicas), nil
+}
+
+// Replicas returns the number of replica points each item gets on the
+// ring, as passed to New.
+func (m *Map) Replicas() int {
+	return m.replicas
+}
+
+// UsingDefaultHash reports whether the Map was made without a custom
+// Hash, so that it hashes with unseeded xxh3. Such Maps place keys the
+// same as every other one with the same replicas and items.
+func (m *Map) UsingDefaultHash() bool {
+	return m.defaultHash
+}
+
 // Returns true if there are no items available.
 func (m *Map) IsEmpty() bool {
 	return len(m.keys) == 0
 }
 
-// Adds some keys to the hash.
+// Returns the number of replica points on the ring.
+func (m *Map) Len() int {
+	return len(m.keys)
+}
+
+// Adds some keys to the hash. Keys already on the ring are skipped, so
+// adding an item twice does not give it twice the replicas.
 func (m *Map) Add(keys ...string) {
+	var added []Point
+	collided := false
 	for _, key := range keys {
+		if m.nodes[key] {
+			continue
+		}
+		m.nodes[key] = true
 		for i := 0; i < m.replicas; i++ {
-			hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
-			m.keys = append(m.keys, hash)
+			hash := m.hash(strconv.Itoa(i) + key)
+			if _, ok := m.hashMap[hash]; ok {
+				collided = true
+			}
 			m.hashMap[hash] = key
+			added = append(added, Point{Hash: hash, Node: key})
+		}
+	}
+	if len(added) == 0 {
+		return
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i].Hash < added[j].Hash })
+
+	// Merge the new points into the sorted ones, so that adding items one
+	// at a time does not sort the whole ring each time.
+	merged := make([]uint64, 0, len(m.keys)+len(added))
+	owners := make([]string, 0, len(m.keys)+len(added))
+	i, j := 0, 0
+	for i < len(m.keys) || j < len(added) {
+		if j == len(added) || (i < len(m.keys) && m.keys[i] <= added[j].Hash) {
+			merged, owners = append(merged, m.keys[i]), append(owners, m.owners[i])
+			i++
+		} else {
+			merged, owners = append(merged, added[j].Hash), append(owners, added[j].Node)
+			j++
+		}
+	}
+	m.keys, m.owners = merged, owners
+	if collided {
+		// Points sharing a hash all belong to the item added last.
+		m.setOwners()
+	}
+}
+
+// Removes some keys from the hash. Replicas of the remaining keys are
+// left untouched, so only keys owned by the removed items change owner.
+func (m *Map) Remove(keys ...string) {
+	for _, key := range keys {
+		delete(m.nodes, key)
+		for i := 0; i < m.replicas; i++ {
+			hash := m.hash(strconv.Itoa(i) + key)
+			if m.hashMap[hash] == key {
+				delete(m.hashMap, hash)
+			}
+		}
+	}
+	kept, owners := m.keys[:0], m.owners[:0]
+	for _, hash := range m.keys {
+		if owner, ok := m.hashMap[hash]; ok {
+			kept, owners = append(kept, hash), append(owners, owner)
+		}
+	}
+	m.keys, m.owners = kept, owners
+}
+
+// setOwners sets the owner of every point from hashMap.
+func (m *Map) setOwners() {
+	for i, hash := range m.keys {
+		m.owners[i] = m.hashMap[hash]
+	}
+}
+
+// Churn returns the fraction of the hash space, between 0 and 1, whose
+// owner differs between the rings old and new, which approximates the
+// share of keys which would move from one to the other. Both rings must
+// use the same hash function.
+func Churn(old, new *Map) float64 {
+	if old.IsEmpty() || new.IsEmpty() {
+		if old.IsEmpty() && new.IsEmpty() {
+			return 0
+		}
+		return 1
+	}
+	// Between consecutive points of either ring, both rings have a single
+	// owner, which is the owner of the hashes up to the upper point.
+	bounds := make([]uint64, 0, len(old.keys)+len(new.keys))
+	bounds = append(bounds, old.keys...)
+	bounds = append(bounds, new.keys...)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+
+	var moved float64
+	for i, hash := range bounds {
+		// Hashes wrap around, so the first arc starts at the last point.
+		prev := bounds[len(bounds)-1]
+		if i > 0 {
+			prev = bounds[i-1]
+		}
+		if old.GetHashed(hash) != new.GetHashed(hash) {
+			moved += float64(hash - prev)
+		}
+	}
+	return moved / math.Exp2(64)
+}
+
+// NodesInRange returns the distinct items owning some hash in [lo, hi),
+// in ring order from lo, such as to scope a bulk operation to the keys
+// hashing into a range. If hi is not above lo the range wraps around the
+// end of the hash space, so NodesInRange(x, x) returns every item.
+func (m *Map) NodesInRange(lo, hi uint64) []string {
+	if m.IsEmpty() {
+		return nil
+	}
+	// The items owning the range are the owner of lo and the owners of
+	// the hash just past each point within the range, which are the
+	// points which follow it.
+	n := hi - lo
+	type owner struct {
+		dist uint64 // from lo
+		node string
+	}
+	owners := []owner{{0, m.GetHashed(lo)}}
+	for _, hash := range m.keys {
+		if d := hash - lo; n == 0 || d < n-1 {
+			owners = append(owners, owner{d + 1, m.GetHashed(hash + 1)})
 		}
 	}
-	sort.Ints(m.keys)
+	sort.SliceStable(owners, func(i, j int) bool { return owners[i].dist < owners[j].dist })
+	var res []string
+	seen := make(map[string]bool)
+	for _, o := range owners {
+		if !seen[o.node] {
+			seen[o.node] = true
+			res = append(res, o.node)
+		}
+	}
+	return res
+}
+
+// Point is a replica point on the ring.
+type Point struct {
+	Hash uint64 // position on the ring
+	Node string // item owning the keys which hash up to Hash
 }
 
-// Gets the closest item in the hash to the provided key.
+// Returns every replica point in ring order, for debugging how keys are
+// spread over the items.
+func (m *Map) Points() []Point {
+	points := make([]Point, len(m.keys))
+	for i, hash := range m.keys {
+		points[i] = Point{Hash: hash, Node: m.owners[i]}
+	}
+	return points
+}
+
+// Gets the closest item in the hash to the provided key. The key may
+// hold arbitrary bytes; the same bytes always map to the same item.
 func (m *Map) Get(key string) string {
 	if m.IsEmpty() {
 		return ""
 	}
+	return m.GetHashed(m.hash(key))
+}
 
-	hash := int(m.hash([]byte(key)))
+// GetBytes is Get for a key held in a byte slice. It hashes the bytes
+// in place, so it saves converting the key to a string.
+func (m *Map) GetBytes(key []byte) string {
+	if m.IsEmpty() {
+		return ""
+	}
+	return m.GetHashed(m.hashBytes(key))
+}
+
+// GetN returns up to n distinct items for key in ring order: the item
+// Get returns, then the items owning the points which follow it. These
+// are where the key would move to if the items before them were
+// removed, so they make natural replicas of the key.
+func (m *Map) GetN(key string, n int) []string {
+	if m.IsEmpty() || n <= 0 {
+		return nil
+	}
+	if n > len(m.nodes) {
+		n = len(m.nodes)
+	}
+	res := make([]string, 0, n)
+	start := m.search(m.hash(key))
+	for i := 0; i < len(m.keys) && len(res) < n; i++ {
+		node := m.owners[(start+i)%len(m.keys)]
+		if !contains(res, node) {
+			res = append(res, node)
+		}
+	}
+	return res
+}
+
+func contains(nodes []string, node string) bool {
+	for _, n := range nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+// Gets the closest item in the hash to a key whose hash, with the Map's
+// hash function, is keyHash. It saves hashing keys whose hash the caller
+// already computed.
+func (m *Map) GetHashed(keyHash uint64) string {
+	if m.IsEmpty() {
+		return ""
+	}
+	return m.owners[m.search(keyHash)]
+}
 
-	// Binary search for appropriate replica.
-	idx := sort.Search(len(m.keys), func(i int) bool { return m.keys[i] >= hash })
+// search returns the index of the first point at or past keyHash,
+// wrapping around to the first point.
+func (m *Map) search(keyHash uint64) int {
+	// This is sort.Search inlined, as calling its closure at every step
+	// costs more than the comparison on large rings.
+	idx, hi := 0, len(m.keys)
+	for idx < hi {
+		mid := int(uint(idx+hi) >> 1)
+		if m.keys[mid] < keyHash {
+			idx = mid + 1
+		} else {
+			hi = mid
+		}
+	}
 
 	// Means we have cycled back to the first replica.
 	if idx == len(m.keys) {
 		idx = 0
 	}
+	return idx
+}
+
+// snapshotVersion is the first byte of the MarshalBinary encoding, so
+// that later encodings can tell it apart.
+const snapshotVersion = 1
+
+// MarshalBinary encodes the replicas, items and points of the ring, so
+// that UnmarshalBinary can restore the exact same ring without adding
+// the items again in the same order. The hash function is not part of
+// the encoding.
+func (m *Map) MarshalBinary() ([]byte, error) {
+	nodes := make([]string, 0, len(m.nodes))
+	for node := range m.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	index := make(map[string]uint64, len(nodes))
+	for i, node := range nodes {
+		index[node] = uint64(i)
+	}
 
-	return m.hashMap[m.keys[idx]]
+	b := []byte{snapshotVersion}
+	b = binary.AppendUvarint(b, uint64(m.replicas))
+	b = binary.AppendUvarint(b, uint64(len(nodes)))
+	for _, node := range nodes {
+		b = binary.AppendUvarint(b, uint64(len(node)))
+		b = append(b, node...)
+	}
+	b = binary.AppendUvarint(b, uint64(len(m.keys)))
+	for i, hash := range m.keys {
+		owner, ok := index[m.owners[i]]
+		if !ok {
+			return nil, fmt.Errorf("consistenthash: point %d is owned by an item not on the ring", hash)
+		}
+		b = binary.BigEndian.AppendUint64(b, hash)
+		b = binary.AppendUvarint(b, owner)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary replaces the ring of m with the one encoded by
+// MarshalBinary, replicas included. m keeps its hash function, which
+// must be the one of the Map which was encoded for Add and Remove to
+// place items the same; a zero Map hashes with unseeded xxh3, as New
+// does without one.
+func (m *Map) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 || data[0] != snapshotVersion {
+		return errors.New("consistenthash: unknown snapshot encoding")
+	}
+	r := snapshotReader{b: data[1:]}
+	replicas := r.uvarint()
+	nodes := make([]string, r.count())
+	for i := range nodes {
+		nodes[i] = string(r.bytes(r.count()))
+	}
+	keys := make([]uint64, r.count())
+	owners := make([]string, len(keys))
+	for i := range keys {
+		keys[i] = r.uint64()
+		owner := r.uvarint()
+		if r.err == nil && owner >= uint64(len(nodes)) {
+			r.err = errors.New("consistenthash: snapshot point owned by an unknown item")
+		}
+		if r.err == nil {
+			owners[i] = nodes[owner]
+		}
+		if r.err == nil && i > 0 && keys[i] < keys[i-1] {
+			r.err = errors.New("consistenthash: snapshot points out of order")
+		}
+	}
+	if r.err == nil && len(r.b) > 0 {
+		r.err = errors.New("consistenthash: trailing bytes after snapshot")
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	if m.hash == nil {
+		*m = *New(0, nil)
+	}
+	m.replicas = int(replicas)
+	m.keys, m.owners = keys, owners
+	m.hashMap = make(map[uint64]string, len(keys))
+	for i, hash := range keys {
+		m.hashMap[hash] = owners[i]
+	}
+	m.nodes = make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		m.nodes[node] = true
+	}
+	return nil
+}
+
+// snapshotReader decodes a MarshalBinary encoding, keeping the first
+// error so that it is checked once at the end.
+type snapshotReader struct {
+	b   []byte
+	err error
+}
+
+var errShortSnapshot = errors.New("consistenthash: snapshot is truncated")
+
+func (r *snapshotReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = errShortSnapshot
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+// count reads a length, which can't exceed the bytes left since every
+// counted thing takes at least one byte.
+func (r *snapshotReader) count() int {
+	v := r.uvarint()
+	if r.err == nil && v > uint64(len(r.b)) {
+		r.err = errShortSnapshot
+	}
+	if r.err != nil {
+		return 0
+	}
+	return int(v)
+}
+
+func (r *snapshotReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *snapshotReader) uint64() uint64 {
+	if r.err == nil && len(r.b) < 8 {
+		r.err = errShortSnapshot
+	}
+	if r.err != nil {
+		return 0
+	}
+	v := binary.BigEndian.Uint64(r.b)
+	r.b = r.b[8:]
+	return v
 }
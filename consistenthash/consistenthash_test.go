@@ -18,8 +18,12 @@ package consistenthash
 
 import (
 	"fmt"
+	"hash/crc32"
+	"math"
 	"math/rand"
 	"net"
+	"reflect"
+	"sort"
 	"strconv"
 	"testing"
 
@@ -90,6 +94,76 @@ func TestConsistency(t *testing.T) {
 	}
 }
 
+func TestRemove(t *testing.T) {
+	hash1 := New(50, nil)
+	hash2 := New(50, nil)
+
+	hash1.Add("Bill", "Bob", "Bonny")
+	hash1.Remove("Bob")
+	hash2.Add("Bill", "Bonny")
+
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		if got, want := hash1.Get(key), hash2.Get(key); got != want {
+			t.Errorf("Asking for %s, got %s; want %s", key, got, want)
+		}
+	}
+
+	hash1.Remove("Bill", "Bonny")
+	if !hash1.IsEmpty() {
+		t.Errorf("Hash should be empty after removing every key")
+	}
+}
+
+func TestAddTwice(t *testing.T) {
+	once := New(50, nil)
+	once.Add("a", "b", "c")
+	twice := New(50, nil)
+	twice.Add("a", "b")
+	twice.Add("b", "c", "c")
+
+	if !reflect.DeepEqual(twice.Points(), once.Points()) {
+		t.Fatalf("ring after adding items twice has %d points; want the %d of adding them once", twice.Len(), once.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		if got, want := twice.Get(key), once.Get(key); got != want {
+			t.Fatalf("Get(%q) = %q after adding items twice; want %q", key, got, want)
+		}
+	}
+
+	// A removed item can be added back.
+	twice.Remove("b")
+	twice.Add("b")
+	if !reflect.DeepEqual(twice.Points(), once.Points()) {
+		t.Error("ring after removing and adding back an item differs")
+	}
+}
+
+func TestSeeded(t *testing.T) {
+	hosts := []string{"a.svc.local", "b.svc.local", "c.svc.local"}
+	hash1 := NewSeeded(50, 42)
+	hash2 := NewSeeded(50, 42)
+	hash3 := NewSeeded(50, 43)
+	hash1.Add(hosts...)
+	hash2.Add(hosts...)
+	hash3.Add(hosts...)
+
+	var differ int
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		if hash1.Get(key) != hash2.Get(key) {
+			t.Fatalf("Asking for %s, maps with the same seed should agree", key)
+		}
+		if hash1.Get(key) != hash3.Get(key) {
+			differ++
+		}
+	}
+	if differ == 0 {
+		t.Errorf("Maps with different seeds should place some keys differently")
+	}
+}
+
 func TestDistribution(t *testing.T) {
 	hosts := []string{"a.svc.local", "b.svc.local", "c.svc.local"}
 	const cases = 10000
@@ -144,9 +218,508 @@ func benchmarkGet(b *testing.B, shards int) {
 
 	hash.Add(buckets...)
 
+	b.ReportAllocs()
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
 		hash.Get(buckets[i&(shards-1)])
 	}
 }
+
+func TestLargeRing(t *testing.T) {
+	hash := New(512, nil)
+	for i := 0; i < 256; i++ {
+		hash.Add(fmt.Sprintf("node-%d", i))
+	}
+	points := hash.Points()
+	if len(points) != 256*512 {
+		t.Fatalf("ring has %d points; want %d", len(points), 256*512)
+	}
+
+	// Look keys up the way rings did with their points sorted as signed
+	// ints, so that keys keep their owners.
+	signed := make([]int, len(points))
+	owner := make(map[int]string, len(points))
+	for i, p := range points {
+		signed[i] = int(p.Hash)
+		owner[int(p.Hash)] = p.Node
+	}
+	sort.Ints(signed)
+	want := func(keyHash uint64) string {
+		idx := sort.SearchInts(signed, int(keyHash))
+		if idx == len(signed) {
+			idx = 0
+		}
+		return owner[signed[idx]]
+	}
+
+	check := func(keyHash uint64) {
+		t.Helper()
+		if got, want := hash.GetHashed(keyHash), want(keyHash); got != want {
+			t.Errorf("GetHashed(%#x) = %s; want %s", keyHash, got, want)
+		}
+	}
+	for i := 0; i < 100000; i++ {
+		check(xxh3.HashString(strconv.Itoa(i)))
+	}
+	for _, p := range points[:100] {
+		check(p.Hash - 1)
+		check(p.Hash)
+		check(p.Hash + 1)
+	}
+	check(0)
+	check(math.MaxUint64)
+}
+
+// BenchmarkGetLargeRing looks up many distinct keys on a ring of 256
+// items with 512 replicas each, whose points don't fit in the CPU caches.
+func BenchmarkGetLargeRing(b *testing.B) {
+	hash := New(512, nil)
+	for i := 0; i < 256; i++ {
+		hash.Add(fmt.Sprintf("node-%d", i))
+	}
+	keys := make([]uint64, 1<<16)
+	for i := range keys {
+		keys[i] = xxh3.HashString(strconv.Itoa(i))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hash.GetHashed(keys[i&(len(keys)-1)])
+	}
+}
+
+func BenchmarkGetBytes(b *testing.B) {
+	hash := New(50, nil)
+	for i := 0; i < 32; i++ {
+		hash.Add(fmt.Sprintf("shard-%d", i))
+	}
+	key := []byte("a-key-well-over-thirty-two-bytes-long")
+
+	b.Run("Get", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			hash.Get(string(key))
+		}
+	})
+	b.Run("GetBytes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			hash.GetBytes(key)
+		}
+	})
+}
+
+func TestPoints(t *testing.T) {
+	hash := New(3, func(key []byte) uint64 {
+		i, err := strconv.Atoi(string(key))
+		if err != nil {
+			panic(err)
+		}
+		return uint64(i)
+	})
+	hash.Add("4", "2")
+
+	want := []Point{
+		{2, "2"}, {4, "4"}, {12, "2"}, {14, "4"}, {22, "2"}, {24, "4"},
+	}
+	got := hash.Points()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Points() = %v; want %v", got, want)
+	}
+
+	hash.Remove("4")
+	want = []Point{{2, "2"}, {12, "2"}, {22, "2"}}
+	if got := hash.Points(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Points() after Remove = %v; want %v", got, want)
+	}
+}
+
+func TestGetN(t *testing.T) {
+	hash := New(3, func(key []byte) uint64 {
+		i, err := strconv.Atoi(string(key))
+		if err != nil {
+			panic(err)
+		}
+		return uint64(i)
+	})
+	if got := hash.GetN("11", 2); got != nil {
+		t.Errorf("GetN on an empty ring = %q; want nil", got)
+	}
+
+	// Points are 2, 4, 6, 12, 14, 16, 22, 24 and 26.
+	hash.Add("6", "4", "2")
+	tests := []struct {
+		key  string
+		n    int
+		want []string
+	}{
+		{"11", 1, []string{"2"}},
+		{"11", 2, []string{"2", "4"}},
+		{"15", 3, []string{"6", "2", "4"}},
+		// Wraps around and stops at the number of items.
+		{"25", 5, []string{"6", "2", "4"}},
+		{"11", 0, nil},
+	}
+	for _, tt := range tests {
+		if got := hash.GetN(tt.key, tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetN(%q, %d) = %q; want %q", tt.key, tt.n, got, tt.want)
+		}
+		if tt.n > 0 && hash.GetN(tt.key, tt.n)[0] != hash.Get(tt.key) {
+			t.Errorf("GetN(%q, %d) does not start with the owner %s", tt.key, tt.n, hash.Get(tt.key))
+		}
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	ring := NewSeeded(50, 42)
+	for i := 0; i < 20; i++ {
+		ring.Add(fmt.Sprintf("10.0.0.%d:8080", i))
+	}
+	ring.Remove("10.0.0.3:8080", "10.0.0.11:8080")
+	data, err := ring.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewSeeded(1, 42)
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Replicas() != 50 {
+		t.Errorf("restored Replicas = %d; want 50", restored.Replicas())
+	}
+	if !reflect.DeepEqual(restored.Points(), ring.Points()) {
+		t.Error("restored Points differ from the marshaled ring's")
+	}
+	for i := 0; i < 10000; i++ {
+		key := strconv.Itoa(i)
+		if got, want := restored.Get(key), ring.Get(key); got != want {
+			t.Fatalf("restored Get(%q) = %s; want %s", key, got, want)
+		}
+	}
+	// The restored ring keeps changing like the original.
+	ring.Add("10.0.0.3:8080")
+	restored.Add("10.0.0.3:8080")
+	ring.Remove("10.0.0.7:8080")
+	restored.Remove("10.0.0.7:8080")
+	if !reflect.DeepEqual(restored.Points(), ring.Points()) {
+		t.Error("restored Points differ from the original's after the same Add and Remove")
+	}
+
+	// A zero Map hashes like New without a Hash.
+	def := New(10, nil)
+	def.Add("a", "b", "c")
+	if data, err = def.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	}
+	var zero Map
+	if err := zero.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		if got, want := zero.Get(key), def.Get(key); got != want {
+			t.Fatalf("zero Map Get(%q) after UnmarshalBinary = %s; want %s", key, got, want)
+		}
+	}
+
+	for n := 0; n < len(data); n++ {
+		if err := New(1, nil).UnmarshalBinary(data[:n]); err == nil {
+			t.Errorf("UnmarshalBinary of the first %d of %d bytes succeeded", n, len(data))
+		}
+	}
+}
+
+func TestGetHashed(t *testing.T) {
+	hash := New(50, nil)
+	hash.Add("a", "b", "c")
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		if got, want := hash.GetHashed(xxh3.HashString(key)), hash.Get(key); got != want {
+			t.Fatalf("GetHashed(hash(%q)) = %q; Get(%q) = %q", key, got, key, want)
+		}
+	}
+	if got := New(50, nil).GetHashed(1); got != "" {
+		t.Errorf("GetHashed on an empty Map = %q", got)
+	}
+}
+
+// share returns the fraction of the hash space node owns on m.
+func share(m *Map, node string) float64 {
+	points := m.Points()
+	var owned float64
+	for i, p := range points {
+		prev := points[len(points)-1].Hash
+		if i > 0 {
+			prev = points[i-1].Hash
+		}
+		if p.Node == node {
+			owned += float64(p.Hash - prev)
+		}
+	}
+	return owned / math.Exp2(64)
+}
+
+func TestChurn(t *testing.T) {
+	nodes := []string{"a", "b", "c", "d"}
+	old := New(50, nil)
+	old.Add(nodes...)
+
+	same := New(50, nil)
+	same.Add("d", "c", "b", "a")
+	if got := Churn(old, same); got != 0 {
+		t.Errorf("Churn between identical rings = %v; want 0", got)
+	}
+
+	removed := New(50, nil)
+	removed.Add("a", "b", "d")
+	want := share(old, "c")
+	if got := Churn(old, removed); math.Abs(got-want) > 1e-9 {
+		t.Errorf("Churn after removing c = %v; want c's share %v", got, want)
+	}
+	if got := Churn(removed, old); math.Abs(got-want) > 1e-9 {
+		t.Errorf("Churn after adding c = %v; want c's share %v", got, want)
+	}
+	if want < 0.1 || want > 0.4 {
+		t.Errorf("c owns %v of the ring; want about a quarter", want)
+	}
+
+	// Sampled keys move in about the same proportion.
+	moved := 0
+	const n = 100000
+	for i := 0; i < n; i++ {
+		key := strconv.Itoa(i)
+		if old.Get(key) != removed.Get(key) {
+			moved++
+		}
+	}
+	if got := float64(moved) / n; math.Abs(got-want) > 0.01 {
+		t.Errorf("%v of sampled keys moved; want about %v", got, want)
+	}
+
+	if got := Churn(old, New(50, nil)); got != 1 {
+		t.Errorf("Churn to an empty ring = %v; want 1", got)
+	}
+	if got := Churn(New(50, nil), New(50, nil)); got != 0 {
+		t.Errorf("Churn between empty rings = %v; want 0", got)
+	}
+}
+
+func TestIntrospection(t *testing.T) {
+	for _, replicas := range []int{1, 3, 50, 160} {
+		if got := New(replicas, nil).Replicas(); got != replicas {
+			t.Errorf("New(%d, nil).Replicas() = %d", replicas, got)
+		}
+		if got := NewSeeded(replicas, 7).Replicas(); got != replicas {
+			t.Errorf("NewSeeded(%d, 7).Replicas() = %d", replicas, got)
+		}
+	}
+
+	crc := func(key []byte) uint64 { return uint64(crc32.ChecksumIEEE(key)) }
+	for _, tc := range []struct {
+		name string
+		m    *Map
+		want bool
+	}{
+		{"New without a Hash", New(50, nil), true},
+		{"New with a Hash", New(50, crc), false},
+		{"New with xxh3.Hash", New(50, xxh3.Hash), false},
+		{"NewSeeded with seed 0", NewSeeded(50, 0), true},
+		{"NewSeeded with seed 7", NewSeeded(50, 7), false},
+	} {
+		if got := tc.m.UsingDefaultHash(); got != tc.want {
+			t.Errorf("%s: UsingDefaultHash() = %v; want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSuggestReplicas(t *testing.T) {
+	const keys = 200000
+	for _, tc := range []struct {
+		nodes        int
+		maxImbalance float64
+	}{
+		{3, 1.5},
+		{10, 1.25},
+		{50, 1.5},
+	} {
+		replicas, err := SuggestReplicas(tc.nodes, tc.maxImbalance)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash := New(replicas, nil)
+		counts := map[string]int{}
+		for i := 0; i < tc.nodes; i++ {
+			node := fmt.Sprintf("10.0.%d.%d:8080", i/256, i%256)
+			hash.Add(node)
+			counts[node] = 0
+		}
+		for i := 0; i < keys; i++ {
+			counts[hash.Get(strconv.Itoa(i))]++
+		}
+		var max int
+		for _, n := range counts {
+			if n > max {
+				max = n
+			}
+		}
+		mean := float64(keys) / float64(tc.nodes)
+		if got := float64(max) / mean; got > tc.maxImbalance {
+			t.Errorf("%d nodes with %d replicas: imbalance %.3f exceeds %.3f", tc.nodes, replicas, got, tc.maxImbalance)
+		}
+	}
+
+	if got, err := SuggestReplicas(1, 1.1); got != 1 || err != nil {
+		t.Errorf("SuggestReplicas(1, 1.1) = %d, %v; want 1", got, err)
+	}
+	a, _ := SuggestReplicas(10, 1.1)
+	b, _ := SuggestReplicas(10, 1.5)
+	if a <= b {
+		t.Errorf("a tighter bound should need more replicas; got %d and %d", a, b)
+	}
+	for _, maxImbalance := range []float64{1, 0.5, -1, math.NaN(), 1 + 1e-12} {
+		if got, err := SuggestReplicas(10, maxImbalance); err == nil {
+			t.Errorf("SuggestReplicas(10, %v) = %d; want an error", maxImbalance, got)
+		}
+	}
+}
+
+func TestGetDoesNotCopy(t *testing.T) {
+	inPlace := New(50, nil)
+	copying := New(50, xxh3.Hash)
+	seeded := NewSeeded(50, 42)
+	copyingSeeded := New(50, func(data []byte) uint64 { return xxh3.HashSeed(data, 42) })
+	for _, m := range []*Map{inPlace, copying, seeded, copyingSeeded} {
+		m.Add("a", "b", "c", "d")
+	}
+
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("some-longer-key-%d", i)
+		if got, want := inPlace.Get(key), copying.Get(key); got != want {
+			t.Fatalf("Get(%q) = %q; the copying path gives %q", key, got, want)
+		}
+		if got, want := seeded.Get(key), copyingSeeded.Get(key); got != want {
+			t.Fatalf("seeded Get(%q) = %q; the copying path gives %q", key, got, want)
+		}
+	}
+
+	key := "a-key-well-over-thirty-two-bytes-long"
+	if n := testing.AllocsPerRun(100, func() { inPlace.Get(key) }); n != 0 {
+		t.Errorf("Get made %v allocations; want 0", n)
+	}
+}
+
+func TestGetBytes(t *testing.T) {
+	maps := map[string]*Map{
+		"default": New(50, nil),
+		"custom":  New(50, func(data []byte) uint64 { return uint64(crc32.ChecksumIEEE(data)) }),
+		"seeded":  NewSeeded(50, 42),
+	}
+	for name, m := range maps {
+		if got := m.GetBytes([]byte("key")); got != "" {
+			t.Errorf("%s: GetBytes on an empty ring = %q", name, got)
+		}
+		m.Add("a", "b", "c", "d")
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			if got, want := m.GetBytes([]byte(key)), m.Get(key); got != want {
+				t.Fatalf("%s: GetBytes(%q) = %q; Get gives %q", name, key, got, want)
+			}
+		}
+	}
+
+	key := []byte("a-key-well-over-thirty-two-bytes-long")
+	if n := testing.AllocsPerRun(100, func() { maps["default"].GetBytes(key) }); n != 0 {
+		t.Errorf("GetBytes made %v allocations; want 0", n)
+	}
+}
+
+func TestBinaryKeys(t *testing.T) {
+	build := func() map[string]*Map {
+		maps := map[string]*Map{
+			"default": New(50, nil),
+			"custom":  New(50, func(data []byte) uint64 { return uint64(crc32.ChecksumIEEE(data)) }),
+			"seeded":  NewSeeded(50, 42),
+		}
+		for _, m := range maps {
+			m.Add("a", "b", "c", "d")
+		}
+		return maps
+	}
+	maps, again := build(), build()
+
+	r := rand.New(rand.NewSource(1))
+	keys := [][]byte{nil, {0}, {0xff, 0xfe, 0xfd}, []byte("\xc3\x28 invalid utf-8")}
+	for i := 0; i < 1000; i++ {
+		key := make([]byte, r.Intn(64))
+		r.Read(key)
+		keys = append(keys, key)
+	}
+	for name, m := range maps {
+		for _, key := range keys {
+			got := m.Get(string(key))
+			if got == "" {
+				t.Fatalf("%s: Get(%q) found no item", name, key)
+			}
+			if repeat := m.Get(string(key)); repeat != got {
+				t.Fatalf("%s: Get(%q) = %q, then %q", name, key, got, repeat)
+			}
+			if b := m.GetBytes(key); b != got {
+				t.Fatalf("%s: GetBytes(%q) = %q; Get gives %q", name, key, b, got)
+			}
+			if other := again[name].Get(string(key)); other != got {
+				t.Fatalf("%s: Get(%q) = %q on an identical map; want %q", name, key, other, got)
+			}
+		}
+	}
+}
+
+func TestNodesInRange(t *testing.T) {
+	hash := New(3, func(key []byte) uint64 {
+		i, err := strconv.Atoi(string(key))
+		if err != nil {
+			panic(err)
+		}
+		return uint64(i)
+	})
+	if got := hash.NodesInRange(0, 10); got != nil {
+		t.Errorf("NodesInRange on an empty ring = %q", got)
+	}
+
+	// Points 2, 4, 6, 12, 14, 16, 22, 24, 26, so "2" owns (26, 2] and
+	// (6, 12], "4" owns (2, 4] and so on.
+	hash.Add("6", "4", "2")
+	tests := []struct {
+		lo, hi uint64
+		want   []string
+	}{
+		{0, 1, []string{"2"}},
+		{3, 5, []string{"4"}},
+		{3, 6, []string{"4", "6"}},
+		{5, 13, []string{"6", "2"}},
+		{5, 14, []string{"6", "2", "4"}},
+		{7, 13, []string{"2"}},
+		{27, 3, []string{"2"}},
+		{27, 4, []string{"2", "4"}},
+		{9, 9, []string{"2", "4", "6"}},
+	}
+	for _, tt := range tests {
+		got := hash.NodesInRange(tt.lo, tt.hi)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("NodesInRange(%d, %d) = %q; want %q", tt.lo, tt.hi, got, tt.want)
+		}
+		// Every hash in the range is owned by one of the nodes.
+		for h := tt.lo; h != tt.hi && h < tt.lo+40; h++ {
+			owner := hash.GetHashed(h)
+			found := false
+			for _, node := range got {
+				found = found || node == owner
+			}
+			if !found {
+				t.Errorf("NodesInRange(%d, %d) = %q misses %s, owning %d", tt.lo, tt.hi, got, owner, h)
+			}
+		}
+	}
+}
@@ -32,3 +32,142 @@ func (e *ErrRemoteCall) Is(target error) bool {
 	_, ok := target.(*ErrRemoteCall)
 	return ok
 }
+
+// ErrNoGetter is returned from `group.Get()` when a value must be loaded
+// locally but the group was created without a `Getter`.
+type ErrNoGetter struct {
+	Msg string
+}
+
+func (e *ErrNoGetter) Error() string {
+	return e.Msg
+}
+
+func (e *ErrNoGetter) Is(target error) bool {
+	_, ok := target.(*ErrNoGetter)
+	return ok
+}
+
+// ErrCircuitOpen is returned from `group.Get()` when the group's circuit
+// breaker is open because the `Getter` kept failing. The `Getter` is not
+// called while the breaker is open.
+type ErrCircuitOpen struct {
+	Msg string
+}
+
+func (e *ErrCircuitOpen) Error() string {
+	return e.Msg
+}
+
+func (e *ErrCircuitOpen) Is(target error) bool {
+	_, ok := target.(*ErrCircuitOpen)
+	return ok
+}
+
+// ErrResponseTooLarge is returned when a peer response is larger than
+// HTTPPoolOptions.MaxResponseBytes. The rest of the response is not read.
+type ErrResponseTooLarge struct {
+	Msg string
+}
+
+func (e *ErrResponseTooLarge) Error() string {
+	return e.Msg
+}
+
+func (e *ErrResponseTooLarge) Is(target error) bool {
+	_, ok := target.(*ErrResponseTooLarge)
+	return ok
+}
+
+// ErrStaleVersion is returned from `group.Set()` when the value is older
+// than the version of the key already cached by its owner. The cached
+// value is kept.
+type ErrStaleVersion struct {
+	Msg string
+}
+
+func (e *ErrStaleVersion) Error() string {
+	return e.Msg
+}
+
+func (e *ErrStaleVersion) Is(target error) bool {
+	_, ok := target.(*ErrStaleVersion)
+	return ok
+}
+
+// ErrGroupClosed is returned from `group.Get()` and the other methods
+// of a group once `group.Close()` was called.
+type ErrGroupClosed struct {
+	Msg string
+}
+
+func (e *ErrGroupClosed) Error() string {
+	return e.Msg
+}
+
+func (e *ErrGroupClosed) Is(target error) bool {
+	_, ok := target.(*ErrGroupClosed)
+	return ok
+}
+
+// ErrKeyTooLong is returned from `group.Get()` and `group.Set()` when
+// the key is longer than `GroupOptions.MaxKeyBytes`.
+type ErrKeyTooLong struct {
+	Msg string
+}
+
+func (e *ErrKeyTooLong) Error() string {
+	return e.Msg
+}
+
+func (e *ErrKeyTooLong) Is(target error) bool {
+	_, ok := target.(*ErrKeyTooLong)
+	return ok
+}
+
+// ErrChecksum is returned when the value in a peer response does not
+// match its checksum, see `HTTPPoolOptions.Checksum`. The value is not
+// cached.
+type ErrChecksum struct {
+	Msg string
+}
+
+func (e *ErrChecksum) Error() string {
+	return e.Msg
+}
+
+func (e *ErrChecksum) Is(target error) bool {
+	_, ok := target.(*ErrChecksum)
+	return ok
+}
+
+// ErrDuplicateGroup is returned by `TryNewGroup` when a group of the same
+// name is already registered.
+type ErrDuplicateGroup struct {
+	Msg string
+}
+
+func (e *ErrDuplicateGroup) Error() string {
+	return e.Msg
+}
+
+func (e *ErrDuplicateGroup) Is(target error) bool {
+	_, ok := target.(*ErrDuplicateGroup)
+	return ok
+}
+
+// ErrLoadWaitTimeout is returned from `group.Get()` when the key is being
+// loaded by another caller and the load did not finish within
+// `GroupOptions.LoadWaitTimeout`. The other caller's load goes on.
+type ErrLoadWaitTimeout struct {
+	Msg string
+}
+
+func (e *ErrLoadWaitTimeout) Error() string {
+	return e.Msg
+}
+
+func (e *ErrLoadWaitTimeout) Is(target error) bool {
+	_, ok := target.(*ErrLoadWaitTimeout)
+	return ok
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"sync"
+
+	"github.com/xdbbe/groupcache/v2/lru"
+)
+
+// maxFetcherKeys bounds how many keys a fetcherTracker remembers the
+// fetchers of, so that tracking costs a bounded amount of memory.
+const maxFetcherKeys = 1 << 16
+
+// fetcherTracker remembers which peers fetched each key from its owner,
+// and so likely hold a copy of it in their hot cache, see
+// GroupOptions.PushInvalidations.
+type fetcherTracker struct {
+	// perKey is the most peers remembered for a key.
+	perKey int
+
+	mu   sync.Mutex
+	keys *lru.Cache // of *fetcherSet
+}
+
+// fetcherSet holds the fetchers of a key. Overflow is set once more
+// than perKey peers fetched it, since they can no longer all be named.
+type fetcherSet struct {
+	peers    map[string]bool
+	overflow bool
+}
+
+func newFetcherTracker(perKey int) *fetcherTracker {
+	return &fetcherTracker{perKey: perKey, keys: lru.New(maxFetcherKeys)}
+}
+
+// add records that the peer with ID peer fetched key.
+func (t *fetcherTracker) add(key, peer string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var s *fetcherSet
+	if v, ok := t.keys.Get(key); ok {
+		s = v.(*fetcherSet)
+	} else {
+		s = &fetcherSet{peers: make(map[string]bool)}
+		t.keys.Add(key, s)
+	}
+	if s.overflow || s.peers[peer] {
+		return
+	}
+	if len(s.peers) == t.perKey {
+		s.overflow = true
+		s.peers = nil
+		return
+	}
+	s.peers[peer] = true
+}
+
+// take forgets and returns the fetchers of key. all is true if they are
+// unknown, because more fetchers were seen than could be remembered or
+// the key was never recorded or was forgotten, in which case every peer
+// may hold a copy.
+func (t *fetcherTracker) take(key string) (peers []string, all bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	v, ok := t.keys.Peek(key)
+	if !ok {
+		return nil, true
+	}
+	t.keys.Remove(key)
+	s := v.(*fetcherSet)
+	for peer := range s.peers {
+		peers = append(peers, peer)
+	}
+	return peers, s.overflow
+}
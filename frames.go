@@ -0,0 +1,95 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// framedContentType is the content type of multi-get responses, which
+// stream one record per key so values can be used as they arrive.
+//
+// A record is the key, a status byte and a payload, where the key and
+// the payload are each prefixed by their length as a uvarint. The
+// payload of a frameValue record is a marshaled GetResponse; for the
+// other statuses it is the error message. Keys are answered in the
+// order they were requested, and keys without a record were not
+// answered because the response ended early.
+const framedContentType = "application/x-groupcache-frames"
+
+const (
+	frameValue byte = iota
+	frameNotFound
+	frameError
+)
+
+// writeRecord writes a record of a framed response to w.
+func writeRecord(w *bufio.Writer, key string, status byte, payload []byte) error {
+	var n [binary.MaxVarintLen64]byte
+	if _, err := w.Write(n[:binary.PutUvarint(n[:], uint64(len(key)))]); err != nil {
+		return err
+	}
+	if _, err := w.WriteString(key); err != nil {
+		return err
+	}
+	if err := w.WriteByte(status); err != nil {
+		return err
+	}
+	if _, err := w.Write(n[:binary.PutUvarint(n[:], uint64(len(payload)))]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readRecord reads a record of a framed response from r. It returns
+// io.EOF at the end of the response. A payload longer than max, if
+// positive, fails with ErrResponseTooLarge.
+func readRecord(r *bufio.Reader, max int64) (key string, status byte, payload []byte, err error) {
+	k, err := readFrame(r, max)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	if status, err = r.ReadByte(); err != nil {
+		return "", 0, nil, io.ErrUnexpectedEOF
+	}
+	if payload, err = readFrame(r, max); err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return string(k), status, payload, err
+}
+
+func readFrame(r *bufio.Reader, max int64) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if max > 0 && n > uint64(max) {
+		return nil, &ErrResponseTooLarge{Msg: fmt.Sprintf("groupcache: framed record of %d bytes exceeds %d bytes", n, max)}
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/xdbbe/groupcache/v2/groupcachepb"
+)
+
+const defaultMaxGossipPeers = 1024
+
+// watchGossip periodically learns peers from the pool's peers until the
+// pool is closed.
+func (p *HTTPPool) watchGossip() {
+	defer p.done.Done()
+	t := time.NewTicker(p.opts.GossipInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			ctx, cancel := context.WithTimeout(context.Background(), p.opts.GossipInterval)
+			p.gossip(ctx)
+			cancel()
+		case <-p.closed:
+			return
+		}
+	}
+}
+
+// gossip asks every other peer of the pool for its peers and adds the
+// ones the pool does not know of, up to MaxGossipPeers. Peers which fail
+// to answer are skipped until the next round.
+func (p *HTTPPool) gossip(ctx context.Context) {
+	p.mu.Lock()
+	known := make(map[string]bool, len(p.httpGetters))
+	var getters []*httpGetter
+	for peer, h := range p.httpGetters {
+		known[peer] = true
+		if !p.isSelf(peer) {
+			getters = append(getters, h)
+		}
+	}
+	p.mu.Unlock()
+
+	learned := make(map[string]bool)
+	for _, h := range getters {
+		var res pb.PeersResponse
+		if err := h.Peers(ctx, &res); err != nil {
+			continue
+		}
+		for _, peer := range res.Peers {
+			if !known[peer] {
+				learned[peer] = true
+			}
+		}
+	}
+	if len(learned) == 0 {
+		return
+	}
+	// Past the limit, every pool keeps the lexically smallest peers, so
+	// that they still agree on which ones.
+	added := make([]string, 0, len(learned))
+	for peer := range learned {
+		added = append(added, peer)
+	}
+	sort.Strings(added)
+	p.addPeers(added)
+}
+
+// addPeers adds the peers which are not in the pool yet, as long as it
+// has fewer than MaxGossipPeers.
+func (p *HTTPPool) addPeers(peers []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var added []string
+	for _, peer := range peers {
+		if len(p.httpGetters) >= p.opts.MaxGossipPeers {
+			break
+		}
+		if _, ok := p.httpGetters[peer]; ok {
+			continue
+		}
+		p.httpGetters[peer] = p.newHTTPGetter(peer)
+		added = append(added, peer)
+	}
+	p.peers.Add(added...)
+}
+
+// servePeers answers a gossip request with the peers of the pool.
+func (p *HTTPPool) servePeers(w http.ResponseWriter, r *http.Request) {
+	p.serveResponse(w, r, &pb.PeersResponse{Peers: p.Peers()})
+}
+
+// Peers fetches the peers the peer's pool knows of, if it gossips.
+func (h *httpGetter) Peers(ctx context.Context, out *pb.PeersResponse) error {
+	var res http.Response
+	if err := h.do(ctx, http.MethodGet, h.baseURL, nil, nil, &res); err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024*1024))
+		return fmt.Errorf("server returned: %v, %v", res.Status, string(msg))
+	}
+	b := bufferPool.Get().(*bytes.Buffer)
+	b.Reset()
+	defer bufferPool.Put(b)
+	if err := h.readBody(&res, b); err != nil {
+		return err
+	}
+	if err := proto.Unmarshal(b.Bytes(), out); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+	return nil
+}
@@ -25,17 +25,25 @@ limitations under the License.
 package groupcache
 
 import (
+	"container/list"
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/sirupsen/logrus"
 	pb "github.com/xdbbe/groupcache/v2/groupcachepb"
 	"github.com/xdbbe/groupcache/v2/lru"
 	"github.com/xdbbe/groupcache/v2/singleflight"
+	"github.com/zeebo/xxh3"
 )
 
 var logger Logger
@@ -61,6 +69,18 @@ type Getter interface {
 	Get(ctx context.Context, key string, dest Sink) error
 }
 
+// MessageGetterFunc implements Getter with a function which loads values
+// as proto messages. Each message is marshaled once, with SetProto.
+type MessageGetterFunc func(ctx context.Context, key string) (proto.Message, error)
+
+func (f MessageGetterFunc) Get(ctx context.Context, key string, dest Sink) error {
+	m, err := f(ctx, key)
+	if err != nil {
+		return err
+	}
+	return dest.SetProto(m)
+}
+
 // A GetterFunc implements Getter with a function.
 type GetterFunc func(ctx context.Context, key string, dest Sink) error
 
@@ -68,6 +88,19 @@ func (f GetterFunc) Get(ctx context.Context, key string, dest Sink) error {
 	return f(ctx, key, dest)
 }
 
+// A GetterMiddleware wraps a Getter with extra behaviour, such as
+// metrics, logging or retries.
+type GetterMiddleware func(Getter) Getter
+
+// Chain wraps base with the middlewares. The first middleware is the
+// outermost, so it sees each load first and its result last.
+func Chain(base Getter, middlewares ...GetterMiddleware) Getter {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		base = middlewares[i](base)
+	}
+	return base
+}
+
 var (
 	mu     sync.RWMutex
 	groups = make(map[string]*Group)
@@ -85,6 +118,33 @@ func GetGroup(name string) *Group {
 	return g
 }
 
+// GroupNames returns the sorted names of all registered groups.
+func GroupNames() []string {
+	mu.RLock()
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// EachGroup calls fn with each registered group in name order. Groups
+// registered or deregistered while it runs may or may not be visited.
+func EachGroup(fn func(*Group)) {
+	mu.RLock()
+	list := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		list = append(list, g)
+	}
+	mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	for _, g := range list {
+		fn(g)
+	}
+}
+
 // NewGroup creates a coordinated group-aware Getter from a Getter.
 //
 // The returned Getter tries (but does not guarantee) to run only one
@@ -98,6 +158,301 @@ func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
 	return newGroup(name, cacheBytes, getter, nil)
 }
 
+// TryNewGroup is like NewGroup, except that it returns ErrDuplicateGroup
+// instead of panicking if a group named name already exists, so that
+// libraries which can't rule out being set up twice may use GetGroup to
+// reuse the existing group instead.
+func TryNewGroup(name string, cacheBytes int64, getter Getter) (*Group, error) {
+	return tryNewGroupOpts(name, cacheBytes, getter, nil, nil)
+}
+
+// GroupOptions are the configurations of a Group.
+type GroupOptions struct {
+	// LocalOnly makes the group always load keys locally, skipping
+	// the PeerPicker entirely. Groups also run local only when no
+	// PeerPicker has been registered.
+	LocalOnly bool
+
+	// Peers, if non-nil, is the PeerPicker used by the group instead of
+	// the registered one.
+	Peers PeerPicker
+
+	// AdmissionFilter enables a TinyLFU style admission filter on the
+	// main and hot caches. When caching a loaded value would evict an
+	// entry, the value is only cached if its key was requested more
+	// often than the key of the entry it would evict. This keeps keys
+	// which are only requested once from pushing out popular ones.
+	AdmissionFilter bool
+
+	// BreakerThreshold enables a circuit breaker around the Getter when
+	// positive. After BreakerThreshold consecutive Getter failures
+	// within BreakerWindow, loads fail fast with ErrCircuitOpen for
+	// BreakerCooldown. A single load is then let through to probe the
+	// Getter, closing the breaker when it succeeds. ErrNotFound and
+	// canceled contexts are not counted as failures.
+	BreakerThreshold int
+
+	// BreakerWindow specifies how close together failures must be to
+	// count as consecutive. If blank, it defaults to 10 seconds.
+	BreakerWindow time.Duration
+
+	// BreakerCooldown specifies how long the breaker stays open.
+	// If blank, it defaults to 5 seconds.
+	BreakerCooldown time.Duration
+
+	// OnEvict, if non-nil, is called each time an entry leaves the
+	// main or hot cache, with the cache type ("main" or "hot"), the key
+	// and the number of bytes the entry accounted for. It is best
+	// effort: it runs while the cache is locked, so it must return
+	// quickly and must not call back into the Group.
+	OnEvict func(cacheType, key string, bytes int)
+
+	// DeregisterOnClose makes Close deregister the group, so that a
+	// new group of the same name may be created.
+	DeregisterOnClose bool
+
+	// Weight, if non-nil, returns how costly a value is to reload, so
+	// that eviction favors keeping costly values over cheap ones of any
+	// size. The eviction hand passes over an entry of weight n another
+	// n-1 times before evicting it; weights below 2 are evicted as
+	// usual. The byte budget still only counts keys and values.
+	Weight func(key string, value ByteView) int
+
+	// Derive, if non-nil, computes a small blob from each value when it
+	// is cached, such as a field extracted from a large document, which
+	// GetDerived returns without handing the value to its caller. It is
+	// cached along with the value, so it is computed once per value, but
+	// it is not counted against cacheBytes.
+	Derive func(key string, value ByteView) []byte
+
+	// Tags, if non-nil, returns the tags of each value when it is
+	// cached, such as its tenant, region or feature flag. InvalidateTag
+	// removes every entry with a tag at once, for sets of keys which do
+	// not share a prefix. Tags are indexed as entries are added, so this
+	// costs memory per tag of each entry.
+	Tags func(key string, value ByteView) []string
+
+	// Validate, if non-nil, checks each value loaded by the Getter or
+	// FallbackGetter before it is cached. If it returns an error, the
+	// value is neither cached nor returned and the load fails with that
+	// error, so a corrupt value from the source is not served to peers.
+	Validate func(key string, value []byte) error
+
+	// DisableSingleflight makes every cache miss load the key on its
+	// own instead of waiting for a concurrent load of the same key.
+	// This is only worth it for Getters that are cheaper than the
+	// coordination, such as in-memory computations. Removals still wait
+	// for the loads running, so the Getter must not remove keys of its
+	// own group.
+	DisableSingleflight bool
+
+	// MaxPeerFetches, if positive, caps how many requests to peers the
+	// group makes at once, across all peers. Further requests wait for
+	// one to finish, or for their context to be done.
+	MaxPeerFetches int
+
+	// HedgeDelay, if positive, makes a fetch from the peer owning a key
+	// which has not answered within HedgeDelay also ask the next peer on
+	// the ring, which may hold a copy in its hot cache. The first value
+	// to arrive is used and the other request is canceled, trading the
+	// extra requests for a shorter tail latency. It needs a Peers picker
+	// implementing ReplicaPicker, such as HTTPPool.
+	HedgeDelay time.Duration
+
+	// MaxFlights, if positive, caps how many distinct keys the group
+	// loads at once, whether with the Getter or from peers, so a burst
+	// of misses of distinct keys can't fan out into as many loads.
+	// Gets of further keys wait for a load to finish, or fail with the
+	// error of their context once it is done. Gets of a key already
+	// being loaded wait on that load without taking another.
+	MaxFlights int
+
+	// LoadWaitTimeout, if positive, bounds how long a Get waits on a
+	// load of the same key by another caller, separately from the
+	// deadline of its context. Once it passes, the Get serves a stale
+	// value as allowed by MaxStale or fails with ErrLoadWaitTimeout,
+	// while the other caller's load goes on. Gets which load the key
+	// themselves are not bounded by it. It has no effect with
+	// DisableSingleflight.
+	LoadWaitTimeout time.Duration
+
+	// OnLoad, if non-nil, is called after a successful load of a key
+	// which wasn't cached, with how long the load took and whether the
+	// Getter or a peer loaded it, to find keys that are slow to load.
+	// It runs on the loading goroutine, so it should return quickly.
+	OnLoad func(key string, d time.Duration, source LoadSource)
+
+	// OnLoadSampling, if above 1, makes OnLoad only be called for one
+	// in every OnLoadSampling loads, to bound its overhead.
+	OnLoadSampling int
+
+	// OnAccess, if non-nil, is called with every Get of a key once it
+	// returns, whether it hit or failed, such as to audit who reads
+	// sensitive keys. It is called by Get and the methods built on it,
+	// such as GetString, GetWithTTL, GetStale, GetRange and
+	// GetIfModified, and by GetInto, on the goroutine of the Get, so it
+	// should return quickly. GetMulti and GetMultiPartial report each of
+	// their keys, those fetched in batches from the goroutine of their
+	// batch. Gets failing their argument checks are not reported.
+	OnAccess func(AccessEvent)
+
+	// MaxKeyBytes, if positive, is the longest key the group accepts.
+	// Gets and Sets of longer keys fail with ErrKeyTooLong before the
+	// key is hashed or loaded.
+	MaxKeyBytes int
+
+	// MainCachePolicy and HotCachePolicy, if non-nil, choose which
+	// entries the main and hot caches evict, instead of evicting the
+	// least recently used. Each cache needs its own EvictionPolicy.
+	MainCachePolicy EvictionPolicy
+	HotCachePolicy  EvictionPolicy
+
+	// CacheShards, if above 1, splits the main and hot caches into that
+	// many shards by key hash, each with its own lock, so that gets and
+	// adds of keys of different shards don't contend. The group's byte
+	// budget stays shared: eviction picks its victims from the shard
+	// holding the most bytes, so each keeps about its share. Tenant
+	// budgets are divided between the shards. Key listings are in no
+	// particular order. It can't be combined with MainCachePolicy or
+	// HotCachePolicy, whose single policy would be shared by the shards.
+	CacheShards int
+
+	// KeyFingerprint, if non-nil, maps keys to the shorter keys their
+	// entries are stored under in the main and hot caches, such as
+	// FingerprintKey, so that very long keys take less memory and count
+	// less against cacheBytes. Keys whose fingerprints collide share an
+	// entry. Peers are still picked by the full key, and Pin and Tenant
+	// are given it too. Range, OnEvict and eviction policies see the
+	// fingerprints. RemoveByPrefix and WarmFromPeers need the full keys
+	// of the cached entries, so they fail on such a group, and its peers
+	// refuse to list or remove its keys by prefix.
+	KeyFingerprint func(key string) string
+
+	// Tenant, if non-nil, returns the tenant owning a key. The entries
+	// of each tenant in the main cache are limited to the budget given
+	// for it by TenantBytes, and DefaultTenantBytes for tenants it
+	// doesn't list, so that one tenant can't evict the others' entries.
+	// A tenant over its budget loses its own least recently used
+	// entries. Budgets of zero leave tenants bounded by the group's
+	// cacheBytes only.
+	Tenant             func(key string) string
+	TenantBytes        map[string]int64
+	DefaultTenantBytes int64
+
+	// HotCachePeerShare, if between 0 and 1, limits the hot cache
+	// entries fetched from each peer to that fraction of the hot cache's
+	// share of cacheBytes, which is a ninth since the hot cache is kept
+	// to an eighth of the main cache. A peer serving a flood of distinct
+	// keys then loses its own least recently added entries rather than
+	// evicting those of other peers.
+	HotCachePeerShare float64
+
+	// HotCacheRate, if between 0 and 1, is the fraction of the values
+	// fetched from peers which enter the hot cache, drawn with Rand.
+	// Otherwise every one of them does.
+	HotCacheRate float64
+
+	// HotCacheMinFetches, if above 1, keeps the values fetched from peers
+	// out of the hot cache until their key was fetched that many times,
+	// so that a burst of keys read once doesn't evict the hot ones.
+	// Fetches are counted for a bounded number of recently fetched keys.
+	HotCacheMinFetches int
+
+	// TrackEntryInfo makes cache entries remember the peer they were
+	// fetched from, for debugging with EntryInfo. It is off by default
+	// to keep entries small.
+	TrackEntryInfo bool
+
+	// LocalFallback makes a Get of a key whose owner fails to return it
+	// load the key with the local Getter even if the owner reported that
+	// its own load failed, and cache the value in the hot cache as the
+	// key belongs to another peer. This favors availability over loading
+	// each key once. Without it, only failures to reach the owner fall
+	// back to the local Getter, and the value is cached in the main
+	// cache. ErrNotFound and context errors are never retried locally.
+	LocalFallback bool
+
+	// CoalesceWindow, if positive, is how long the value of a completed
+	// load is reused by further misses of the key. Singleflight only
+	// merges loads which overlap, so this also covers misses arriving
+	// just after a load, such as of values which aren't cached. Set and
+	// Remove drop the reused value. Failed loads are not reused.
+	CoalesceWindow time.Duration
+
+	// TTL, if positive, is how long values stay cached. Expired values
+	// are dropped when they are next looked up, so the key is loaded
+	// again.
+	TTL time.Duration
+
+	// NegativeTTL, if positive, makes the group remember for that long
+	// the keys whose load failed with ErrNotFound, and fail Gets of them
+	// with the same error instead of loading them again. It applies to
+	// missing keys what TTL applies to values, so each can be set to
+	// suit: missing keys usually deserve a shorter lifetime. Set and
+	// Remove forget that a key was missing.
+	NegativeTTL time.Duration
+
+	// TTLJitter, if between 0 and 1, moves the expiry of each value by
+	// a random amount of up to that fraction of its TTL, earlier or
+	// later, when the value is cached. Values loaded together then
+	// expire over a window instead of all at once, so their reloads
+	// don't come in a burst.
+	TTLJitter float64
+
+	// SlidingTTL makes every cache hit extend the value's life by TTL,
+	// as Touch does, so that only keys which go unread expire.
+	SlidingTTL bool
+
+	// MaxStale, if positive, keeps values for up to MaxStale after they
+	// expire, so that when reloading an expired key fails the expired
+	// value is returned instead of the error. ErrNotFound is returned
+	// as is, since the key is gone rather than unavailable.
+	MaxStale time.Duration
+
+	// HashSeed seeds the xxh3 hashes the group computes of keys and
+	// values, which are the ETags of its values and the key hashes of
+	// its admission filter. Groups with different seeds give identical
+	// values different ETags. Peers of a group should use the same seed,
+	// so that conditional gets between them match.
+	HashSeed uint64
+
+	// FallbackGetter, if non-nil, loads keys which the Getter failed to
+	// load, such as from a slower secondary store, while the breaker is
+	// open too. Its values are cached like those of the Getter. When it
+	// fails as well, the load fails with an error wrapping the Getter's
+	// error and naming the FallbackGetter's. Loads whose context is done
+	// are not retried.
+	FallbackGetter Getter
+
+	// PushInvalidations, if positive, makes the owner of a key remember
+	// up to that many peers which fetched it, and so likely hold a copy
+	// in their hot cache. When the owner sets or removes the key it
+	// removes the key from just those peers, so Remove no longer asks
+	// every peer, and Set reaches the hot caches of fetchers too. Peers
+	// which set a key through its owner count as fetchers, since they
+	// may keep a hot copy. Fetchers are only known for peers which
+	// identify themselves, as HTTPPool and InProcessPicker do, and only
+	// for a bounded number of keys; a key fetched by more peers, or whose
+	// fetchers are not known, is removed from all of them. RemovePrefix
+	// still asks every peer.
+	PushInvalidations int
+
+	// Clock returns the current time. All time dependent features of
+	// the group, such as TTLs, use it. If nil, it defaults to time.Now.
+	Clock func() time.Time
+
+	// Rand returns a pseudo-random number in [0.0,1.0). All random
+	// features of the group, such as TTLJitter, use it, so that tests
+	// can make them reproducible. If nil, it defaults to rand.Float64.
+	Rand func() float64
+}
+
+// NewGroupWithOptions creates a coordinated group-aware Getter from a
+// Getter with the given options. See NewGroup.
+func NewGroupWithOptions(name string, cacheBytes int64, getter Getter, o *GroupOptions) *Group {
+	return newGroupOpts(name, cacheBytes, getter, nil, o)
+}
+
 // DeregisterGroup removes group from group pool
 func DeregisterGroup(name string) {
 	mu.Lock()
@@ -106,30 +461,127 @@ func DeregisterGroup(name string) {
 }
 
 // If peers is nil, the peerPicker is called via a sync.Once to initialize it.
+// A nil getter is allowed; loads which cannot be served by a peer then
+// fail with ErrNoGetter.
 func newGroup(name string, cacheBytes int64, getter Getter, peers PeerPicker) *Group {
-	if getter == nil {
-		panic("nil Getter")
+	return newGroupOpts(name, cacheBytes, getter, peers, nil)
+}
+
+func newGroupOpts(name string, cacheBytes int64, getter Getter, peers PeerPicker, o *GroupOptions) *Group {
+	g, err := tryNewGroupOpts(name, cacheBytes, getter, peers, o)
+	if err != nil {
+		panic(err.Error())
 	}
+	return g
+}
+
+func tryNewGroupOpts(name string, cacheBytes int64, getter Getter, peers PeerPicker, o *GroupOptions) (*Group, error) {
 	mu.Lock()
 	defer mu.Unlock()
 	initPeerServerOnce.Do(callInitPeerServer)
 	if _, dup := groups[name]; dup {
-		panic("duplicate registration of group " + name)
+		return nil, &ErrDuplicateGroup{Msg: "duplicate registration of group " + name}
+	}
+	if o != nil && o.CacheShards > 1 && (o.MainCachePolicy != nil || o.HotCachePolicy != nil) {
+		return nil, errors.New("groupcache: CacheShards can't be combined with MainCachePolicy or HotCachePolicy")
 	}
 	g := &Group{
 		name:        name,
 		getter:      getter,
 		peers:       peers,
 		cacheBytes:  cacheBytes,
+		wantBytes:   cacheBytes,
 		loadGroup:   &singleflight.Group{},
 		setGroup:    &singleflight.Group{},
 		removeGroup: &singleflight.Group{},
+		closed:      make(chan struct{}),
+	}
+	if o != nil {
+		g.opts = *o
+	}
+	if g.opts.Clock == nil {
+		g.opts.Clock = time.Now
+	}
+	if g.opts.Rand == nil {
+		g.opts.Rand = rand.Float64
+	}
+	g.mainCache.now = g.opts.Clock
+	g.hotCache.now = g.opts.Clock
+	g.mainCache.maxStale = g.opts.MaxStale
+	g.hotCache.maxStale = g.opts.MaxStale
+	if g.opts.SlidingTTL {
+		g.mainCache.slide = g.opts.TTL
+		g.hotCache.slide = g.opts.TTL
+	}
+	g.mainCache.policy = g.opts.MainCachePolicy
+	g.hotCache.policy = g.opts.HotCachePolicy
+	g.mainCache.evictions = &g.evictions
+	g.hotCache.evictions = &g.evictions
+	g.mainCache.pins = &g.pins
+	g.hotCache.pins = &g.pins
+	if g.opts.Tenant != nil {
+		g.mainCache.tenant = func(key string, _ ByteView) string { return g.opts.Tenant(key) }
+		g.mainCache.tenantBudget = func(tenant string) int64 {
+			if n, ok := g.opts.TenantBytes[tenant]; ok {
+				return n
+			}
+			return g.opts.DefaultTenantBytes
+		}
+	}
+	if share := g.opts.HotCachePeerShare; share > 0 && share < 1 {
+		g.hotCache.tenant = func(_ string, value ByteView) string { return value.source() }
+		g.hotCache.tenantBudget = func(source string) int64 {
+			if source == "" {
+				// Set by this process rather than fetched from a peer.
+				return 0
+			}
+			return int64(share * float64(g.maxBytes()/9))
+		}
+	}
+	if g.opts.MaxPeerFetches > 0 {
+		g.peerFetches = make(chan struct{}, g.opts.MaxPeerFetches)
+	}
+	if g.opts.MaxFlights > 0 {
+		g.flights = make(chan struct{}, g.opts.MaxFlights)
+	}
+	if g.opts.DisableSingleflight {
+		g.loadGroup = &noFlight{}
+	}
+	if g.opts.CoalesceWindow > 0 {
+		g.recent = newRecentLoads(g.opts.CoalesceWindow, g.opts.Clock)
+	}
+	if g.opts.NegativeTTL > 0 {
+		g.notFound = newTombstones(g.opts.NegativeTTL, g.opts.Clock)
+	}
+	if g.opts.PushInvalidations > 0 {
+		g.fetchers = newFetcherTracker(g.opts.PushInvalidations)
+	}
+	if g.opts.AdmissionFilter {
+		g.admission = newTinyLFU(g.opts.HashSeed)
+	}
+	if g.opts.HotCacheMinFetches > 1 {
+		g.fetchCounts = newFetchCounter(g.opts.HotCacheMinFetches)
+	}
+	if g.opts.BreakerThreshold > 0 {
+		g.breaker = newBreaker(g.opts, &g.Stats)
+	}
+	if fn := g.opts.OnEvict; fn != nil {
+		g.mainCache.onEvict = func(key string, bytes int) { fn(MainCache.String(), key, bytes) }
+		g.hotCache.onEvict = func(key string, bytes int) { fn(HotCache.String(), key, bytes) }
+	}
+	if fn := g.opts.KeyFingerprint; fn != nil {
+		g.mainCache.fingerprint = fn
+		g.hotCache.fingerprint = fn
+	}
+	if n := g.opts.CacheShards; n > 1 {
+		g.mainCache.split(n)
+		g.hotCache.split(n)
 	}
 	if fn := newGroupHook; fn != nil {
 		fn(g)
 	}
 	groups[name] = g
-	return g
+	return g, nil
 }
 
 // newGroupHook, if non-nil, is called right after a new group is created.
@@ -162,11 +614,12 @@ func callInitPeerServer() {
 // A Group is a cache namespace and associated data loaded spread over
 // a group of 1 or more machines.
 type Group struct {
-	name       string
-	getter     Getter
-	peersOnce  sync.Once
-	peers      PeerPicker
-	cacheBytes int64 // limit for sum of mainCache and hotCache size
+	name      string
+	getter    Getter
+	opts      GroupOptions
+	peersOnce sync.Once
+	peers     PeerPicker
+	localOnly bool // set by initPeers; true if peers can never be picked
 
 	// mainCache is a cache of the keys for which this process
 	// (amongst its peers) is authoritative. That is, this cache
@@ -184,6 +637,54 @@ type Group struct {
 	// of key/value pairs that can be stored globally.
 	hotCache cache
 
+	// admission, if non-nil, decides whether loaded values are worth
+	// caching when caching them would evict another entry.
+	admission *tinyLFU
+
+	// fetchCounts, if non-nil, counts the fetches of keys from peers
+	// until they may enter the hot cache.
+	fetchCounts *fetchCounter
+
+	// breaker, if non-nil, stops calling the Getter while it keeps failing.
+	breaker *breaker
+
+	// peerFetches, if non-nil, holds a token for each request to a peer
+	// in flight, bounding them to its capacity.
+	peerFetches chan struct{}
+
+	// flights, if non-nil, holds a token for each key being loaded,
+	// bounding them to its capacity.
+	flights chan struct{}
+
+	// closed is closed by Close, to stop background work.
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// background tracks the goroutines started by RemoveAsync and
+	// GetStale.
+	background sync.WaitGroup
+
+	// refreshing holds the keys GetStale is reloading in the background.
+	refreshing sync.Map
+
+	// evictions counts the entries the caches evicted to make room.
+	evictions rateCounter
+
+	// pins holds the keys passed to Pin.
+	pins pinSet
+
+	// fetchers, if non-nil, remembers the peers which fetched each key,
+	// see GroupOptions.PushInvalidations.
+	fetchers *fetcherTracker
+
+	// recent, if non-nil, holds the values of loads which completed
+	// within GroupOptions.CoalesceWindow.
+	recent *recentLoads
+
+	// notFound, if non-nil, holds the keys which were not found within
+	// GroupOptions.NegativeTTL.
+	notFound *tombstones
+
 	// loadGroup ensures that each key is only fetched once
 	// (either locally or remotely), regardless of the number of
 	// concurrent callers.
@@ -201,6 +702,26 @@ type Group struct {
 
 	// Stats are statistics on the group.
 	Stats Stats
+
+	// lastVersion is the version of the last Set. It, cacheBytes and
+	// wantBytes follow Stats to be 8-byte aligned on 32-bit platforms.
+	lastVersion AtomicInt
+
+	// cacheBytes is the limit for the sum of the mainCache and hotCache
+	// size. It is accessed atomically since SetCacheBytes may change it.
+	cacheBytes int64
+
+	// wantBytes is the limit the group was given, which the share of the
+	// limit of its MemoryCoordinator may lower cacheBytes below. It is
+	// accessed atomically.
+	wantBytes int64
+
+	// memory is the MemoryCoordinator the group was added to, if any. It
+	// is guarded by memoryMu.
+	memory *MemoryCoordinator
+
+	// loadSamples counts the loads considered for OnLoad sampling.
+	loadSamples AtomicInt
 }
 
 // flightGroup is defined as an interface which flightgroup.Group
@@ -208,10 +729,39 @@ type Group struct {
 // implementation.
 type flightGroup interface {
 	Do(key string, fn func() (interface{}, error)) (interface{}, error)
+	DoChan(key string, fn func() (interface{}, error)) <-chan singleflight.Result
 	Lock(fn func())
 }
 
-// Stats are per-group statistics.
+// noFlight is a flightGroup which runs every call. Calls hold the read
+// side of mu while they run, so that Lock waits for them to finish
+// before removing what they are about to cache.
+type noFlight struct {
+	mu sync.RWMutex
+}
+
+func (f *noFlight) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return fn()
+}
+
+func (f *noFlight) DoChan(key string, fn func() (interface{}, error)) <-chan singleflight.Result {
+	ch := make(chan singleflight.Result, 1)
+	v, err := f.Do(key, fn)
+	ch <- singleflight.Result{Val: v, Err: err}
+	return ch
+}
+
+func (f *noFlight) Lock(fn func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn()
+}
+
+// Stats are per-group statistics. The counters are updated atomically
+// while the group is in use, so reading them by copying Stats races
+// with the updates; use Snapshot to get a copy for reporting.
 type Stats struct {
 	Gets                     AtomicInt // any Get request, including from peers
 	CacheHits                AtomicInt // either cache was good
@@ -223,6 +773,94 @@ type Stats struct {
 	LocalLoads               AtomicInt // total good local loads
 	LocalLoadErrs            AtomicInt // total bad local loads
 	ServerRequests           AtomicInt // gets that came over the network from peers
+	ServerHotCacheHits       AtomicInt // gets from peers answered from the hot cache
+	BreakerOpens             AtomicInt // circuit breaker transitions to open
+	BreakerHalfOpens         AtomicInt // circuit breaker transitions to half-open
+	BreakerCloses            AtomicInt // circuit breaker transitions back to closed
+	StaleHits                AtomicInt // gets answered with an expired value
+	CoalescedLoads           AtomicInt // loads answered by a concurrent or recent load of the key
+	HotCachePromotions       AtomicInt // values fetched from peers added to the hot cache
+	HotCacheSkips            AtomicInt // values fetched from peers kept out by HotCacheRate or HotCacheMinFetches
+}
+
+// StatsSnapshot is a copy of the counters of Stats at one point in
+// time. It is a plain value, free to copy and pass around.
+type StatsSnapshot struct {
+	Gets                     int64
+	CacheHits                int64
+	GetFromPeersLatencyLower int64
+	PeerLoads                int64
+	PeerErrors               int64
+	Loads                    int64
+	LoadsDeduped             int64
+	LocalLoads               int64
+	LocalLoadErrs            int64
+	ServerRequests           int64
+	ServerHotCacheHits       int64
+	BreakerOpens             int64
+	BreakerHalfOpens         int64
+	BreakerCloses            int64
+	StaleHits                int64
+	CoalescedLoads           int64
+	HotCachePromotions       int64
+	HotCacheSkips            int64
+}
+
+// Snapshot reads each counter atomically into a StatsSnapshot. Counters
+// are read one after the other, so ones updated concurrently may be a
+// few updates apart.
+func (s *Stats) Snapshot() StatsSnapshot {
+	return StatsSnapshot{
+		Gets:                     s.Gets.Get(),
+		CacheHits:                s.CacheHits.Get(),
+		GetFromPeersLatencyLower: s.GetFromPeersLatencyLower.Get(),
+		PeerLoads:                s.PeerLoads.Get(),
+		PeerErrors:               s.PeerErrors.Get(),
+		Loads:                    s.Loads.Get(),
+		LoadsDeduped:             s.LoadsDeduped.Get(),
+		LocalLoads:               s.LocalLoads.Get(),
+		LocalLoadErrs:            s.LocalLoadErrs.Get(),
+		ServerRequests:           s.ServerRequests.Get(),
+		ServerHotCacheHits:       s.ServerHotCacheHits.Get(),
+		BreakerOpens:             s.BreakerOpens.Get(),
+		BreakerHalfOpens:         s.BreakerHalfOpens.Get(),
+		BreakerCloses:            s.BreakerCloses.Get(),
+		StaleHits:                s.StaleHits.Get(),
+		CoalescedLoads:           s.CoalescedLoads.Get(),
+		HotCachePromotions:       s.HotCachePromotions.Get(),
+		HotCacheSkips:            s.HotCacheSkips.Get(),
+	}
+}
+
+// StatCounter is the value of one counter of a StatsSnapshot.
+type StatCounter struct {
+	Name  string // the name of the field of Stats, e.g. "CacheHits"
+	Value int64
+}
+
+// Counters returns every counter of s sorted by name, so that exporters
+// iterating them always list the counters in the same order.
+func (s StatsSnapshot) Counters() []StatCounter {
+	return []StatCounter{
+		{"BreakerCloses", s.BreakerCloses},
+		{"BreakerHalfOpens", s.BreakerHalfOpens},
+		{"BreakerOpens", s.BreakerOpens},
+		{"CacheHits", s.CacheHits},
+		{"CoalescedLoads", s.CoalescedLoads},
+		{"GetFromPeersLatencyLower", s.GetFromPeersLatencyLower},
+		{"Gets", s.Gets},
+		{"HotCachePromotions", s.HotCachePromotions},
+		{"HotCacheSkips", s.HotCacheSkips},
+		{"Loads", s.Loads},
+		{"LoadsDeduped", s.LoadsDeduped},
+		{"LocalLoadErrs", s.LocalLoadErrs},
+		{"LocalLoads", s.LocalLoads},
+		{"PeerErrors", s.PeerErrors},
+		{"PeerLoads", s.PeerLoads},
+		{"ServerHotCacheHits", s.ServerHotCacheHits},
+		{"ServerRequests", s.ServerRequests},
+		{"StaleHits", s.StaleHits},
+	}
 }
 
 // Name returns the name of the group.
@@ -231,22 +869,248 @@ func (g *Group) Name() string {
 }
 
 func (g *Group) initPeers() {
+	if g.opts.LocalOnly {
+		g.peers = NoPeers{}
+	}
+	if g.peers == nil {
+		g.peers = g.opts.Peers
+	}
 	if g.peers == nil {
 		g.peers = getPeers(g.name)
 	}
+	_, g.localOnly = g.peers.(NoPeers)
+}
+
+// LocalOnly reports whether the group always loads keys locally
+// without consulting a PeerPicker.
+func (g *Group) LocalOnly() bool {
+	g.peersOnce.Do(g.initPeers)
+	return g.localOnly
+}
+
+// IsLocal reports whether this process owns key, so that a Get of it
+// which misses the caches loads it locally rather than from a peer. It
+// only consults the PeerPicker, without looking up or loading the key.
+func (g *Group) IsLocal(key string) bool {
+	g.peersOnce.Do(g.initPeers)
+	_, remote := g.pickPeer(key)
+	return !remote
+}
+
+// pickPeer returns the peer that owns key, skipping the PeerPicker
+// entirely when the group is local only.
+func (g *Group) pickPeer(key string) (ProtoGetter, bool) {
+	if g.localOnly {
+		return nil, false
+	}
+	return g.peers.PickPeer(key)
 }
 
 func (g *Group) Get(ctx context.Context, key string, dest Sink) error {
+	_, err := g.GetWithInfo(ctx, key, dest)
+	return err
+}
+
+// AsGetter returns a Getter which gets keys from g, so that g can back
+// another Group, such as a small process-local group fronting a larger
+// shared one. Misses of the front group are then loaded, and cached, by
+// g. The Getter returns the errors of Get as they are, so ErrNotFound is
+// passed on to the front group.
+func (g *Group) AsGetter() Getter {
+	return GetterFunc(g.Get)
+}
+
+// GetDerived returns what GroupOptions.Derive computed from the value of
+// key, loading the key like Get if it isn't cached. The value itself is
+// not copied for the caller. It fails if the group has no Derive.
+func (g *Group) GetDerived(ctx context.Context, key string) (ByteView, error) {
+	if g.opts.Derive == nil {
+		return ByteView{}, errors.New("groupcache: group " + g.name + " has no Derive")
+	}
+	var value ByteView
+	if _, err := g.GetWithInfo(ctx, key, ByteViewSink(&value)); err != nil {
+		return ByteView{}, err
+	}
+	if value.derived() == nil {
+		// Loaded values are only derived as they are cached.
+		if cached, ok := g.mainCache.peek(key); ok {
+			value = cached
+		} else if cached, ok := g.hotCache.peek(key); ok {
+			value = cached
+		}
+	}
+	if value.derived() == nil {
+		return ByteView{b: g.opts.Derive(key, value)}, nil
+	}
+	return ByteView{b: value.derived()}, nil
+}
+
+// GetStale is like Get, except that a value which expired less than
+// maxStale ago is returned right away instead of being loaded again.
+// The key is then reloaded in the background, so that later gets find
+// the fresh value. Without a cached value recent enough, the key is
+// loaded as by Get.
+func (g *Group) GetStale(ctx context.Context, key string, dest Sink, maxStale time.Duration) error {
 	g.peersOnce.Do(g.initPeers)
-	g.Stats.Gets.Add(1)
+	if err := g.closedErr(); err != nil {
+		return err
+	}
+	if err := g.checkKey(key); err != nil {
+		return err
+	}
 	if dest == nil {
 		return errors.New("groupcache: nil dest Sink")
 	}
-	value, cacheHit := g.lookupCache(key)
+	if value, which, ok := g.lookupExpired(key, maxStale); ok {
+		g.Stats.Gets.Add(1)
+		g.Stats.StaleHits.Add(1)
+		g.refreshAsync(key)
+		err := setFinalView(dest, value)
+		if g.opts.OnAccess != nil {
+			g.access(ctx, key, GetInfo{CacheHit: true, Source: accessSource(which)}, err)
+		}
+		return err
+	}
+	return g.Get(ctx, key, dest)
+}
+
+// lookupExpired returns the value of key, and the cache holding it, if
+// it expired less than maxStale ago.
+func (g *Group) lookupExpired(key string, maxStale time.Duration) (value ByteView, which CacheType, ok bool) {
+	if maxStale <= 0 || g.maxBytes() <= 0 {
+		return
+	}
+	value, ok = g.mainCache.getExpired(key, maxStale)
+	if ok {
+		return value, MainCache, true
+	}
+	value, ok = g.hotCache.getExpired(key, maxStale)
+	return value, HotCache, ok
+}
+
+// refreshAsync loads key in the background, unless it is already being
+// refreshed.
+func (g *Group) refreshAsync(key string) {
+	if _, busy := g.refreshing.LoadOrStore(key, struct{}{}); busy {
+		return
+	}
+	g.background.Add(1)
+	go func() {
+		defer g.background.Done()
+		defer g.refreshing.Delete(key)
+		var value ByteView
+		_, _, _, err := g.load(context.Background(), key, ByteViewSink(&value))
+		if err != nil && logger != nil {
+			logger.Error().
+				WithFields(map[string]interface{}{
+					"err":      err,
+					"key":      key,
+					"category": "groupcache",
+				}).Printf("error refreshing stale key")
+		}
+	}()
+}
+
+// GetBytes returns a copy of the value of key, which the caller may
+// modify. It is Get with an AllocatingByteSliceSink.
+func (g *Group) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	var b []byte
+	if err := g.Get(ctx, key, AllocatingByteSliceSink(&b)); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// GetString returns the value of key as a string. It is Get with a
+// StringSink.
+func (g *Group) GetString(ctx context.Context, key string) (string, error) {
+	var s string
+	if err := g.Get(ctx, key, StringSink(&s)); err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+// GetInto copies the value of key into buf and returns its length, so
+// that callers can reuse buffers, such as from a pool, rather than
+// allocate one per value. If the value does not fit, buf is left as it
+// is and GetInto returns the length of the value with io.ErrShortBuffer,
+// so that the caller can retry with a large enough buffer. Cache hits
+// do not allocate.
+func (g *Group) GetInto(ctx context.Context, key string, buf []byte) (n int, err error) {
+	g.peersOnce.Do(g.initPeers)
+	if err := g.closedErr(); err != nil {
+		return 0, err
+	}
+	if err := g.checkKey(key); err != nil {
+		return 0, err
+	}
+	g.Stats.Gets.Add(1)
+	// Cache hits go without a Sink, which would escape to the heap.
+	value, which, cacheHit := g.lookupCacheType(key)
+	var info GetInfo
+	if cacheHit {
+		g.Stats.CacheHits.Add(1)
+		info = GetInfo{CacheHit: true, Source: accessSource(which)}
+	} else {
+		var loaded ByteView
+		value, _, info, err = g.load(ctx, key, ByteViewSink(&loaded))
+	}
+	if g.opts.OnAccess != nil {
+		g.access(ctx, key, info, err)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if value.Len() > len(buf) {
+		return value.Len(), io.ErrShortBuffer
+	}
+	return value.Copy(buf), nil
+}
+
+// GetInfo describes how a Get was served.
+type GetInfo struct {
+	// CacheHit is true if the value was found in the main or hot cache
+	// without loading it.
+	CacheHit bool
+
+	// Follower is true if the value was loaded by a concurrent Get of
+	// the same key which this Get waited on, rather than by this Get.
+	Follower bool
+
+	// OwnerCacheHit is true if this Get fetched the value from the peer
+	// owning the key, which served it from its cache. It is false when
+	// the owner had to load the value, so that its backing store served
+	// the Get, and for values loaded locally.
+	OwnerCacheHit bool
+
+	// Source is where the value was found.
+	Source AccessSource
+}
+
+// GetWithInfo is like Get but also reports how the value was served,
+// to diagnose how effectively concurrent loads are deduplicated.
+func (g *Group) GetWithInfo(ctx context.Context, key string, dest Sink) (GetInfo, error) {
+	g.peersOnce.Do(g.initPeers)
+	if err := g.closedErr(); err != nil {
+		return GetInfo{}, err
+	}
+	if err := g.checkKey(key); err != nil {
+		return GetInfo{}, err
+	}
+	g.Stats.Gets.Add(1)
+	if dest == nil {
+		return GetInfo{}, errors.New("groupcache: nil dest Sink")
+	}
+	value, which, cacheHit := g.lookupCacheType(key)
 
 	if cacheHit {
 		g.Stats.CacheHits.Add(1)
-		return setSinkView(dest, value)
+		info := GetInfo{CacheHit: true, Source: accessSource(which)}
+		if g.opts.OnAccess != nil {
+			g.access(ctx, key, info, nil)
+		}
+		return info, setFinalView(dest, value)
 	}
 
 	// Optimization to avoid double unmarshalling or copying: keep
@@ -254,62 +1118,395 @@ func (g *Group) Get(ctx context.Context, key string, dest Sink) error {
 	// (if local) will set this; the losers will not. The common
 	// case will likely be one caller.
 	destPopulated := false
-	value, destPopulated, err := g.load(ctx, key, dest)
-	if err != nil {
-		return err
+	value, destPopulated, info, err := g.load(ctx, key, dest)
+	if err == nil && !destPopulated {
+		err = setSinkView(dest, value)
 	}
-	if destPopulated {
-		return nil
+	if err == nil {
+		err = flushSinkView(dest)
+	}
+	if g.opts.OnAccess != nil {
+		g.access(ctx, key, info, err)
 	}
-	return setSinkView(dest, value)
+	return info, err
 }
 
-func (g *Group) Set(ctx context.Context, key string, value []byte, hotCache bool) error {
+// NoExpiry is the TTL GetWithTTL reports for values which never expire.
+const NoExpiry time.Duration = -1
+
+// GetWithTTL is like Get but also returns how long the value stays
+// fresh, such as to set the max-age of a response serving it, or
+// NoExpiry if it never expires. A value which isn't cached locally after
+// the Get, such as one fetched from a peer, is reported as fresh for the
+// group's TTL.
+func (g *Group) GetWithTTL(ctx context.Context, key string, dest Sink) (time.Duration, error) {
+	if _, err := g.GetWithInfo(ctx, key, dest); err != nil {
+		return 0, err
+	}
+	value, ok := g.mainCache.peek(key)
+	if !ok {
+		value, ok = g.hotCache.peek(key)
+	}
+	switch {
+	case ok && !value.Expire().IsZero():
+		if ttl := value.Expire().Sub(g.opts.Clock()); ttl > 0 {
+			return ttl, nil
+		}
+		return 0, nil
+	case !ok && g.opts.TTL > 0:
+		return g.opts.TTL, nil
+	default:
+		return NoExpiry, nil
+	}
+}
+
+// GetRange is like Get but only populates dest with length bytes of the
+// value starting at offset. A length of zero means until the end of the
+// value. When a remote peer owns the key and it is not cached locally,
+// only the requested range is transferred and nothing is cached.
+func (g *Group) GetRange(ctx context.Context, key string, offset, length int64, dest Sink) error {
 	g.peersOnce.Do(g.initPeers)
+	if err := g.closedErr(); err != nil {
+		return err
+	}
+	if err := g.checkKey(key); err != nil {
+		return err
+	}
+	if dest == nil {
+		return errors.New("groupcache: nil dest Sink")
+	}
+	if _, cacheHit := g.lookupCache(key); !cacheHit {
+		if peer, ok := g.pickPeer(key); ok {
+			g.Stats.Gets.Add(1)
+			value, err := g.getRangeFromPeer(ctx, peer, key, offset, length)
+			if err != nil {
+				g.Stats.PeerErrors.Add(1)
+			} else {
+				g.Stats.PeerLoads.Add(1)
+				err = setFinalView(dest, value)
+			}
+			if g.opts.OnAccess != nil {
+				g.access(ctx, key, GetInfo{Source: PeerAccess}, err)
+			}
+			return err
+		}
+	}
 
-	if key == "" {
-		return errors.New("empty Set() key not allowed")
+	var value ByteView
+	if err := g.Get(ctx, key, ByteViewSink(&value)); err != nil {
+		return err
 	}
+	return setFinalView(dest, value.sliceRange(offset, length))
+}
 
-	_, err := g.setGroup.Do(key, func() (interface{}, error) {
-		// If remote peer owns this key
-		owner, ok := g.peers.PickPeer(key)
-		if ok {
-			if err := g.setFromPeer(ctx, owner, key, value); err != nil {
-				return nil, err
+// GetIfModified is like Get, but only fills dest when the ETag of the
+// value differs from etag, which is typically the ETag a previous call
+// returned. It returns the current ETag of the value and whether dest
+// was filled. When a remote peer owns an uncached key, the ETag is sent
+// along so an unmodified value isn't transferred.
+func (g *Group) GetIfModified(ctx context.Context, key, etag string, dest Sink) (string, bool, error) {
+	g.peersOnce.Do(g.initPeers)
+	if err := g.closedErr(); err != nil {
+		return "", false, err
+	}
+	if err := g.checkKey(key); err != nil {
+		return "", false, err
+	}
+	if dest == nil {
+		return "", false, errors.New("groupcache: nil dest Sink")
+	}
+	if _, cacheHit := g.lookupCache(key); !cacheHit {
+		if peer, ok := g.pickPeer(key); ok {
+			g.Stats.Gets.Add(1)
+			value, tag, modified, err := g.getIfModifiedFromPeer(ctx, peer, key, etag)
+			if err != nil {
+				g.Stats.PeerErrors.Add(1)
+				tag, modified = "", false
+			} else {
+				g.Stats.PeerLoads.Add(1)
+				if !modified {
+					tag = etag
+				} else {
+					if tag == "" {
+						tag = g.etag(value)
+					}
+					err = setFinalView(dest, value)
+				}
 			}
-			// TODO(thrawn01): Not sure if this is useful outside of tests...
-			//  maybe we should ALWAYS update the local cache?
-			if hotCache {
-				g.localSet(key, value, &g.hotCache)
+			if g.opts.OnAccess != nil {
+				g.access(ctx, key, GetInfo{Source: PeerAccess}, err)
 			}
-			return nil, nil
+			return tag, modified, err
 		}
-		// We own this key
-		g.localSet(key, value, &g.mainCache)
-		return nil, nil
-	})
-	return err
+	}
+
+	var value ByteView
+	if err := g.Get(ctx, key, ByteViewSink(&value)); err != nil {
+		return "", false, err
+	}
+	tag := g.etag(value)
+	if tag == etag {
+		return tag, false, nil
+	}
+	return tag, true, setFinalView(dest, value)
 }
 
-// Remove clears the key from our cache then forwards the remove
-// request to all peers.
-func (g *Group) Remove(ctx context.Context, key string) error {
+// GetMulti gets the values of keys, returning them by key. Keys which
+// are not cached and are owned by a peer implementing MultiGetter are
+// fetched from each peer in a single request; the others are loaded as
+// by Get. Keys a batch request did not answer before failing are loaded
+// one by one instead. GetMulti fails with the first error a key fails
+// with, including ErrNotFound; see GetMultiPartial to keep the values
+// of the other keys.
+func (g *Group) GetMulti(ctx context.Context, keys []string) (map[string]ByteView, error) {
 	g.peersOnce.Do(g.initPeers)
+	if err := g.closedErr(); err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if err := g.checkKey(key); err != nil {
+			return nil, err
+		}
+	}
+	res, _, firstErr := g.getMulti(ctx, keys)
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return res, nil
+}
 
-	_, err := g.removeGroup.Do(key, func() (interface{}, error) {
+// GetMultiPartial is like GetMulti, except that keys which fail do not
+// fail the others. It returns the values of the keys which succeeded,
+// and the error of each key which failed, such as those owned by an
+// unreachable peer or not found. Both maps are empty if the group is
+// closed or no key failed, respectively; every key fails with
+// ErrGroupClosed if the group is closed.
+func (g *Group) GetMultiPartial(ctx context.Context, keys []string) (map[string]ByteView, map[string]error) {
+	g.peersOnce.Do(g.initPeers)
+	errs := make(map[string]error)
+	if err := g.closedErr(); err != nil {
+		for _, key := range keys {
+			errs[key] = err
+		}
+		return map[string]ByteView{}, errs
+	}
+	valid := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if err := g.checkKey(key); err != nil {
+			errs[key] = err
+			continue
+		}
+		valid = append(valid, key)
+	}
+	res, failed, _ := g.getMulti(ctx, valid)
+	for key, err := range failed {
+		errs[key] = err
+	}
+	return res, errs
+}
 
-		// Remove from key owner first
-		owner, ok := g.peers.PickPeer(key)
-		if ok {
-			if err := g.removeFromPeer(ctx, owner, key); err != nil {
-				return nil, err
+// getMulti gets the values of keys, which must be valid, returning the
+// values and errors by key, and the first error.
+func (g *Group) getMulti(ctx context.Context, keys []string) (res map[string]ByteView, errs map[string]error, firstErr error) {
+	var mu sync.Mutex
+	res = make(map[string]ByteView, len(keys))
+	errs = make(map[string]error)
+	done := func(key string, value ByteView, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
 			}
+			errs[key] = err
+			return
 		}
-		// Remove from our cache next
-		g.localRemove(key)
-		wg := sync.WaitGroup{}
-		errs := make(chan error)
+		res[key] = value
+	}
+	// Keys loaded by Get are reported by it.
+	accessed := func(key string, info GetInfo, err error) {
+		if g.opts.OnAccess != nil {
+			g.access(ctx, key, info, err)
+		}
+	}
+
+	// batches holds the keys to fetch from each MultiGetter peer, by URL.
+	batches := make(map[string][]string)
+	getters := make(map[string]MultiGetter)
+	var single []string
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		peer, ok := g.pickPeer(key)
+		if !ok {
+			single = append(single, key)
+			continue
+		}
+		mg, ok := peer.(MultiGetter)
+		if !ok {
+			single = append(single, key)
+			continue
+		}
+		g.Stats.Gets.Add(1)
+		if value, which, cacheHit := g.lookupCacheType(key); cacheHit {
+			g.Stats.CacheHits.Add(1)
+			accessed(key, GetInfo{CacheHit: true, Source: accessSource(which)}, nil)
+			done(key, value, nil)
+			continue
+		}
+		batches[peer.GetURL()] = append(batches[peer.GetURL()], key)
+		getters[peer.GetURL()] = mg
+	}
+
+	var wg sync.WaitGroup
+	for peerURL, batch := range batches {
+		wg.Add(1)
+		go func(mg MultiGetter, batch []string) {
+			defer wg.Done()
+			answered := make(map[string]bool, len(batch))
+			req := &pb.GetMultiRequest{Group: &g.name, Keys: batch}
+			err := g.callPeer(ctx, func() error {
+				return mg.GetMulti(ctx, req, func(key string, out *pb.GetResponse, err error) {
+					answered[key] = true
+					accessed(key, GetInfo{Source: PeerAccess}, err)
+					if err != nil {
+						done(key, ByteView{}, err)
+						return
+					}
+					g.Stats.PeerLoads.Add(1)
+					value := ByteView{b: out.Value}.withVersion(out.GetVersion(), g.sourceOf(mg.(ProtoGetter)))
+					g.promote(key, value)
+					done(key, value, nil)
+				})
+			})
+			if err == nil {
+				return
+			}
+			g.Stats.PeerErrors.Add(1)
+			for _, key := range batch {
+				if answered[key] {
+					continue
+				}
+				var view ByteView
+				value, _, info, err := g.load(ctx, key, ByteViewSink(&view))
+				accessed(key, info, err)
+				done(key, value, err)
+			}
+		}(getters[peerURL], batch)
+	}
+
+	for _, key := range single {
+		var value ByteView
+		err := g.Get(ctx, key, ByteViewSink(&value))
+		done(key, value, err)
+	}
+	wg.Wait()
+	return res, errs, firstErr
+}
+
+// Set stores value as the value of key on its owner, replacing any
+// cached value. Each Set is given a version from Clock which is higher
+// than that of any earlier Set by this process, see SetWithVersion.
+func (g *Group) Set(ctx context.Context, key string, value []byte, hotCache bool) error {
+	return g.SetWithVersion(ctx, key, value, g.nextVersion(), hotCache)
+}
+
+// SetWithVersion is like Set, but with a version chosen by the caller.
+// Owners and hot caches keep the highest version of a key they have
+// cached, so a write which arrives after a newer one is rejected with
+// ErrStaleVersion instead of overwriting it. Version zero is unversioned
+// and always replaces the cached value, as do values loaded by a Getter,
+// which have version zero.
+func (g *Group) SetWithVersion(ctx context.Context, key string, value []byte, version int64, hotCache bool) error {
+	g.peersOnce.Do(g.initPeers)
+	if err := g.closedErr(); err != nil {
+		return err
+	}
+	if err := g.checkKey(key); err != nil {
+		return err
+	}
+
+	if key == "" {
+		return errors.New("empty Set() key not allowed")
+	}
+
+	_, err := g.setGroup.Do(key, func() (interface{}, error) {
+		// If remote peer owns this key
+		owner, ok := g.pickPeer(key)
+		if ok {
+			if err := g.setFromPeer(ctx, owner, key, value, version); err != nil {
+				return nil, err
+			}
+			// TODO(thrawn01): Not sure if this is useful outside of tests...
+			//  maybe we should ALWAYS update the local cache?
+			if hotCache {
+				g.localSetVersion(key, value, version, &g.hotCache)
+			}
+			return nil, nil
+		}
+		// We own this key
+		if !g.localSetVersion(key, value, version, &g.mainCache) {
+			return nil, staleVersion(key, version)
+		}
+		return nil, g.pushInvalidations(ctx, key)
+	})
+	return err
+}
+
+// nextVersion returns the version of the next Set, which is the current
+// time in nanoseconds unless that doesn't exceed the last version.
+func (g *Group) nextVersion() int64 {
+	now := g.opts.Clock().UnixNano()
+	for {
+		last := g.lastVersion.Get()
+		next := now
+		if next <= last {
+			next = last + 1
+		}
+		if atomic.CompareAndSwapInt64((*int64)(&g.lastVersion), last, next) {
+			return next
+		}
+	}
+}
+
+func staleVersion(key string, version int64) error {
+	return &ErrStaleVersion{Msg: fmt.Sprintf("groupcache: version %d of key %q is older than the cached version", version, key)}
+}
+
+// Remove clears the key from our cache then forwards the remove
+// request to all peers.
+func (g *Group) Remove(ctx context.Context, key string) error {
+	g.peersOnce.Do(g.initPeers)
+	if err := g.closedErr(); err != nil {
+		return err
+	}
+
+	_, err := g.removeGroup.Do(key, func() (interface{}, error) {
+
+		// Remove from key owner first
+		owner, ok := g.pickPeer(key)
+		if ok {
+			if err := g.removeFromPeer(ctx, owner, key); err != nil {
+				return nil, err
+			}
+		}
+		// Remove from our cache next
+		g.localRemove(key)
+		if g.localOnly {
+			return nil, nil
+		}
+		if g.fetchers != nil {
+			// The owner removes the key from the peers which fetched it.
+			if ok {
+				return nil, nil
+			}
+			return nil, g.pushInvalidations(ctx, key)
+		}
+		wg := sync.WaitGroup{}
+		errs := make(chan error)
 
 		// Asynchronously clear the key from all hot and main caches of peers
 		for _, peer := range g.peers.GetAll() {
@@ -341,10 +1538,312 @@ func (g *Group) Remove(ctx context.Context, key string) error {
 	return err
 }
 
+// RemoveAsync clears the key from our cache, then removes it from all
+// peers in the background without waiting for them. Failures are only
+// logged; use Remove to learn whether the key is gone everywhere. It
+// does nothing once the group is closed.
+func (g *Group) RemoveAsync(key string) {
+	g.peersOnce.Do(g.initPeers)
+	if g.closedErr() != nil {
+		return
+	}
+
+	g.localRemove(key)
+	if g.localOnly {
+		return
+	}
+	for _, peer := range g.peers.GetAll() {
+		g.background.Add(1)
+		go func(peer ProtoGetter) {
+			defer g.background.Done()
+			err := g.removeFromPeer(context.Background(), peer, key)
+			if err != nil && logger != nil {
+				logger.Error().
+					WithFields(map[string]interface{}{
+						"err":      err,
+						"key":      key,
+						"category": "groupcache",
+					}).Printf("error removing key from peer '%s'", peer.GetURL())
+			}
+		}(peer)
+	}
+}
+
+// InvalidateTag clears every entry which GroupOptions.Tags tagged with
+// tag from our cache then forwards the invalidation to all peers. Every
+// peer must implement TagInvalidator.
+func (g *Group) InvalidateTag(ctx context.Context, tag string) error {
+	g.peersOnce.Do(g.initPeers)
+	if err := g.closedErr(); err != nil {
+		return err
+	}
+
+	g.localInvalidateTag(tag)
+	if g.localOnly {
+		return nil
+	}
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error)
+	for _, peer := range g.peers.GetAll() {
+		wg.Add(1)
+		go func(peer ProtoGetter) {
+			errs <- g.invalidateTagOnPeer(ctx, peer, tag)
+			wg.Done()
+		}(peer)
+	}
+	go func() {
+		wg.Wait()
+		close(errs)
+	}()
+
+	var err error
+	for e := range errs {
+		if e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// RemoveByPrefix clears every key starting with prefix from our cache
+// then forwards the removal to all peers. Every peer must implement
+// PrefixRemover. Since the caches are not indexed by prefix, this scans
+// every cached entry on each peer.
+func (g *Group) RemoveByPrefix(ctx context.Context, prefix string) error {
+	g.peersOnce.Do(g.initPeers)
+	if err := g.closedErr(); err != nil {
+		return err
+	}
+	if err := g.fingerprintErr("RemoveByPrefix"); err != nil {
+		return err
+	}
+
+	g.localRemovePrefix(prefix)
+	if g.localOnly {
+		return nil
+	}
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error)
+	for _, peer := range g.peers.GetAll() {
+		wg.Add(1)
+		go func(peer ProtoGetter) {
+			errs <- g.removePrefixFromPeer(ctx, peer, prefix)
+			wg.Done()
+		}(peer)
+	}
+	go func() {
+		wg.Wait()
+		close(errs)
+	}()
+
+	var err error
+	for e := range errs {
+		if e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// DropNonOwned removes the keys from the main cache which are now owned
+// by another peer, as happens when peers join, and returns the number of
+// keys removed. The owner loads such keys itself, so the copies here
+// would never be read again. A group with a KeyFingerprint can't tell
+// the owners of its entries, so nothing is removed from it.
+func (g *Group) DropNonOwned() int {
+	g.peersOnce.Do(g.initPeers)
+	if g.maxBytes() <= 0 || g.localOnly {
+		return 0
+	}
+	if g.fingerprintErr("DropNonOwned") != nil {
+		return 0
+	}
+
+	var n int
+	g.loadGroup.Lock(func() {
+		n = g.mainCache.removeFunc(func(key string) bool {
+			_, remote := g.pickPeer(key)
+			return remote
+		})
+	})
+	return n
+}
+
+// DropNonOwnedEvery calls DropNonOwned every interval until ctx is done.
+// It is meant to be run in its own goroutine. Like DropNonOwned, it
+// removes nothing from a group with a KeyFingerprint.
+func (g *Group) DropNonOwnedEvery(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			g.DropNonOwned()
+		case <-ctx.Done():
+			return
+		case <-g.closed:
+			return
+		}
+	}
+}
+
+// Close stops the group's background work, such as DropNonOwnedEvery,
+// and waits for the removals started by RemoveAsync to finish. Further
+// calls to Get and the group's other methods fail with ErrGroupClosed,
+// while calls already in progress complete. The group is deregistered
+// if GroupOptions.DeregisterOnClose is set. Close always returns nil.
+func (g *Group) Close() error {
+	g.closeOnce.Do(func() {
+		close(g.closed)
+		if g.opts.DeregisterOnClose {
+			DeregisterGroup(g.name)
+		}
+	})
+	g.background.Wait()
+	return nil
+}
+
+// checkKey returns ErrKeyTooLong if key exceeds GroupOptions.MaxKeyBytes.
+func (g *Group) checkKey(key string) error {
+	if g.opts.MaxKeyBytes > 0 && len(key) > g.opts.MaxKeyBytes {
+		return &ErrKeyTooLong{Msg: fmt.Sprintf("groupcache: key of %d bytes exceeds the maximum of %d bytes", len(key), g.opts.MaxKeyBytes)}
+	}
+	return nil
+}
+
+// fingerprintErr returns an error if the group stores fingerprints of
+// its keys, which op can't be applied to.
+func (g *Group) fingerprintErr(op string) error {
+	if g.opts.KeyFingerprint == nil {
+		return nil
+	}
+	return errors.New("groupcache: group " + g.name + " has a KeyFingerprint, which " + op + " can't be applied to")
+}
+
+// closedErr returns ErrGroupClosed if the group was closed.
+func (g *Group) closedErr() error {
+	select {
+	case <-g.closed:
+		return &ErrGroupClosed{Msg: "groupcache: group " + g.name + " is closed"}
+	default:
+		return nil
+	}
+}
+
+// LoadAll populates the main cache with the key/value pairs returned by
+// iter until it reports !ok, without calling the Getter. Each value
+// expires after its ttl, or the group's TTL if ttl is zero. Keys owned
+// by another peer are skipped, so every peer can be given the same
+// source. LoadAll stops with an error when ctx is done, or before a
+// value would make the caches exceed their size, so it never evicts.
+func (g *Group) LoadAll(ctx context.Context, iter func() (key string, value []byte, ttl time.Duration, ok bool)) error {
+	g.peersOnce.Do(g.initPeers)
+	if err := g.closedErr(); err != nil {
+		return err
+	}
+	if g.maxBytes() <= 0 {
+		return nil
+	}
+
+	for n := 0; ; n++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		key, value, ttl, ok := iter()
+		if !ok {
+			return nil
+		}
+		if _, remote := g.pickPeer(key); remote {
+			continue
+		}
+		size := int64(len(key) + len(value))
+		if g.mainCache.bytes()+g.hotCache.bytes()+size > g.maxBytes() {
+			return fmt.Errorf("groupcache: LoadAll filled the cache of group %s after %d keys", g.name, n)
+		}
+		bv := ByteView{b: cloneBytes(value)}
+		if ttl > 0 {
+			expire := g.opts.Clock().Add(g.jitter(ttl))
+			bv = bv.withEntry(func(e *entry) { e.expire = expire })
+		}
+		g.loadGroup.Lock(func() {
+			g.populateCache(key, bv, &g.mainCache)
+		})
+	}
+}
+
+// WarmFromPeers preloads the main cache with keys this process owns by
+// asking every peer which keys it holds, then fetching the wanted keys
+// from the peer which holds them instead of calling the Getter. It is
+// meant to be called when a process joins, so it does not start cold.
+// At most limit keys are listed per peer; a limit of zero lists every
+// key. If want is non-nil, only keys for which it returns true are
+// fetched. It returns the number of keys which were preloaded.
+func (g *Group) WarmFromPeers(ctx context.Context, limit int64, want func(key string) bool) (int, error) {
+	g.peersOnce.Do(g.initPeers)
+	if err := g.closedErr(); err != nil {
+		return 0, err
+	}
+	if err := g.fingerprintErr("WarmFromPeers"); err != nil {
+		return 0, err
+	}
+	if g.maxBytes() <= 0 || g.localOnly {
+		return 0, nil
+	}
+
+	var loaded int
+	for _, peer := range g.peers.GetAll() {
+		lister, ok := peer.(KeyLister)
+		if !ok {
+			continue
+		}
+		var keys pb.KeysResponse
+		err := g.callPeer(ctx, func() error {
+			return lister.Keys(ctx, &pb.KeysRequest{Group: &g.name, Limit: &limit}, &keys)
+		})
+		if err != nil {
+			return loaded, err
+		}
+		for _, key := range keys.Keys {
+			if want != nil && !want(key) {
+				continue
+			}
+			// Only preload keys we own which are not cached yet.
+			if _, remote := g.pickPeer(key); remote || g.Contains(key) {
+				continue
+			}
+			req := &pb.GetRequest{Group: &g.name, Key: &key}
+			res := &pb.GetResponse{}
+			err := g.callPeer(ctx, func() error { return peer.Get(ctx, req, res) })
+			if err != nil {
+				if ctx.Err() != nil {
+					return loaded, err
+				}
+				continue
+			}
+			g.localSet(key, res.Value, &g.mainCache)
+			loaded++
+		}
+	}
+	return loaded, nil
+}
+
 // load loads key either by invoking the getter locally or by sending it to another machine.
-func (g *Group) load(ctx context.Context, key string, dest Sink) (value ByteView, destPopulated bool, err error) {
+func (g *Group) load(ctx context.Context, key string, dest Sink) (value ByteView, destPopulated bool, info GetInfo, err error) {
 	g.Stats.Loads.Add(1)
-	viewi, err := g.loadGroup.Do(key, func() (interface{}, error) {
+	// Only the leader of a flight runs the callback, so it records the
+	// outcome for its caller; every other caller waited on the flight.
+	info.Follower = true
+	// tombstoned is whether the leader found key was missing again
+	// without loading it, so that its tombstone is not extended.
+	var tombstoned bool
+	do := g.loadGroup.Do
+	if g.opts.LoadWaitTimeout > 0 {
+		do = g.waitFlight
+	}
+	viewi, err := do(key, func() (interface{}, error) {
+		info.Follower = false
 		// Check the cache again because singleflight can only dedup calls
 		// that overlap concurrently.  It's possible for 2 concurrent
 		// requests to miss the cache, resulting in 2 load() calls.  An
@@ -366,20 +1865,45 @@ func (g *Group) load(ctx context.Context, key string, dest Sink) (value ByteView
 		// 1: fn()
 		// 2: loadGroup.Do("key", fn)
 		// 2: fn()
-		if value, cacheHit := g.lookupCache(key); cacheHit {
+		if value, which, cacheHit := g.lookupCacheType(key); cacheHit {
 			g.Stats.CacheHits.Add(1)
+			info.CacheHit = true
+			info.Source = accessSource(which)
 			return value, nil
 		}
+		if g.recent != nil {
+			if value, ok := g.recent.get(key); ok {
+				info.Follower = true
+				return value, nil
+			}
+		}
+		if g.notFound != nil {
+			if err := g.notFound.get(key); err != nil {
+				tombstoned = true
+				return nil, err
+			}
+		}
+		if g.flights != nil {
+			select {
+			case g.flights <- struct{}{}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			defer func() { <-g.flights }()
+		}
 		g.Stats.LoadsDeduped.Add(1)
 		var value ByteView
 		var err error
-		if peer, ok := g.peers.PickPeer(key); ok {
+		cache := &g.mainCache
+		if peer, ok := g.pickPeer(key); ok {
 
 			// metrics duration start
 			start := time.Now()
 
 			// get value from peers
-			value, err = g.getFromPeer(ctx, peer, key)
+			info.Source = PeerAccess
+			var ownerHit bool
+			value, ownerHit, err = g.getFromPeer(ctx, peer, key)
 
 			// metrics duration compute
 			duration := int64(time.Since(start)) / int64(time.Millisecond)
@@ -390,7 +1914,10 @@ func (g *Group) load(ctx context.Context, key string, dest Sink) (value ByteView
 			}
 
 			if err == nil {
+				info.OwnerCacheHit = ownerHit
 				g.Stats.PeerLoads.Add(1)
+				g.observeLoad(key, time.Since(start), PeerLoad)
+				g.rememberLoad(key, value)
 				return value, nil
 			}
 
@@ -402,7 +1929,10 @@ func (g *Group) load(ctx context.Context, key string, dest Sink) (value ByteView
 				return nil, err
 			}
 
-			if errors.Is(err, &ErrRemoteCall{}) {
+			id := peerID(peer)
+			err = fmt.Errorf("groupcache: loading key from peer %s: %w", id, err)
+
+			if errors.Is(err, &ErrRemoteCall{}) && !g.opts.LocalFallback {
 				return nil, err
 			}
 
@@ -411,8 +1941,9 @@ func (g *Group) load(ctx context.Context, key string, dest Sink) (value ByteView
 					WithFields(map[string]interface{}{
 						"err":      err,
 						"key":      key,
+						"peer":     id,
 						"category": "groupcache",
-					}).Printf("error retrieving key from peer '%s'", peer.GetURL())
+					}).Printf("error retrieving key from peer '%s'", id)
 			}
 
 			g.Stats.PeerErrors.Add(1)
@@ -421,97 +1952,572 @@ func (g *Group) load(ctx context.Context, key string, dest Sink) (value ByteView
 				// since the context is no longer valid
 				return nil, err
 			}
+			if g.opts.LocalFallback {
+				cache = &g.hotCache
+			}
 		}
 
+		start := time.Now()
+		info.Source = LoadAccess
 		value, err = g.getLocally(ctx, key, dest)
 		if err != nil {
 			g.Stats.LocalLoadErrs.Add(1)
+			if stale, ok := g.lookupStale(key, err); ok {
+				g.Stats.StaleHits.Add(1)
+				return stale, nil
+			}
 			return nil, err
 		}
 		g.Stats.LocalLoads.Add(1)
+		g.observeLoad(key, time.Since(start), LocalLoad)
 		destPopulated = true // only one caller of load gets this return value
-		g.populateCache(key, value, &g.mainCache)
+		if g.admit(key, value) {
+			g.populateCache(key, value, cache)
+		}
+		g.rememberLoad(key, value)
 		return value, nil
 	})
+	if info.Follower {
+		g.Stats.CoalescedLoads.Add(1)
+		info.Source = SharedAccess
+	}
+	if g.notFound != nil && !info.Follower && !tombstoned && errors.Is(err, &ErrNotFound{}) {
+		g.notFound.add(key, err)
+	}
+	if errors.Is(err, &ErrLoadWaitTimeout{}) {
+		if stale, ok := g.lookupStale(key, err); ok {
+			g.Stats.StaleHits.Add(1)
+			viewi, err = stale, nil
+		}
+	}
 	if err == nil {
 		value = viewi.(ByteView)
 	}
 	return
 }
 
-func (g *Group) getLocally(ctx context.Context, key string, dest Sink) (ByteView, error) {
-	err := g.getter.Get(ctx, key, dest)
-	if err != nil {
-		return ByteView{}, err
+// waitFlight is like loadGroup.Do, but gives up with ErrLoadWaitTimeout
+// once it waited GroupOptions.LoadWaitTimeout on a flight led by another
+// caller. If no flight is in progress, this caller runs fn itself.
+func (g *Group) waitFlight(key string, fn func() (interface{}, error)) (interface{}, error) {
+	ch := g.loadGroup.DoChan(key, fn)
+	select {
+	case r := <-ch:
+		// This caller led the flight, or it just landed.
+		return r.Val, r.Err
+	default:
+	}
+	timer := time.NewTimer(g.opts.LoadWaitTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-ch:
+		return r.Val, r.Err
+	case <-timer.C:
+		return nil, g.loadWaitTimeout(key)
 	}
-	return dest.view()
 }
 
-func (g *Group) getFromPeer(ctx context.Context, peer ProtoGetter, key string) (ByteView, error) {
-	req := &pb.GetRequest{
-		Group: &g.name,
-		Key:   &key,
+func (g *Group) loadWaitTimeout(key string) error {
+	return &ErrLoadWaitTimeout{Msg: fmt.Sprintf("groupcache: waited over %v for the load of key %q in group %s", g.opts.LoadWaitTimeout, key, g.name)}
+}
+
+// observeLoad calls GroupOptions.OnLoad for a sample of the loads.
+func (g *Group) observeLoad(key string, d time.Duration, source LoadSource) {
+	if g.opts.OnLoad == nil {
+		return
 	}
-	res := &pb.GetResponse{}
-	err := peer.Get(ctx, req, res)
-	if err != nil {
-		return ByteView{}, err
+	if n := int64(g.opts.OnLoadSampling); n > 1 && atomic.AddInt64((*int64)(&g.loadSamples), 1)%n != 1 {
+		return
 	}
-
-	value := ByteView{b: res.Value}
-
-	// Always populate the hot cache
-	g.populateCache(key, value, &g.hotCache)
-	return value, nil
+	g.opts.OnLoad(key, d, source)
 }
 
-func (g *Group) setFromPeer(ctx context.Context, peer ProtoGetter, k string, v []byte) error {
-	req := &pb.SetRequest{
-		Group: &g.name,
-		Key:   &k,
-		Value: v,
+// rememberLoad keeps the loaded value of key for GroupOptions.CoalesceWindow.
+func (g *Group) rememberLoad(key string, value ByteView) {
+	if g.recent != nil {
+		g.recent.add(key, value)
 	}
-	return peer.Set(ctx, req)
 }
 
-func (g *Group) removeFromPeer(ctx context.Context, peer ProtoGetter, key string) error {
-	req := &pb.GetRequest{
-		Group: &g.name,
-		Key:   &key,
+func (g *Group) getLocally(ctx context.Context, key string, dest Sink) (ByteView, error) {
+	if g.getter == nil {
+		return ByteView{}, &ErrNoGetter{Msg: "groupcache: no Getter for group " + g.name}
+	}
+	if _, ok := dest.(flushSink); ok {
+		// The Getter may pass dest on to the Get of another group,
+		// which would hand the value on before this load succeeded.
+		var loaded ByteView
+		value, err := g.getLocally(ctx, key, ByteViewSink(&loaded))
+		if err != nil {
+			return ByteView{}, err
+		}
+		return value, setSinkView(dest, value)
+	}
+	err := g.getPrimary(ctx, key, dest)
+	if err != nil && g.opts.FallbackGetter != nil && ctx.Err() == nil {
+		// The primary may have set part of a value before failing.
+		dest.Reset()
+		if ferr := g.opts.FallbackGetter.Get(ctx, key, dest); ferr != nil {
+			return ByteView{}, fmt.Errorf("%w; fallback getter: %v", err, ferr)
+		}
+		err = nil
+	}
+	if err != nil {
+		return ByteView{}, err
 	}
-	return peer.Remove(ctx, req)
+	value, err := dest.view()
+	if err != nil {
+		return ByteView{}, err
+	}
+	if g.opts.Validate != nil {
+		if err := g.opts.Validate(key, value.ByteSlice()); err != nil {
+			dest.Reset()
+			return ByteView{}, err
+		}
+	}
+	return value, nil
 }
 
-func (g *Group) lookupCache(key string) (value ByteView, ok bool) {
-	if g.cacheBytes <= 0 {
-		return
+// getPrimary loads key with the group's Getter, through the breaker.
+func (g *Group) getPrimary(ctx context.Context, key string, dest Sink) error {
+	if g.breaker != nil {
+		if err := g.breaker.allow(); err != nil {
+			return err
+		}
 	}
-	value, ok = g.mainCache.get(key)
-	if ok {
-		return
+	err := g.getter.Get(ctx, key, dest)
+	if g.breaker != nil {
+		g.breaker.done(err)
 	}
-	value, ok = g.hotCache.get(key)
-	return
+	return err
+}
+
+// getHedged sends req to the owner peer and, once HedgeDelay passed
+// without an answer, to the next peer on the ring as well. It returns
+// the first response and the peer which sent it, and cancels the other
+// request. If both fail, it returns the owner's error.
+func (g *Group) getHedged(ctx context.Context, owner ProtoGetter, req *pb.GetRequest) (*pb.GetResponse, ProtoGetter, error) {
+	fetch := func(ctx context.Context, peer ProtoGetter) (*pb.GetResponse, error) {
+		res := &pb.GetResponse{}
+		err := g.callPeer(ctx, func() error { return peer.Get(ctx, req, res) })
+		return res, err
+	}
+	var replica ProtoGetter
+	if picker, ok := g.peers.(ReplicaPicker); ok && g.opts.HedgeDelay > 0 {
+		if peers := picker.PickPeers(req.GetKey(), 2); len(peers) == 2 {
+			replica = peers[1]
+		}
+	}
+	if replica == nil {
+		res, err := fetch(ctx, owner)
+		return res, owner, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		res  *pb.GetResponse
+		peer ProtoGetter
+		err  error
+	}
+	results := make(chan result, 2)
+	send := func(peer ProtoGetter) {
+		go func() {
+			res, err := fetch(ctx, peer)
+			results <- result{res, peer, err}
+		}()
+	}
+	send(owner)
+	timer := time.NewTimer(g.opts.HedgeDelay)
+	defer timer.Stop()
+
+	hedged, pending := false, 1
+	var ownerErr error
+	for {
+		select {
+		case <-timer.C:
+			hedged = true
+			pending++
+			send(replica)
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				return r.res, r.peer, nil
+			}
+			if r.peer == owner {
+				ownerErr = r.err
+			}
+			// The owner failed before the replica was asked, or both
+			// failed.
+			if !hedged || pending == 0 {
+				return nil, owner, ownerErr
+			}
+		}
+	}
+}
+
+// getFromPeer fetches key from peer, also reporting whether the peer
+// served it from its cache.
+func (g *Group) getFromPeer(ctx context.Context, peer ProtoGetter, key string) (ByteView, bool, error) {
+	req := &pb.GetRequest{
+		Group: &g.name,
+		Key:   &key,
+	}
+	res, peer, err := g.getHedged(ctx, peer, req)
+	if err != nil {
+		return ByteView{}, false, err
+	}
+
+	value := ByteView{b: res.Value}.withVersion(res.GetVersion(), g.sourceOf(peer))
+
+	g.promote(key, value)
+	return value, res.GetCacheHit(), nil
+}
+
+func (g *Group) getRangeFromPeer(ctx context.Context, peer ProtoGetter, key string, offset, length int64) (ByteView, error) {
+	req := &pb.GetRequest{
+		Group:  &g.name,
+		Key:    &key,
+		Offset: &offset,
+		Length: &length,
+	}
+	res := &pb.GetResponse{}
+	if err := g.callPeer(ctx, func() error { return peer.Get(ctx, req, res) }); err != nil {
+		return ByteView{}, err
+	}
+	return ByteView{b: res.Value}, nil
+}
+
+// getIfModifiedFromPeer fetches key from peer unless its ETag is etag,
+// also returning the ETag the peer computed.
+func (g *Group) getIfModifiedFromPeer(ctx context.Context, peer ProtoGetter, key, etag string) (ByteView, string, bool, error) {
+	req := &pb.GetRequest{
+		Group: &g.name,
+		Key:   &key,
+		Etag:  &etag,
+	}
+	res := &pb.GetResponse{}
+	if err := g.callPeer(ctx, func() error { return peer.Get(ctx, req, res) }); err != nil {
+		return ByteView{}, "", false, err
+	}
+	if res.GetNotModified() {
+		return ByteView{}, "", false, nil
+	}
+	return ByteView{b: res.Value}, res.GetEtag(), true, nil
+}
+
+// callPeer calls fn, which makes a request to a peer, once fewer than
+// MaxPeerFetches requests of the group are in flight.
+func (g *Group) callPeer(ctx context.Context, fn func() error) error {
+	if g.peerFetches == nil {
+		return fn()
+	}
+	select {
+	case g.peerFetches <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-g.peerFetches }()
+	return fn()
+}
+
+func (g *Group) setFromPeer(ctx context.Context, peer ProtoGetter, k string, v []byte, version int64) error {
+	req := &pb.SetRequest{
+		Group:   &g.name,
+		Key:     &k,
+		Value:   v,
+		Version: &version,
+	}
+	return g.callPeer(ctx, func() error { return peer.Set(ctx, req) })
+}
+
+func (g *Group) removeFromPeer(ctx context.Context, peer ProtoGetter, key string) error {
+	req := &pb.GetRequest{
+		Group: &g.name,
+		Key:   &key,
+	}
+	return g.callPeer(ctx, func() error { return peer.Remove(ctx, req) })
+}
+
+// lookupStale returns the expired value of key if loading it failed
+// with err and the value may still be served, see GroupOptions.MaxStale.
+func (g *Group) lookupStale(key string, err error) (value ByteView, ok bool) {
+	if g.opts.MaxStale <= 0 || g.maxBytes() <= 0 || errors.Is(err, &ErrNotFound{}) {
+		return
+	}
+	value, ok = g.mainCache.getStale(key)
+	if ok {
+		return
+	}
+	value, ok = g.hotCache.getStale(key)
+	return
+}
+
+func (g *Group) lookupCache(key string) (value ByteView, ok bool) {
+	value, _, ok = g.lookupCacheType(key)
+	return
+}
+
+// lookupCacheType is lookupCache also returning the cache holding key.
+func (g *Group) lookupCacheType(key string) (value ByteView, which CacheType, ok bool) {
+	if g.maxBytes() <= 0 {
+		return
+	}
+	if g.admission != nil {
+		g.admission.record(key)
+	}
+	value, ok = g.mainCache.get(key)
+	if ok {
+		return value, MainCache, true
+	}
+	value, ok = g.hotCache.get(key)
+	return value, HotCache, ok
+}
+
+// Contains reports whether key is present in the local main or hot
+// cache. It never loads the key, asks a peer, or affects eviction order.
+func (g *Group) Contains(key string) bool {
+	if g.maxBytes() <= 0 {
+		return false
+	}
+	return g.mainCache.contains(key) || g.hotCache.contains(key)
+}
+
+// Pin exempts key from eviction, whether or not it is cached yet, so
+// that once loaded it stays cached under memory pressure. Pinned entries
+// still count against cacheBytes, so pinning more than fits makes the
+// group exceed it; they are still dropped by Remove and when they expire.
+func (g *Group) Pin(key string) {
+	g.pins.add(g.mainCache.cacheKey(key))
+}
+
+// Unpin makes key evictable again.
+func (g *Group) Unpin(key string) {
+	g.pins.remove(g.mainCache.cacheKey(key))
+}
+
+// recordFetcher remembers that the peer with ID peer fetched key from
+// us, if the group pushes invalidations.
+func (g *Group) recordFetcher(key, peer string) {
+	if g.fetchers != nil && peer != "" {
+		g.fetchers.add(key, peer)
+	}
+}
+
+// pushInvalidations removes key from the peers which fetched it since
+// it was last set or removed, or from every peer if they are unknown,
+// see GroupOptions.PushInvalidations. Only the owner of key pushes, so
+// that the peers it removes key from don't push it on.
+func (g *Group) pushInvalidations(ctx context.Context, key string) error {
+	if g.fetchers == nil {
+		return nil
+	}
+	g.peersOnce.Do(g.initPeers)
+	if g.localOnly {
+		return nil
+	}
+	if _, remote := g.pickPeer(key); remote {
+		return nil
+	}
+	fetchers, all := g.fetchers.take(key)
+	if len(fetchers) == 0 && !all {
+		return nil
+	}
+	want := make(map[string]bool, len(fetchers))
+	for _, id := range fetchers {
+		want[id] = true
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error)
+	for _, peer := range g.peers.GetAll() {
+		if !all && !want[peerID(peer)] {
+			continue
+		}
+		wg.Add(1)
+		go func(peer ProtoGetter) {
+			defer wg.Done()
+			if err := g.removeFromPeer(ctx, peer, key); err != nil {
+				errs <- err
+			}
+		}(peer)
+	}
+	go func() {
+		wg.Wait()
+		close(errs)
+	}()
+	var err error
+	for e := range errs {
+		err = e
+	}
+	return err
+}
+
+// pinSet is a set of keys which are never evicted.
+type pinSet struct {
+	mu   sync.RWMutex
+	keys map[string]bool
+}
+
+func (s *pinSet) add(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		s.keys = make(map[string]bool)
+	}
+	s.keys[key] = true
+}
+
+func (s *pinSet) remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, key)
+}
+
+func (s *pinSet) has(key string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys[key]
+}
+
+// Range calls fn with each unexpired key and value of the main cache,
+// from the most to the least recently added, until fn returns false.
+// It neither loads keys nor counts as an access to them, so it leaves
+// what is evicted next unchanged. fn is called on a copy of the entries
+// taken when Range starts, so it may call into the Group; entries of a
+// CacheShards group are listed shard by shard.
+func (g *Group) Range(fn func(key string, value ByteView) bool) {
+	keys, values := g.mainCache.entries()
+	for i, key := range keys {
+		if !fn(key, values[i]) {
+			return
+		}
+	}
+}
+
+// Touch makes key expire ttl from now, or after the group's TTL if ttl
+// is zero, if it is cached locally, for sliding expiration. Without
+// either TTL the key stops expiring. It reports whether key was cached;
+// the key is never loaded or looked up on peers.
+func (g *Group) Touch(key string, ttl time.Duration) bool {
+	if g.maxBytes() <= 0 {
+		return false
+	}
+	if ttl <= 0 {
+		ttl = g.opts.TTL
+	}
+	var expire time.Time
+	if ttl > 0 {
+		expire = g.opts.Clock().Add(ttl)
+	}
+	main := g.mainCache.touch(key, expire)
+	hot := g.hotCache.touch(key, expire)
+	return main || hot
 }
 
 func (g *Group) localSet(key string, value []byte, cache *cache) {
-	if g.cacheBytes <= 0 {
+	g.localSetVersion(key, value, 0, cache)
+}
+
+// localSetVersion caches value unless a higher version of key is
+// cached, and reports whether it did.
+func (g *Group) localSetVersion(key string, value []byte, version int64, cache *cache) bool {
+	if g.recent != nil {
+		g.recent.forget(key)
+	}
+	if g.notFound != nil {
+		g.notFound.forget(key)
+	}
+	if g.maxBytes() <= 0 {
+		return true
+	}
+
+	bv := ByteView{b: value}.withVersion(version, "")
+
+	// Ensure no requests are in flight
+	ok := true
+	g.loadGroup.Lock(func() {
+		ok = g.populateCache(key, bv, cache)
+	})
+	return ok
+}
+
+func (g *Group) invalidateTagOnPeer(ctx context.Context, peer ProtoGetter, tag string) error {
+	invalidator, ok := peer.(TagInvalidator)
+	if !ok {
+		return fmt.Errorf("groupcache: peer %s does not support InvalidateTag", peer.GetURL())
+	}
+	req := &pb.GetRequest{
+		Group: &g.name,
+		Key:   &tag,
+	}
+	return g.callPeer(ctx, func() error { return invalidator.InvalidateTag(ctx, req) })
+}
+
+func (g *Group) localInvalidateTag(tag string) {
+	if g.recent != nil && g.opts.Tags != nil {
+		g.recent.forgetFunc(func(key string, value ByteView) bool {
+			for _, t := range g.opts.Tags(key, value) {
+				if t == tag {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	if g.maxBytes() <= 0 {
 		return
 	}
 
-	bv := ByteView{
-		b: value,
+	// Ensure no requests are in flight
+	g.loadGroup.Lock(func() {
+		g.hotCache.removeTag(tag)
+		g.mainCache.removeTag(tag)
+	})
+}
+
+func (g *Group) removePrefixFromPeer(ctx context.Context, peer ProtoGetter, prefix string) error {
+	remover, ok := peer.(PrefixRemover)
+	if !ok {
+		return fmt.Errorf("groupcache: peer %s does not support RemoveByPrefix", peer.GetURL())
+	}
+	req := &pb.GetRequest{
+		Group: &g.name,
+		Key:   &prefix,
+	}
+	return g.callPeer(ctx, func() error { return remover.RemovePrefix(ctx, req) })
+}
+
+func (g *Group) localRemovePrefix(prefix string) {
+	if g.recent != nil {
+		g.recent.forgetPrefix(prefix)
+	}
+	if g.notFound != nil {
+		g.notFound.forgetPrefix(prefix)
+	}
+	if g.maxBytes() <= 0 {
+		return
 	}
 
 	// Ensure no requests are in flight
+	hasPrefix := func(key string) bool { return strings.HasPrefix(key, prefix) }
 	g.loadGroup.Lock(func() {
-		g.populateCache(key, bv, cache)
+		g.hotCache.removeFunc(hasPrefix)
+		g.mainCache.removeFunc(hasPrefix)
 	})
 }
 
 func (g *Group) localRemove(key string) {
+	if g.recent != nil {
+		g.recent.forget(key)
+	}
+	if g.notFound != nil {
+		g.notFound.forget(key)
+	}
 	// Clear key from our local cache
-	if g.cacheBytes <= 0 {
+	if g.maxBytes() <= 0 {
 		return
 	}
 
@@ -522,28 +2528,174 @@ func (g *Group) localRemove(key string) {
 	})
 }
 
-func (g *Group) populateCache(key string, value ByteView, cache *cache) {
-	if g.cacheBytes <= 0 {
-		return
+// etag returns the ETag of value, hashed with the group's seed.
+func (g *Group) etag(value ByteView) string {
+	return value.etagSeed(g.opts.HashSeed)
+}
+
+// populateCache adds value to cache, evicting other entries as needed.
+// It reports false, leaving cache unchanged, if a higher version of key
+// is cached.
+func (g *Group) populateCache(key string, value ByteView, cache *cache) bool {
+	if g.maxBytes() <= 0 {
+		return true
+	}
+	now := g.opts.Clock()
+	value = value.withEntry(func(e *entry) {
+		e.added = now
+		if e.expire.IsZero() && g.opts.TTL > 0 {
+			e.expire = now.Add(g.jitter(g.opts.TTL))
+		}
+		e.setExtra(func(x *entryExtra) {
+			if g.opts.Weight != nil {
+				x.weight = g.opts.Weight(key, value)
+			}
+			if g.opts.Derive != nil {
+				x.derived = g.opts.Derive(key, value)
+			}
+			if g.opts.Tags != nil {
+				x.tags = g.opts.Tags(key, value)
+			}
+		})
+	})
+	if !cache.add(key, value) {
+		return false
+	}
+	g.evict()
+	return true
+}
+
+// jitter returns ttl moved by a random amount of up to TTLJitter of it.
+func (g *Group) jitter(ttl time.Duration) time.Duration {
+	j := g.opts.TTLJitter
+	if j <= 0 || j >= 1 {
+		return ttl
 	}
-	cache.add(key, value)
+	return ttl + time.Duration((2*g.opts.Rand()-1)*j*float64(ttl))
+}
 
-	// Evict items from cache(s) if necessary.
+// evict evicts items from the cache(s) until they fit in cacheBytes.
+func (g *Group) evict() {
+	limit := g.maxBytes()
 	for {
 		mainBytes := g.mainCache.bytes()
 		hotBytes := g.hotCache.bytes()
-		if mainBytes+hotBytes <= g.cacheBytes {
+		if mainBytes+hotBytes <= limit || mainBytes+hotBytes == 0 {
 			return
 		}
 
-		// TODO(bradfitz): this is good-enough-for-now logic.
-		// It should be something based on measurements and/or
-		// respecting the costs of different resources.
-		victim := &g.mainCache
-		if hotBytes > mainBytes/8 {
-			victim = &g.hotCache
+		victim := g.victimCache(mainBytes, hotBytes)
+		if !victim.removeOldest() {
+			// Everything left in victim is pinned.
+			other := &g.mainCache
+			if victim == other {
+				other = &g.hotCache
+			}
+			if !other.removeOldest() {
+				return
+			}
 		}
-		victim.removeOldest()
+	}
+}
+
+// maxBytes returns the limit for the sum of the main and hot cache size.
+func (g *Group) maxBytes() int64 {
+	return atomic.LoadInt64(&g.cacheBytes)
+}
+
+// OnEvict makes fn be called with the key and value of each entry the
+// main cache evicts to make room, such as to persist or log values on
+// their way out, replacing any function passed before; nil stops the
+// calls. Entries dropped by Remove, Set or expiry are not passed to it.
+// fn runs while the cache is locked, so it must return quickly and must
+// not call back into the Group.
+func (g *Group) OnEvict(fn func(key string, value ByteView)) {
+	g.mainCache.setEvicted(fn)
+}
+
+// OnHotEvict is OnEvict for the hot cache.
+func (g *Group) OnHotEvict(fn func(key string, value ByteView)) {
+	g.hotCache.setEvicted(fn)
+}
+
+// SetCacheBytes changes the limit for the sum of the main and hot cache
+// size, evicting entries right away if they no longer fit. The hot cache
+// keeps its share of the new limit, as it does when caching new entries.
+// A limit of zero or less disables caching, dropping every entry. In a
+// MemoryCoordinator, the group gets its share of the new limit instead.
+func (g *Group) SetCacheBytes(n int64) {
+	atomic.StoreInt64(&g.wantBytes, n)
+	memoryMu.Lock()
+	c := g.memory
+	if c == nil {
+		atomic.StoreInt64(&g.cacheBytes, n)
+	}
+	memoryMu.Unlock()
+	if c != nil {
+		c.rebalance()
+		return
+	}
+	g.evict()
+}
+
+// CacheBytes returns the limit for the sum of the main and hot cache
+// size in effect, which is below the one the group was given while its
+// MemoryCoordinator is over its limit.
+func (g *Group) CacheBytes() int64 {
+	return g.maxBytes()
+}
+
+// victimCache returns the cache to evict from given the current size of
+// the main and hot caches.
+func (g *Group) victimCache(mainBytes, hotBytes int64) *cache {
+	// TODO(bradfitz): this is good-enough-for-now logic.
+	// It should be something based on measurements and/or
+	// respecting the costs of different resources.
+	if hotBytes > mainBytes/8 {
+		return &g.hotCache
+	}
+	return &g.mainCache
+}
+
+// admit reports whether a loaded value should be cached. Without an
+// admission filter, or when caching the value evicts nothing, every
+// value is admitted.
+func (g *Group) admit(key string, value ByteView) bool {
+	if g.admission == nil || g.maxBytes() <= 0 {
+		return true
+	}
+	mainBytes := g.mainCache.bytes()
+	hotBytes := g.hotCache.bytes()
+	if mainBytes+hotBytes+int64(len(key)+value.Len()) <= g.maxBytes() {
+		return true
+	}
+	victim, ok := g.victimCache(mainBytes, hotBytes).peekVictim()
+	if !ok {
+		return true
+	}
+	return g.admission.admit(key, victim)
+}
+
+// LoadSource is where a load got a value from.
+type LoadSource int
+
+const (
+	// A LocalLoad is a load by the group's Getter.
+	LocalLoad LoadSource = iota + 1
+
+	// A PeerLoad is a load from the peer owning the key.
+	PeerLoad
+)
+
+// String returns "local" or "peer".
+func (s LoadSource) String() string {
+	switch s {
+	case LocalLoad:
+		return "local"
+	case PeerLoad:
+		return "peer"
+	default:
+		return "LoadSource(" + strconv.Itoa(int(s)) + ")"
 	}
 }
 
@@ -561,6 +2713,18 @@ const (
 	HotCache
 )
 
+// String returns "main" or "hot".
+func (t CacheType) String() string {
+	switch t {
+	case MainCache:
+		return "main"
+	case HotCache:
+		return "hot"
+	default:
+		return "CacheType(" + strconv.Itoa(int(t)) + ")"
+	}
+}
+
 // CacheStats returns stats about the provided cache within the group.
 func (g *Group) CacheStats(which CacheType) CacheStats {
 	switch which {
@@ -573,6 +2737,167 @@ func (g *Group) CacheStats(which CacheType) CacheStats {
 	}
 }
 
+// GroupStats combines the counters of a group with the stats of its
+// caches, for exporting them all at once.
+type GroupStats struct {
+	Name      string
+	Group     StatsSnapshot
+	MainCache CacheStats
+	HotCache  CacheStats
+}
+
+// AllStats returns the stats of the group and of both of its caches.
+// Like Stats.Snapshot, it reads them one after the other rather than
+// all at the same instant.
+func (g *Group) AllStats() GroupStats {
+	return GroupStats{
+		Name:      g.name,
+		Group:     g.Stats.Snapshot(),
+		MainCache: g.CacheStats(MainCache),
+		HotCache:  g.CacheStats(HotCache),
+	}
+}
+
+// sourceOf returns the source to record in the cache entries fetched
+// from peer, which is empty unless an option needs it.
+func (g *Group) sourceOf(peer ProtoGetter) string {
+	if g.opts.TrackEntryInfo || g.hotCache.tenant != nil {
+		return peerID(peer)
+	}
+	return ""
+}
+
+// EntryInfo describes a cache entry, for debugging.
+type EntryInfo struct {
+	// Cache is the cache holding the entry.
+	Cache CacheType
+	// Source is PeerLoad if the value was fetched from Peer, and
+	// LocalLoad if it was loaded or set by this process.
+	Source LoadSource
+	Peer   string
+	// Added is when the entry was stored, and Expire when it expires,
+	// or the zero time if it doesn't.
+	Added  time.Time
+	Expire time.Time
+	// Version is the version the value was Set with, if any.
+	Version int64
+	Bytes   int64
+}
+
+// EntryInfo returns where the unexpired entry of key in the main or hot
+// cache came from and when. It reports false if key is not cached, or
+// if GroupOptions.TrackEntryInfo is not set.
+func (g *Group) EntryInfo(key string) (*EntryInfo, bool) {
+	if !g.opts.TrackEntryInfo || g.maxBytes() <= 0 {
+		return nil, false
+	}
+	which := MainCache
+	value, ok := g.mainCache.peek(key)
+	if !ok {
+		which = HotCache
+		if value, ok = g.hotCache.peek(key); !ok {
+			return nil, false
+		}
+	}
+	info := &EntryInfo{
+		Cache:   which,
+		Source:  LocalLoad,
+		Peer:    value.source(),
+		Added:   value.added(),
+		Expire:  value.Expire(),
+		Version: value.Version(),
+		Bytes:   int64(len(key) + value.Len()),
+	}
+	if value.source() != "" {
+		info.Source = PeerLoad
+	}
+	return info, true
+}
+
+// EvictionRate returns how many entries per second the main and hot
+// caches evicted to make room over the last window, which is rounded to
+// whole seconds up to a minute. A high rate means the working set does
+// not fit in the cache, which may call for shedding load. Unlike
+// CacheStats.Evictions, it does not count removed or expired entries.
+func (g *Group) EvictionRate(window time.Duration) float64 {
+	return g.evictions.rate(g.opts.Clock(), window)
+}
+
+// AgeStats describes how long ago the live entries of a group were
+// cached, by the percentiles of their ages.
+type AgeStats struct {
+	Items int64
+	P50   time.Duration
+	P90   time.Duration
+	Max   time.Duration
+}
+
+// AgeStats returns the age distribution of the unexpired entries in the
+// main and hot caches, where an entry's age is the time since it was
+// last added. It walks every entry, so it is meant for occasional
+// inspection such as tuning TTLs rather than for frequent polling.
+func (g *Group) AgeStats() AgeStats {
+	now := g.opts.Clock()
+	ages := append(g.mainCache.ages(now), g.hotCache.ages(now)...)
+	if len(ages) == 0 {
+		return AgeStats{}
+	}
+	sort.Slice(ages, func(i, j int) bool { return ages[i] < ages[j] })
+	// percentile returns the nearest-rank percentile.
+	percentile := func(p int) time.Duration {
+		return ages[(len(ages)*p+99)/100-1]
+	}
+	return AgeStats{
+		Items: int64(len(ages)),
+		P50:   percentile(50),
+		P90:   percentile(90),
+		Max:   ages[len(ages)-1],
+	}
+}
+
+// SizeStats describes the sizes in bytes of the values cached by a
+// group, by their mean and percentiles.
+type SizeStats struct {
+	Items int64
+	Min   int64
+	Mean  float64
+	P50   int64
+	P90   int64
+	P99   int64
+	Max   int64
+}
+
+// SizeStats returns the size distribution of the unexpired values in the
+// main cache, not counting their keys. Like AgeStats, it walks every
+// entry, so it is meant for occasional inspection such as choosing
+// cacheBytes rather than for frequent polling.
+func (g *Group) SizeStats() SizeStats {
+	_, values := g.mainCache.entries()
+	if len(values) == 0 {
+		return SizeStats{}
+	}
+	sizes := make([]int64, len(values))
+	var total int64
+	for i, v := range values {
+		sizes[i] = int64(v.Len())
+		total += sizes[i]
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	// percentile returns the nearest-rank percentile.
+	percentile := func(p int) int64 {
+		return sizes[(len(sizes)*p+99)/100-1]
+	}
+	return SizeStats{
+		Items: int64(len(sizes)),
+		Min:   sizes[0],
+		Mean:  float64(total) / float64(len(sizes)),
+		P50:   percentile(50),
+		P90:   percentile(90),
+		P99:   percentile(99),
+		Max:   sizes[len(sizes)-1],
+	}
+}
+
 // cache is a wrapper around an *lru.Cache that adds synchronization,
 // makes values always be ByteView, and counts the size of all keys and
 // values.
@@ -582,9 +2907,81 @@ type cache struct {
 	lru        *lru.Cache
 	nhit, nget int64
 	nevict     int64 // number of evictions
+
+	// onEvict, if non-nil, is called with the key and size of each
+	// evicted entry.
+	onEvict func(key string, bytes int)
+
+	// evicted, if non-nil, is called with each entry evicted to make
+	// room, see Group.OnEvict.
+	evicted func(key string, value ByteView)
+
+	// now returns the current time, to expire entries.
+	now func() time.Time
+
+	// maxStale is how long expired entries are kept for getStale.
+	maxStale time.Duration
+
+	// slide, if positive, is how far a hit pushes back the expiry of
+	// entries which have one.
+	slide time.Duration
+
+	// credits holds, for entries weighing more than 1, how many more
+	// times the eviction hand passes them over.
+	credits map[string]int
+
+	// evictions, if non-nil, counts the entries evicted to make room.
+	evictions *rateCounter
+
+	// pins holds the keys which are never evicted.
+	pins *pinSet
+
+	// policy, if non-nil, chooses the entries to evict instead of lru.
+	policy EvictionPolicy
+
+	// tenant, if non-nil, maps entries to their tenant, whose entries
+	// are limited to tenantBudget bytes, if positive. tenantBytes holds
+	// the size of each tenant's entries, tenantLists their tenantEntry
+	// elements, least recently used first, and tenantElems the element
+	// of each key.
+	tenant       func(key string, value ByteView) string
+	tenantBudget func(tenant string) int64
+	tenantBytes  map[string]int64
+	tenantLists  map[string]*list.List
+	tenantElems  map[string]*list.Element
+
+	// shards, if non-nil, hold the entries of the cache, which only
+	// holds their configuration, see GroupOptions.CacheShards.
+	shards []*cache
+
+	// tagged indexes the keys of the entries by each of their tags.
+	tagged map[string]map[string]bool
+
+	// fingerprint, if non-nil, maps keys to the keys their entries are
+	// stored under, see GroupOptions.KeyFingerprint. Shards are handed
+	// keys which are already mapped.
+	fingerprint func(key string) string
+}
+
+// FingerprintKey returns the 16 byte xxh3 hash of key, for use as
+// GroupOptions.KeyFingerprint.
+func FingerprintKey(key string) string {
+	b := xxh3.HashString128(key).Bytes()
+	return string(b[:])
+}
+
+// cacheKey returns the key the entry of key is stored under.
+func (c *cache) cacheKey(key string) string {
+	if c.fingerprint != nil {
+		return c.fingerprint(key)
+	}
+	return key
 }
 
 func (c *cache) stats() CacheStats {
+	if c.shards != nil {
+		return c.shardStats()
+	}
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	return CacheStats{
@@ -596,23 +2993,150 @@ func (c *cache) stats() CacheStats {
 	}
 }
 
-func (c *cache) add(key string, value ByteView) {
+// add adds value to the cache, replacing any cached value of key, and
+// reports true. If value is versioned and a higher version of key is
+// cached it only reports false instead.
+func (c *cache) add(key string, value ByteView) bool {
+	var tenant string
+	if c.tenant != nil {
+		// The tenant is picked by the full key rather than the one the
+		// entry is stored under.
+		tenant = c.tenant(key, value)
+	}
+	return c.addTenant(c.cacheKey(key), value, tenant)
+}
+
+// addTenant is add for a key already mapped by cacheKey, accounting the
+// entry to tenant if the cache has tenants.
+func (c *cache) addTenant(key string, value ByteView, tenant string) bool {
+	if c.shards != nil {
+		return c.shard(key).addTenant(key, value, tenant)
+	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.lru == nil {
 		c.lru = &lru.Cache{
 			OnEvicted: func(key lru.Key, value interface{}) {
 				val := value.(ByteView)
-				c.nbytes -= int64(len(key.(string))) + int64(val.Len())
+				size := len(key.(string)) + val.Len()
+				c.nbytes -= int64(size)
 				c.nevict++
+				delete(c.credits, key.(string))
+				if c.policy != nil {
+					c.policy.Removed(key.(string))
+				}
+				if c.tenant != nil {
+					c.untenantLocked(key.(string))
+				}
+				c.untagLocked(key.(string), val)
+				if c.onEvict != nil {
+					c.onEvict(key.(string), size)
+				}
 			},
 		}
 	}
+	if vi, ok := c.lru.Peek(key); ok {
+		old := vi.(ByteView)
+		// Version zero is unversioned, which always replaces.
+		if value.Version() != 0 && old.Version() > value.Version() && !c.expired(old) {
+			return false
+		}
+		c.nbytes -= int64(len(key)) + int64(old.Len())
+		if c.tenant != nil {
+			c.untenantLocked(key)
+		}
+		c.untagLocked(key, old)
+	}
 	c.lru.Add(key, value)
+	c.tagLocked(key, value)
 	c.nbytes += int64(len(key)) + int64(value.Len())
+	if w := value.weight(); w > 1 {
+		if c.credits == nil {
+			c.credits = make(map[string]int)
+		}
+		c.credits[key] = w - 1
+	} else {
+		delete(c.credits, key)
+	}
+	if c.policy != nil {
+		c.policy.Added(key)
+	}
+	if c.tenant != nil {
+		c.tenantLocked(key, tenant, int64(len(key)+value.Len()))
+		c.evictTenantLocked(tenant)
+	}
+	return true
+}
+
+// tenantEntry is an element of a tenant's list of entries.
+type tenantEntry struct {
+	key    string
+	tenant string
+	size   int64
+}
+
+// tenantLocked accounts the entry of key, of size bytes, to tenant, as
+// its most recently used entry.
+func (c *cache) tenantLocked(key, tenant string, size int64) {
+	if c.tenantBytes == nil {
+		c.tenantBytes = make(map[string]int64)
+		c.tenantLists = make(map[string]*list.List)
+		c.tenantElems = make(map[string]*list.Element)
+	}
+	l := c.tenantLists[tenant]
+	if l == nil {
+		l = list.New()
+		c.tenantLists[tenant] = l
+	}
+	c.tenantElems[key] = l.PushBack(&tenantEntry{key: key, tenant: tenant, size: size})
+	c.tenantBytes[tenant] += size
+}
+
+// untenantLocked removes the entry of key from its tenant's accounting.
+func (c *cache) untenantLocked(key string) {
+	e, ok := c.tenantElems[key]
+	if !ok {
+		return
+	}
+	te := e.Value.(*tenantEntry)
+	delete(c.tenantElems, key)
+	l := c.tenantLists[te.tenant]
+	l.Remove(e)
+	c.tenantBytes[te.tenant] -= te.size
+	if l.Len() == 0 {
+		delete(c.tenantLists, te.tenant)
+		delete(c.tenantBytes, te.tenant)
+	}
+}
+
+// evictTenantLocked evicts the least recently used entries of tenant
+// until its entries fit its budget. Only the tenant's own entries are
+// visited.
+func (c *cache) evictTenantLocked(tenant string) {
+	budget := c.tenantBudget(tenant)
+	excess := c.tenantBytes[tenant] - budget
+	if budget <= 0 || excess <= 0 {
+		return
+	}
+	var victims []string
+	for e := c.tenantLists[tenant].Front(); e != nil && excess > 0; e = e.Next() {
+		te := e.Value.(*tenantEntry)
+		if !c.pins.has(te.key) {
+			victims = append(victims, te.key)
+			excess -= te.size
+		}
+	}
+	for _, key := range victims {
+		c.evictLocked(key)
+	}
+	c.countEviction(len(victims))
 }
 
 func (c *cache) get(key string) (value ByteView, ok bool) {
+	key = c.cacheKey(key)
+	if c.shards != nil {
+		return c.shard(key).get(key)
+	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.nget++
@@ -623,11 +3147,211 @@ func (c *cache) get(key string) (value ByteView, ok bool) {
 	if !ok {
 		return
 	}
+	value = vi.(ByteView)
+	if c.expired(value) {
+		if !c.servableStale(value) {
+			c.lru.Remove(key)
+		}
+		return ByteView{}, false
+	}
+	if c.policy != nil {
+		c.policy.Accessed(key)
+	}
+	if e, ok := c.tenantElems[key]; ok {
+		c.tenantLists[e.Value.(*tenantEntry).tenant].MoveToBack(e)
+	}
+	if c.slide > 0 && !value.Expire().IsZero() {
+		expire := c.clock().Add(c.slide)
+		value = value.withEntry(func(e *entry) { e.expire = expire })
+		c.lru.Add(key, value)
+	}
 	c.nhit++
+	return value, true
+}
+
+// touch sets the expiry of key, if it is cached and unexpired.
+func (c *cache) touch(key string, expire time.Time) bool {
+	key = c.cacheKey(key)
+	if c.shards != nil {
+		return c.shard(key).touch(key, expire)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return false
+	}
+	vi, ok := c.lru.Peek(key)
+	if !ok || c.expired(vi.(ByteView)) {
+		return false
+	}
+	value := vi.(ByteView).withEntry(func(e *entry) { e.expire = expire })
+	c.lru.Add(key, value)
+	return true
+}
+
+// getStale returns the value of key even if it expired, as long as it
+// expired less than maxStale ago.
+func (c *cache) getStale(key string) (value ByteView, ok bool) {
+	key = c.cacheKey(key)
+	if c.shards != nil {
+		return c.shard(key).getStale(key)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.lru == nil {
+		return
+	}
+	vi, ok := c.lru.Peek(key)
+	if !ok {
+		return
+	}
+	value = vi.(ByteView)
+	if c.expired(value) && !c.servableStale(value) {
+		return ByteView{}, false
+	}
+	return value, true
+}
+
+// getExpired returns the value of key if it expired less than maxStale
+// ago, without affecting eviction.
+func (c *cache) getExpired(key string, maxStale time.Duration) (value ByteView, ok bool) {
+	key = c.cacheKey(key)
+	if c.shards != nil {
+		return c.shard(key).getExpired(key, maxStale)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.lru == nil {
+		return
+	}
+	vi, ok := c.lru.Peek(key)
+	if !ok {
+		return
+	}
+	value = vi.(ByteView)
+	if !c.expired(value) || !c.clock().Before(value.Expire().Add(maxStale)) {
+		return ByteView{}, false
+	}
+	return value, true
+}
+
+func (c *cache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// expired reports whether value has outlived its TTL.
+func (c *cache) expired(value ByteView) bool {
+	expire := value.Expire()
+	if expire.IsZero() {
+		return false
+	}
+	return !c.clock().Before(expire)
+}
+
+// servableStale reports whether an expired value may still be served by
+// getStale.
+func (c *cache) servableStale(value ByteView) bool {
+	return c.maxStale > 0 && c.clock().Before(value.Expire().Add(c.maxStale))
+}
+
+func (c *cache) contains(key string) bool {
+	_, ok := c.peek(key)
+	return ok
+}
+
+// peek returns the unexpired value of key without affecting eviction.
+func (c *cache) peek(key string) (value ByteView, ok bool) {
+	key = c.cacheKey(key)
+	if c.shards != nil {
+		return c.shard(key).peek(key)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.lru == nil {
+		return
+	}
+	vi, ok := c.lru.Peek(key)
+	if !ok || c.expired(vi.(ByteView)) {
+		return ByteView{}, false
+	}
 	return vi.(ByteView), true
 }
 
+// keys returns up to limit unexpired keys from the most to the least
+// recently added. A limit of zero returns every key.
+func (c *cache) keys(limit int64) []string {
+	if c.shards != nil {
+		return c.shardKeys(limit)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.lru == nil {
+		return nil
+	}
+	var res []string
+	c.lru.Each(func(key lru.Key, vi interface{}) bool {
+		if !c.expired(vi.(ByteView)) {
+			res = append(res, key.(string))
+		}
+		return limit <= 0 || int64(len(res)) < limit
+	})
+	return res
+}
+
+// entries returns the unexpired entries from the most to the least
+// recently added, without affecting eviction.
+func (c *cache) entries() (keys []string, values []ByteView) {
+	if c.shards != nil {
+		for _, shard := range c.shards {
+			k, v := shard.entries()
+			keys, values = append(keys, k...), append(values, v...)
+		}
+		return keys, values
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.lru == nil {
+		return nil, nil
+	}
+	c.lru.Each(func(key lru.Key, vi interface{}) bool {
+		if value := vi.(ByteView); !c.expired(value) {
+			keys = append(keys, key.(string))
+			values = append(values, value)
+		}
+		return true
+	})
+	return keys, values
+}
+
+// ages returns how long before now each unexpired entry was added.
+func (c *cache) ages(now time.Time) []time.Duration {
+	if c.shards != nil {
+		return c.shardAges(now)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.lru == nil {
+		return nil
+	}
+	var res []time.Duration
+	c.lru.Each(func(_ lru.Key, vi interface{}) bool {
+		if value := vi.(ByteView); !c.expired(value) {
+			res = append(res, now.Sub(value.added()))
+		}
+		return true
+	})
+	return res
+}
+
 func (c *cache) remove(key string) {
+	key = c.cacheKey(key)
+	if c.shards != nil {
+		c.shard(key).remove(key)
+		return
+	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.lru == nil {
@@ -636,21 +3360,168 @@ func (c *cache) remove(key string) {
 	c.lru.Remove(key)
 }
 
-func (c *cache) removeOldest() {
+// removeFunc removes every key for which fn returns true and returns
+// the number of keys removed.
+func (c *cache) removeFunc(fn func(key string) bool) int {
+	if c.shards != nil {
+		return c.shardRemoveFunc(fn)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return 0
+	}
+	var keys []string
+	c.lru.Each(func(key lru.Key, _ interface{}) bool {
+		if fn(key.(string)) {
+			keys = append(keys, key.(string))
+		}
+		return true
+	})
+	for _, key := range keys {
+		c.lru.Remove(key)
+	}
+	return len(keys)
+}
+
+// removeOldest evicts the next victim, reporting false if there is no
+// entry which may be evicted.
+func (c *cache) removeOldest() bool {
+	if c.shards != nil {
+		return c.shardRemoveOldest()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key, ok := c.victimLocked()
+	if !ok {
+		return false
+	}
+	c.evictLocked(key)
+	c.countEviction(1)
+	return true
+}
+
+// evictLocked removes key to make room, passing it to c.evicted.
+func (c *cache) evictLocked(key string) {
+	if c.evicted != nil {
+		if vi, ok := c.lru.Peek(key); ok {
+			c.evicted(key, vi.(ByteView))
+		}
+	}
+	c.lru.Remove(key)
+}
+
+func (c *cache) setEvicted(fn func(key string, value ByteView)) {
+	if c.shards != nil {
+		for _, shard := range c.shards {
+			shard.setEvicted(fn)
+		}
+		return
+	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if c.lru != nil {
-		c.lru.RemoveOldest()
+	c.evicted = fn
+}
+
+// countEviction records n evictions for Group.EvictionRate.
+func (c *cache) countEviction(n int) {
+	if c.evictions != nil {
+		c.evictions.add(c.clock(), int64(n))
+	}
+}
+
+// peekVictim returns the entry likely to be evicted next, without the
+// side effects of looking for it: no credits are spent, no entries are
+// marked visited and the hand doesn't move. It is the unpinned entry
+// the hand would reach with the fewest passes left, counting credits
+// and visited flags. With an EvictionPolicy it is the policy's victim.
+func (c *cache) peekVictim() (key string, ok bool) {
+	if c.shards != nil {
+		return c.largestShard().peekVictim()
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.lru == nil {
+		return "", false
+	}
+	if c.policy != nil {
+		return c.policy.Victim()
+	}
+	passes := 0
+	c.lru.EachFromHand(func(k lru.Key, _ interface{}, visited bool) bool {
+		if c.pins.has(k.(string)) {
+			return true
+		}
+		n := c.credits[k.(string)]
+		if visited {
+			n++
+		}
+		if !ok || n < passes {
+			key, passes, ok = k.(string), n, true
+		}
+		return passes > 0
+	})
+	return key, ok
+}
+
+// victimLocked returns the entry to evict next. Entries with credits
+// left spend one and pinned entries are marked visited, so the hand
+// passes them over. It reports false if every entry is pinned.
+func (c *cache) victimLocked() (key string, ok bool) {
+	if c.lru == nil {
+		return
+	}
+	// pinned counts the pinned entries passed over since the last
+	// unpinned one, so that the hand gives up only after a full pass
+	// found nothing but pins.
+	pinned := 0
+	for {
+		key, ok := c.nextVictimLocked()
+		if !ok {
+			return "", false
+		}
+		if c.pins.has(key) {
+			if pinned++; pinned > c.lru.Len() {
+				return "", false
+			}
+		} else if c.credits[key] == 0 {
+			return key, true
+		} else {
+			c.credits[key]--
+			pinned = 0
+		}
+		c.lru.Get(key)
+		if c.policy != nil {
+			c.policy.Accessed(key)
+		}
 	}
 }
 
+// nextVictimLocked returns the entry the eviction policy picks.
+func (c *cache) nextVictimLocked() (key string, ok bool) {
+	if c.policy != nil {
+		return c.policy.Victim()
+	}
+	k, _, ok := c.lru.Victim()
+	if !ok {
+		return "", false
+	}
+	return k.(string), true
+}
+
 func (c *cache) bytes() int64 {
+	if c.shards != nil {
+		return c.shardBytes()
+	}
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	return c.nbytes
 }
 
 func (c *cache) items() int64 {
+	if c.shards != nil {
+		return c.shardItems()
+	}
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	return c.itemsLocked()
@@ -19,18 +19,28 @@ limitations under the License.
 package groupcache
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 	"unsafe"
 
 	"github.com/golang/protobuf/proto"
 	pb "github.com/xdbbe/groupcache/v2/groupcachepb"
+	"github.com/xdbbe/groupcache/v2/singleflight"
 	"github.com/xdbbe/groupcache/v2/testpb"
 )
 
@@ -370,6 +380,14 @@ func (g *orderedFlightGroup) Do(key string, fn func() (interface{}, error)) (int
 	return g.orig.Do(key, fn)
 }
 
+func (g *orderedFlightGroup) DoChan(key string, fn func() (interface{}, error)) <-chan singleflight.Result {
+	<-g.stage1
+	<-g.stage2
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.orig.DoChan(key, fn)
+}
+
 func (g *orderedFlightGroup) Lock(fn func()) {
 	fn()
 }
@@ -476,3 +494,3278 @@ func TestContextDeadlineOnPeer(t *testing.T) {
 		}
 	}
 }
+
+func TestNilGetter(t *testing.T) {
+	g := newGroup("TestNilGetter-group", cacheSize, nil, NoPeers{})
+
+	var got string
+	err := g.Get(dummyCtx, "key", StringSink(&got))
+	if !errors.Is(err, &ErrNoGetter{}) {
+		t.Fatalf("expected ErrNoGetter; got %v", err)
+	}
+	if g.Stats.LocalLoadErrs.Get() != 1 {
+		t.Errorf("expected 1 local load error; got %d", g.Stats.LocalLoadErrs.Get())
+	}
+}
+
+func TestLocalOnly(t *testing.T) {
+	peer := &fakePeer{}
+	getter := GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return dest.SetString("got:" + key)
+	})
+
+	g := newGroupOpts("TestLocalOnly-group", cacheSize, getter, fakePeers{peer}, &GroupOptions{LocalOnly: true})
+	if !g.LocalOnly() {
+		t.Fatal("expected group with LocalOnly option to be local only")
+	}
+	for _, key := range testKeys(10) {
+		var got string
+		if err := g.Get(dummyCtx, key, StringSink(&got)); err != nil {
+			t.Fatal(err)
+		}
+		if want := "got:" + key; got != want {
+			t.Errorf("for key %q, got %q; want %q", key, got, want)
+		}
+	}
+	if peer.hits != 0 {
+		t.Errorf("expected no peer hits in local only mode; got %d", peer.hits)
+	}
+
+	if g := newGroup("TestLocalOnly-nopeers", cacheSize, getter, NoPeers{}); !g.LocalOnly() {
+		t.Error("expected group without peers to be local only")
+	}
+	if g := newGroup("TestLocalOnly-peers", cacheSize, getter, fakePeers{peer}); g.LocalOnly() {
+		t.Error("expected group with peers not to be local only")
+	}
+}
+
+func BenchmarkGetLocalOnly(b *testing.B) {
+	benchmarkGetLocal(b, NoPeers{})
+}
+
+func BenchmarkGetPeerPicker(b *testing.B) {
+	// Every key is owned by self, so each Get still goes through the ring.
+	p := newHTTPPoolOpts("http://self", nil)
+	p.Set("http://self")
+	benchmarkGetLocal(b, p)
+}
+
+func benchmarkGetLocal(b *testing.B, peers PeerPicker) {
+	const name = "benchmarkGetLocal-group"
+	// A cache size of zero makes every Get a load.
+	g := newGroup(name, 0, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return dest.SetString(key)
+	}), peers)
+	defer DeregisterGroup(name)
+
+	var s string
+	sink := StringSink(&s)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := g.Get(dummyCtx, "key", sink); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSinkReset(t *testing.T) {
+	once.Do(testSetup)
+
+	var s string
+	sink := StringSink(&s)
+	if err := stringGroup.Get(dummyCtx, "TestSinkReset-a", sink); err != nil {
+		t.Fatal(err)
+	}
+	sink.Reset()
+	if s != "" {
+		t.Errorf("expected Reset to clear the string; got %q", s)
+	}
+	if v, _ := sink.view(); v.Len() != 0 {
+		t.Errorf("expected Reset to clear the view; got %q", v.String())
+	}
+	if err := stringGroup.Get(dummyCtx, "TestSinkReset-b", sink); err != nil {
+		t.Fatal(err)
+	}
+	if want := "ECHO:TestSinkReset-b"; s != want {
+		t.Errorf("reused sink got %q; want %q", s, want)
+	}
+
+	var b []byte
+	bsink := AllocatingByteSliceSink(&b)
+	if err := stringGroup.Get(dummyCtx, "TestSinkReset-a", bsink); err != nil {
+		t.Fatal(err)
+	}
+	bsink.Reset()
+	if b != nil {
+		t.Errorf("expected Reset to clear the byte slice; got %q", b)
+	}
+
+	tm := new(testpb.TestMessage)
+	psink := ProtoSink(tm)
+	if err := protoGroup.Get(dummyCtx, "TestSinkReset-a", psink); err != nil {
+		t.Fatal(err)
+	}
+	psink.Reset()
+	if tm.Name != nil {
+		t.Errorf("expected Reset to clear the proto; got %v", tm)
+	}
+}
+
+func BenchmarkStringSink(b *testing.B) {
+	once.Do(testSetup)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var s string
+		if err := stringGroup.Get(dummyCtx, "BenchmarkStringSink", StringSink(&s)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStringSinkPooled(b *testing.B) {
+	once.Do(testSetup)
+	type pooledSink struct {
+		s    string
+		sink Sink
+	}
+	pool := sync.Pool{New: func() interface{} {
+		p := &pooledSink{}
+		p.sink = StringSink(&p.s)
+		return p
+	}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p := pool.Get().(*pooledSink)
+		if err := stringGroup.Get(dummyCtx, "BenchmarkStringSink", p.sink); err != nil {
+			b.Fatal(err)
+		}
+		p.sink.Reset()
+		pool.Put(p)
+	}
+}
+
+type rangePeer struct {
+	fakePeer
+	value string
+}
+
+func (p *rangePeer) Get(_ context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	p.hits++
+	out.Value = []byte(ByteView{s: p.value}.sliceRange(in.GetOffset(), in.GetLength()).String())
+	return nil
+}
+
+func TestGetRange(t *testing.T) {
+	peer := &rangePeer{value: "0123456789"}
+	g := newGroup("TestGetRange-group", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return dest.SetString("abcdefghij")
+	}), fakePeers{peer})
+
+	tests := []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, 0, "0123456789"},
+		{2, 3, "234"},
+		{8, 10, "89"},
+		{20, 0, ""},
+	}
+	for _, tt := range tests {
+		var got string
+		if err := g.GetRange(dummyCtx, "remote", tt.offset, tt.length, StringSink(&got)); err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("GetRange(%d, %d) = %q; want %q", tt.offset, tt.length, got, tt.want)
+		}
+	}
+	if g.hotCache.items() != 0 {
+		t.Errorf("expected ranged peer fetches not to populate the hot cache")
+	}
+
+	local := newGroup("TestGetRange-local", cacheSize, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return dest.SetString("abcdefghij")
+	}), NoPeers{})
+	var got string
+	if err := local.GetRange(dummyCtx, "local", 3, 2, StringSink(&got)); err != nil {
+		t.Fatal(err)
+	}
+	if got != "de" {
+		t.Errorf("local GetRange = %q; want %q", got, "de")
+	}
+	if local.mainCache.items() != 1 {
+		t.Errorf("expected the whole value to be cached locally")
+	}
+}
+
+func TestContains(t *testing.T) {
+	once.Do(testSetup)
+	g := stringGroup.(*Group)
+	const key = "TestContains-key"
+
+	fills := countFills(func() {
+		if g.Contains(key) {
+			t.Error("expected Contains to be false before Get")
+		}
+	})
+	if fills != 0 {
+		t.Errorf("expected Contains not to load; got %d fills", fills)
+	}
+
+	var s string
+	if err := g.Get(dummyCtx, key, StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	gets := g.mainCache.stats().Gets
+	if !g.Contains(key) {
+		t.Error("expected Contains to be true after Get")
+	}
+	if got := g.mainCache.stats().Gets; got != gets {
+		t.Errorf("expected Contains not to count as a cache get; got %d gets, want %d", got, gets)
+	}
+}
+
+func TestAdmissionFilter(t *testing.T) {
+	var hotFills int
+	getter := GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		if strings.HasPrefix(key, "hot-") {
+			hotFills++
+		}
+		return dest.SetString("ECHO:" + key)
+	})
+	g := newGroupOpts("TestAdmissionFilter-group", 200, getter, NoPeers{}, &GroupOptions{AdmissionFilter: true})
+
+	get := func(key string) {
+		var s string
+		if err := g.Get(dummyCtx, key, StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hot := []string{"hot-0", "hot-1", "hot-2", "hot-3", "hot-4"}
+	for round := 0; round < 100; round++ {
+		for _, key := range hot {
+			get(key)
+		}
+		for i := 0; i < 20; i++ {
+			get(fmt.Sprintf("scan-%d-%d", round, i))
+		}
+	}
+
+	for _, key := range hot {
+		if !g.Contains(key) {
+			t.Errorf("expected hot key %q to survive the scan", key)
+		}
+	}
+	if hotFills != len(hot) {
+		t.Errorf("expected each hot key to be loaded once; got %d loads", hotFills)
+	}
+	if g.mainCache.bytes() > g.cacheBytes {
+		t.Errorf("cache holds %d bytes; want at most %d", g.mainCache.bytes(), g.cacheBytes)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var calls int
+	fail := true
+	getter := GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		calls++
+		if fail {
+			return errors.New("backing store is down")
+		}
+		return dest.SetString("ECHO:" + key)
+	})
+	g := newGroupOpts("TestCircuitBreaker-group", cacheSize, getter, NoPeers{}, &GroupOptions{
+		BreakerThreshold: 3,
+		BreakerCooldown:  100 * time.Millisecond,
+	})
+	get := func(key string) error {
+		var s string
+		return g.Get(dummyCtx, key, StringSink(&s))
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := get(fmt.Sprintf("key-%d", i)); err == nil || errors.Is(err, &ErrCircuitOpen{}) {
+			t.Fatalf("expected the getter error; got %v", err)
+		}
+	}
+	if got := g.Stats.BreakerOpens.Get(); got != 1 {
+		t.Fatalf("expected the breaker to open once; got %d", got)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := get("open"); !errors.Is(err, &ErrCircuitOpen{}) {
+			t.Fatalf("expected ErrCircuitOpen; got %v", err)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("expected the getter not to be called while open; got %d calls", calls)
+	}
+
+	// A failed probe opens the breaker again.
+	time.Sleep(150 * time.Millisecond)
+	if err := get("probe"); err == nil || errors.Is(err, &ErrCircuitOpen{}) {
+		t.Fatalf("expected the probe to call the getter; got %v", err)
+	}
+	if err := get("open"); !errors.Is(err, &ErrCircuitOpen{}) {
+		t.Fatalf("expected ErrCircuitOpen after a failed probe; got %v", err)
+	}
+
+	// A successful probe closes it.
+	fail = false
+	time.Sleep(150 * time.Millisecond)
+	if err := get("probe"); err != nil {
+		t.Fatal(err)
+	}
+	if err := get("closed"); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 6 {
+		t.Errorf("expected 6 getter calls; got %d", calls)
+	}
+	if got := g.Stats.BreakerHalfOpens.Get(); got != 2 {
+		t.Errorf("expected 2 half-open transitions; got %d", got)
+	}
+	if got := g.Stats.BreakerCloses.Get(); got != 1 {
+		t.Errorf("expected 1 close transition; got %d", got)
+	}
+}
+
+func TestCircuitBreakerCanceledProbe(t *testing.T) {
+	var calls int
+	var result error
+	getter := GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		calls++
+		return result
+	})
+	g := newGroupOpts("TestCircuitBreakerCanceledProbe-group", cacheSize, getter, NoPeers{}, &GroupOptions{
+		BreakerThreshold: 1,
+		BreakerCooldown:  20 * time.Millisecond,
+	})
+	defer DeregisterGroup("TestCircuitBreakerCanceledProbe-group")
+	get := func(key string) error {
+		var s string
+		return g.Get(dummyCtx, key, StringSink(&s))
+	}
+
+	result = errors.New("backing store is down")
+	if err := get("fail"); err == nil {
+		t.Fatal("expected the getter error")
+	}
+	// A probe whose caller went away leaves the breaker half-open.
+	time.Sleep(30 * time.Millisecond)
+	result = context.Canceled
+	if err := get("canceled"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the probe to be canceled; got %v", err)
+	}
+	if got := g.Stats.BreakerCloses.Get(); got != 0 {
+		t.Errorf("a canceled probe closed the breaker %d times", got)
+	}
+	// So the next call probes the Getter, and its failure opens it again.
+	result = errors.New("still down")
+	if err := get("probe"); err == nil || errors.Is(err, &ErrCircuitOpen{}) {
+		t.Fatalf("expected the next call to probe the getter; got %v", err)
+	}
+	if err := get("open"); !errors.Is(err, &ErrCircuitOpen{}) {
+		t.Fatalf("expected ErrCircuitOpen after the failed probe; got %v", err)
+	}
+	if calls != 3 || g.Stats.BreakerOpens.Get() != 2 {
+		t.Errorf("%d getter calls and %d opens; want 3 and 2", calls, g.Stats.BreakerOpens.Get())
+	}
+}
+
+type listingPeer struct {
+	fakePeer
+	keys []string
+}
+
+func (p *listingPeer) Keys(_ context.Context, in *pb.KeysRequest, out *pb.KeysResponse) error {
+	out.Keys = p.keys
+	return nil
+}
+
+func TestWarmFromPeers(t *testing.T) {
+	var keys []string
+	for i := 0; i < 20; i++ {
+		keys = append(keys, fmt.Sprintf("key-%d", i))
+	}
+	peer := &listingPeer{keys: keys}
+	peers := fakePeers{peer, nil}
+	g := newGroup("warmFromPeersTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return errors.New("getter called while warming")
+	}), peers)
+
+	var owned []string
+	for _, key := range keys {
+		if _, ok := peers.PickPeer(key); !ok && key != "key-0" {
+			owned = append(owned, key)
+		}
+	}
+	if len(owned) == 0 {
+		t.Fatal("test keys are all owned by the peer")
+	}
+
+	n, err := g.WarmFromPeers(context.Background(), 0, func(key string) bool {
+		return key != "key-0"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(owned) {
+		t.Errorf("WarmFromPeers loaded %d keys; want %d", n, len(owned))
+	}
+	for _, key := range owned {
+		if !g.mainCache.contains(key) {
+			t.Errorf("expected %q in the main cache", key)
+		}
+	}
+	if g.Contains("key-0") {
+		t.Error("expected key-0 to be filtered out")
+	}
+
+	// Warming again skips everything already cached.
+	n, err = g.WarmFromPeers(context.Background(), 0, func(key string) bool {
+		return key != "key-0"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("second WarmFromPeers loaded %d already cached keys", n)
+	}
+}
+
+func TestOnEvict(t *testing.T) {
+	type eviction struct {
+		cacheType, key string
+		bytes          int
+	}
+	var evictions []eviction
+	g := newGroupOpts("onEvictTest", 100, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("0123456789")
+	}), NoPeers{}, &GroupOptions{
+		OnEvict: func(cacheType, key string, bytes int) {
+			evictions = append(evictions, eviction{cacheType, key, bytes})
+		},
+	})
+
+	// 8 entries of 12 bytes fit in the main cache.
+	for i := 0; i < 8; i++ {
+		var s string
+		if err := g.Get(context.Background(), fmt.Sprintf("m%d", i), StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(evictions) != 0 {
+		t.Fatalf("unexpected evictions %v", evictions)
+	}
+
+	// The first hot entry pushes a main entry out, the second pushes
+	// out the first since the hot cache is now over its share.
+	g.localSet("h0", []byte("0123456789"), &g.hotCache)
+	g.localSet("h1", []byte("0123456789"), &g.hotCache)
+
+	want := []eviction{
+		{"main", "m0", 12},
+		{"hot", "h0", 12},
+	}
+	if !reflect.DeepEqual(evictions, want) {
+		t.Errorf("evictions = %v; want %v", evictions, want)
+	}
+	if got := g.CacheStats(MainCache).Evictions + g.CacheStats(HotCache).Evictions; got != int64(len(evictions)) {
+		t.Errorf("stats count %d evictions; the hook saw %d", got, len(evictions))
+	}
+}
+
+type prefixPeer struct {
+	fakePeer
+	prefixes []string
+}
+
+func (p *prefixPeer) RemovePrefix(_ context.Context, in *pb.GetRequest) error {
+	p.prefixes = append(p.prefixes, in.GetKey())
+	return nil
+}
+
+func TestRemoveByPrefix(t *testing.T) {
+	peer := &prefixPeer{}
+	g := newGroup("removeByPrefixTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value")
+	}), fakePeers{peer})
+
+	keys := []string{"tenant:1:a", "tenant:1:b", "tenant:2:a", "tenant:2:b"}
+	for _, key := range keys {
+		g.localSet(key, []byte("value"), &g.mainCache)
+	}
+	g.localSet("tenant:1:hot", []byte("value"), &g.hotCache)
+
+	if err := g.RemoveByPrefix(context.Background(), "tenant:1:"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range append(keys, "tenant:1:hot") {
+		want := strings.HasPrefix(key, "tenant:2:")
+		if got := g.Contains(key); got != want {
+			t.Errorf("Contains(%q) = %v; want %v", key, got, want)
+		}
+	}
+	if want := []string{"tenant:1:"}; !reflect.DeepEqual(peer.prefixes, want) {
+		t.Errorf("peer received prefix removals %v; want %v", peer.prefixes, want)
+	}
+
+	// Peers which can't remove by prefix are reported.
+	g2 := newGroup("removeByPrefixUnsupportedTest", 1<<20, nil, fakePeers{&fakePeer{}})
+	if err := g2.RemoveByPrefix(context.Background(), "tenant:1:"); err == nil {
+		t.Error("expected an error from a peer without RemovePrefix")
+	}
+}
+
+type etagPeer struct {
+	fakePeer
+	value []byte
+}
+
+func (p *etagPeer) Get(_ context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	p.hits++
+	etag := ByteView{b: p.value}.ETag()
+	out.Etag = &etag
+	if in.GetEtag() == etag {
+		out.NotModified = proto.Bool(true)
+		return nil
+	}
+	out.Value = p.value
+	return nil
+}
+
+func TestGetIfModified(t *testing.T) {
+	peer := &etagPeer{value: []byte("remote")}
+	g := newGroup("getIfModifiedTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("local")
+	}), fakePeers{peer, nil})
+
+	var localKey, remoteKey string
+	for i := 0; localKey == "" || remoteKey == ""; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if _, ok := g.peers.PickPeer(key); ok {
+			remoteKey = key
+		} else {
+			localKey = key
+		}
+	}
+
+	for _, tt := range []struct {
+		key, value string
+	}{
+		{localKey, "local"},
+		{remoteKey, "remote"},
+	} {
+		var s string
+		etag, modified, err := g.GetIfModified(context.Background(), tt.key, "", StringSink(&s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !modified || s != tt.value {
+			t.Fatalf("GetIfModified(%q) = %q, %v; want %q, true", tt.key, s, modified, tt.value)
+		}
+
+		s = ""
+		etag2, modified, err := g.GetIfModified(context.Background(), tt.key, etag, StringSink(&s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if modified || s != "" || etag2 != etag {
+			t.Errorf("GetIfModified(%q) with a matching ETag = %q, %v; want unmodified", tt.key, s, modified)
+		}
+	}
+}
+
+func TestDisableSingleflight(t *testing.T) {
+	const n = 4
+	var calls int32
+	all := make(chan struct{})
+	g := newGroupOpts("disableSingleflightTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		// Wait until every Get is loading, so the loads overlap.
+		if atomic.AddInt32(&calls, 1) == n {
+			close(all)
+		}
+		select {
+		case <-all:
+		case <-time.After(5 * time.Second):
+			return errors.New("timeout waiting for concurrent loads")
+		}
+		return dest.SetString("value")
+	}), NoPeers{}, &GroupOptions{DisableSingleflight: true})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var s string
+			errs <- g.Get(context.Background(), "key", StringSink(&s))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != n {
+		t.Errorf("Getter called %d times; want %d", got, n)
+	}
+}
+
+func TestDisableSingleflightRemove(t *testing.T) {
+	loading, release := make(chan struct{}), make(chan struct{})
+	g := newGroupOpts("disableSingleflightRemoveTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		close(loading)
+		<-release
+		return dest.SetString("old")
+	}), NoPeers{}, &GroupOptions{DisableSingleflight: true})
+	defer DeregisterGroup(g.Name())
+
+	got := make(chan error)
+	go func() {
+		_, err := g.GetString(context.Background(), "key")
+		got <- err
+	}()
+	<-loading
+	removed := make(chan error)
+	go func() { removed <- g.Remove(context.Background(), "key") }()
+	select {
+	case <-removed:
+		t.Fatal("Remove returned while a load of the key was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-got; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-removed; err != nil {
+		t.Fatal(err)
+	}
+	if g.Contains("key") {
+		t.Error("the load running during Remove left its value cached")
+	}
+}
+
+func BenchmarkGetSingleflight(b *testing.B) {
+	benchmarkGetParallel(b, false)
+}
+
+func BenchmarkGetNoSingleflight(b *testing.B) {
+	benchmarkGetParallel(b, true)
+}
+
+func benchmarkGetParallel(b *testing.B, disableSingleflight bool) {
+	const name = "benchmarkGetParallel-group"
+	// A cache size of zero makes every Get a load.
+	g := newGroupOpts(name, 0, GetterFunc(func(_ context.Context, key string, dest Sink) error {
+		return dest.SetString(key)
+	}), NoPeers{}, &GroupOptions{DisableSingleflight: disableSingleflight})
+	defer DeregisterGroup(name)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var s string
+		sink := StringSink(&s)
+		for pb.Next() {
+			if err := g.Get(dummyCtx, "key", sink); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestDropNonOwned(t *testing.T) {
+	p := newHTTPPoolOpts("http://self", nil)
+	p.Set("http://self")
+	g := newGroup("dropNonOwnedTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value")
+	}), p)
+
+	var keys []string
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		keys = append(keys, key)
+		var s string
+		if err := g.Get(context.Background(), key, StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := g.DropNonOwned(); n != 0 {
+		t.Fatalf("DropNonOwned removed %d keys while every key is owned", n)
+	}
+
+	// A peer joins and takes over some of the keys.
+	p.Set("http://self", "http://other")
+	var moved int
+	for _, key := range keys {
+		if _, ok := p.PickPeer(key); ok {
+			moved++
+		}
+	}
+	if moved == 0 || moved == len(keys) {
+		t.Fatalf("expected the new peer to own some of the keys; it owns %d", moved)
+	}
+
+	if n := g.DropNonOwned(); n != moved {
+		t.Errorf("DropNonOwned removed %d keys; want %d", n, moved)
+	}
+	for _, key := range keys {
+		_, remote := p.PickPeer(key)
+		if got := g.Contains(key); got == remote {
+			t.Errorf("Contains(%q) = %v after DropNonOwned; owned remotely: %v", key, got, remote)
+		}
+	}
+}
+
+func TestDropNonOwnedFingerprinted(t *testing.T) {
+	p := newHTTPPoolOpts("http://self", nil)
+	p.Set("http://self")
+	g := newGroupOpts("dropNonOwnedFingerprintTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value")
+	}), p, &GroupOptions{KeyFingerprint: FingerprintKey})
+	defer DeregisterGroup(g.Name())
+
+	var keys []string
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		keys = append(keys, key)
+		if _, err := g.GetString(context.Background(), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Set("http://self", "http://other")
+	if n := g.DropNonOwned(); n != 0 {
+		t.Errorf("DropNonOwned removed %d fingerprinted entries; want none", n)
+	}
+	for _, key := range keys {
+		if !g.Contains(key) {
+			t.Errorf("DropNonOwned removed %q from a fingerprinted group", key)
+		}
+	}
+}
+
+// fakeClock is a Clock which only moves when advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestTTL(t *testing.T) {
+	clock := newFakeClock()
+	var loads int
+	g := newGroupOpts("ttlTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		loads++
+		return dest.SetString(fmt.Sprintf("value-%d", loads))
+	}), NoPeers{}, &GroupOptions{TTL: time.Minute, Clock: clock.Now})
+
+	get := func() string {
+		var s string
+		if err := g.Get(context.Background(), "key", StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	if got := get(); got != "value-1" {
+		t.Fatalf("got %q; want value-1", got)
+	}
+	var view ByteView
+	if err := g.Get(context.Background(), "key", ByteViewSink(&view)); err != nil {
+		t.Fatal(err)
+	}
+	if want := clock.Now().Add(time.Minute); !view.Expire().Equal(want) {
+		t.Errorf("Expire() = %v; want %v", view.Expire(), want)
+	}
+
+	clock.Advance(59 * time.Second)
+	if got := get(); got != "value-1" || loads != 1 {
+		t.Errorf("got %q after %d loads before the TTL; want value-1 after 1 load", got, loads)
+	}
+
+	clock.Advance(time.Second)
+	if g.Contains("key") {
+		t.Error("expected an expired key not to be reported as cached")
+	}
+	if keys := g.mainCache.keys(0); len(keys) != 0 {
+		t.Errorf("expected an expired key not to be listed; got %q", keys)
+	}
+	if got := get(); got != "value-2" || loads != 2 {
+		t.Errorf("got %q after %d loads past the TTL; want value-2 after 2 loads", got, loads)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	for _, sliding := range []bool{false, true} {
+		clock := newFakeClock()
+		var loads int
+		g := newGroupOpts(fmt.Sprintf("touchTest-%v", sliding), 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+			loads++
+			return dest.SetString("value")
+		}), NoPeers{}, &GroupOptions{TTL: time.Minute, SlidingTTL: sliding, Clock: clock.Now})
+
+		if g.Touch("key", 0) {
+			t.Error("Touch of an uncached key = true; want false")
+		}
+		var s string
+		if err := g.Get(context.Background(), "key", StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+
+		clock.Advance(50 * time.Second)
+		if sliding {
+			// The hit slides the expiry instead of Touch.
+			if err := g.Get(context.Background(), "key", StringSink(&s)); err != nil {
+				t.Fatal(err)
+			}
+		} else if !g.Touch("key", 0) {
+			t.Fatal("Touch of a cached key = false; want true")
+		}
+
+		// Past the original expiry, but not past the refreshed one.
+		clock.Advance(30 * time.Second)
+		if !g.Contains("key") {
+			t.Errorf("sliding=%v: expected the refreshed key to still be cached", sliding)
+		}
+		if err := g.Get(context.Background(), "key", StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+		if loads != 1 {
+			t.Errorf("sliding=%v: loaded %d times; want 1", sliding, loads)
+		}
+	}
+
+	clock := newFakeClock()
+	g := newGroupOpts("touchTestExpired", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value")
+	}), NoPeers{}, &GroupOptions{TTL: time.Minute, Clock: clock.Now})
+	var s string
+	if err := g.Get(context.Background(), "key", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if !g.Touch("key", time.Hour) {
+		t.Fatal("Touch of a cached key = false; want true")
+	}
+	clock.Advance(59 * time.Minute)
+	if !g.Contains("key") {
+		t.Error("expected Touch's ttl to override the group's TTL")
+	}
+	clock.Advance(time.Minute)
+	if g.Touch("key", 0) {
+		t.Error("Touch of an expired key = true; want false")
+	}
+}
+
+type removeRecordingPeer struct {
+	fakePeer
+	removed chan string
+}
+
+func (p *removeRecordingPeer) Remove(_ context.Context, in *pb.GetRequest) error {
+	p.removed <- in.GetKey()
+	return nil
+}
+
+func TestRemoveAsync(t *testing.T) {
+	block := make(chan string)
+	peers := fakePeers{
+		&removeRecordingPeer{removed: make(chan string, 1)},
+		&removeRecordingPeer{removed: block},
+	}
+	g := newGroup("removeAsyncTest", 1<<20, nil, peers)
+	g.localSet("key", []byte("value"), &g.mainCache)
+
+	// Returns while the second peer has not accepted the removal yet.
+	g.RemoveAsync("key")
+	if g.Contains("key") {
+		t.Error("expected the key to be removed locally right away")
+	}
+
+	for i, peer := range peers {
+		select {
+		case key := <-peer.(*removeRecordingPeer).removed:
+			if key != "key" {
+				t.Errorf("peer %d removed %q; want key", i, key)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for peer %d to be asked to remove the key", i)
+		}
+	}
+}
+
+func TestSetWithVersion(t *testing.T) {
+	ctx := context.Background()
+	getString := func(g *Group, key string) string {
+		var s string
+		if err := g.Get(ctx, key, StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	// The owner keeps the highest version.
+	owner := newGroup("setWithVersionOwnerTest", 1<<20, nil, NoPeers{})
+	if err := owner.SetWithVersion(ctx, "key", []byte("v2"), 2, false); err != nil {
+		t.Fatal(err)
+	}
+	err := owner.SetWithVersion(ctx, "key", []byte("v1"), 1, false)
+	if !errors.Is(err, &ErrStaleVersion{}) {
+		t.Errorf("expected ErrStaleVersion for an older version; got %v", err)
+	}
+	if got := getString(owner, "key"); got != "v2" {
+		t.Errorf("owner has %q; want v2", got)
+	}
+	if err := owner.SetWithVersion(ctx, "key", []byte("v3"), 3, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := getString(owner, "key"); got != "v3" {
+		t.Errorf("owner has %q; want v3", got)
+	}
+	if got, want := owner.CacheStats(MainCache).Bytes, int64(len("key")+len("v3")); got != want {
+		t.Errorf("main cache accounts for %d bytes; want %d", got, want)
+	}
+
+	// Plain Sets are ordered too.
+	if err := owner.Set(ctx, "key", []byte("latest"), false); err != nil {
+		t.Fatal(err)
+	}
+	if got := getString(owner, "key"); got != "latest" {
+		t.Errorf("owner has %q; want latest", got)
+	}
+
+	// Unversioned writes, such as from older clients, always replace.
+	if err := owner.SetWithVersion(ctx, "key", []byte("unversioned"), 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := getString(owner, "key"); got != "unversioned" {
+		t.Errorf("owner has %q; want unversioned", got)
+	}
+	owner.localSet("key", []byte("local"), &owner.mainCache)
+	if got := getString(owner, "key"); got != "local" {
+		t.Errorf("owner has %q after localSet; want local", got)
+	}
+
+	// Hot copies keep the highest version as well.
+	client := newGroup("setWithVersionClientTest", 1<<20, nil, fakePeers{&fakePeer{}})
+	for _, v := range []int64{5, 4} {
+		if err := client.SetWithVersion(ctx, "key", []byte(fmt.Sprint("v", v)), v, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if view, ok := client.hotCache.get("key"); !ok || view.String() != "v5" || view.Version() != 5 {
+		t.Errorf("hot cache has %q at version %d; want v5 at version 5", view.String(), view.Version())
+	}
+}
+
+// BenchmarkProtoRoundTrip loads with a single marshal and reads cached
+// values with a single unmarshal.
+func BenchmarkProtoRoundTrip(b *testing.B) {
+	const name = "benchmarkProtoRoundTrip-group"
+	g := newGroup(name, 1<<20, MessageGetterFunc(func(_ context.Context, key string) (proto.Message, error) {
+		return &testpb.TestMessage{
+			Name: proto.String("name:" + key),
+			City: proto.String(strings.Repeat("x", 512)),
+		}, nil
+	}), NoPeers{})
+	defer DeregisterGroup(name)
+
+	var m testpb.TestMessage
+	sink := ProtoSink(&m)
+	b.Run("load", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			g.localRemove("key")
+			if err := g.Get(dummyCtx, "key", sink); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("hit", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := g.Get(dummyCtx, "key", sink); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestProtoSinkCopiesSameType(t *testing.T) {
+	g := newGroup("protoSinkCopiesSameTypeTest", 1<<20, MessageGetterFunc(func(_ context.Context, key string) (proto.Message, error) {
+		return &testpb.TestMessage{Name: proto.String("name:" + key)}, nil
+	}), NoPeers{})
+
+	for i := 0; i < 2; i++ {
+		m := testpb.TestMessage{City: proto.String("stale")}
+		if err := g.Get(dummyCtx, "key", ProtoSink(&m)); err != nil {
+			t.Fatal(err)
+		}
+		if m.GetName() != "name:key" || m.City != nil {
+			t.Errorf("Get #%d filled %v", i, proto.CompactTextString(&m))
+		}
+	}
+
+	// A different message type is still decoded.
+	var other testpb.TestRequest
+	if err := g.Get(dummyCtx, "other", ProtoSink(&other)); err != nil {
+		t.Fatal(err)
+	}
+	if other.GetLower() != "name:other" {
+		t.Errorf("decoded %v", proto.CompactTextString(&other))
+	}
+}
+
+type concurrencyPeer struct {
+	fakePeer
+	running, max int32
+	release      chan struct{}
+}
+
+func (p *concurrencyPeer) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	n := atomic.AddInt32(&p.running, 1)
+	defer atomic.AddInt32(&p.running, -1)
+	for {
+		max := atomic.LoadInt32(&p.max)
+		if n <= max || atomic.CompareAndSwapInt32(&p.max, max, n) {
+			break
+		}
+	}
+	select {
+	case <-p.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	out.Value = []byte("got:" + in.GetKey())
+	return nil
+}
+
+func TestMaxPeerFetches(t *testing.T) {
+	const limit = 2
+	peer := &concurrencyPeer{release: make(chan struct{})}
+	g := newGroupOpts("maxPeerFetchesTest", 1<<20, nil, fakePeers{peer}, &GroupOptions{MaxPeerFetches: limit})
+
+	// A request waiting for a free slot gives up with its context.
+	var wg sync.WaitGroup
+	for i := 0; i < limit; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var s string
+			if err := g.Get(context.Background(), fmt.Sprintf("block-%d", i), StringSink(&s)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	for atomic.LoadInt32(&peer.running) != limit {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	var s string
+	if err := g.Get(ctx, "waiting", StringSink(&s)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the waiting Get to time out; got %v", err)
+	}
+	close(peer.release)
+	wg.Wait()
+
+	// Many concurrent Gets never exceed the limit.
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var s string
+			if err := g.Get(context.Background(), fmt.Sprintf("key-%d", i), StringSink(&s)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if max := atomic.LoadInt32(&peer.max); max > limit {
+		t.Errorf("%d peer fetches ran at once; want at most %d", max, limit)
+	}
+}
+
+func TestMemoryCoordinator(t *testing.T) {
+	getter := GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString(strings.Repeat("x", 94))
+	})
+	g1 := newGroup("memoryCoordinatorTest1", 4000, getter, NoPeers{})
+	defer DeregisterGroup("memoryCoordinatorTest1")
+	g2 := newGroup("memoryCoordinatorTest2", 6000, getter, NoPeers{})
+	defer DeregisterGroup("memoryCoordinatorTest2")
+	fill := func(g *Group) {
+		// Entries of 100 bytes each.
+		for i := 0; i < 100; i++ {
+			var s string
+			if err := g.Get(context.Background(), fmt.Sprintf("key-%02d", i), StringSink(&s)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	fill(g1)
+	fill(g2)
+	held := func(g *Group) int64 {
+		return g.CacheStats(MainCache).Bytes + g.CacheStats(HotCache).Bytes
+	}
+
+	// The groups ask for 10000 bytes, so each gets half of what it asks for.
+	m := NewMemoryCoordinator(5000)
+	m.Add(g1)
+	m.Add(g2)
+	if g1.CacheBytes() != 2000 || g2.CacheBytes() != 3000 {
+		t.Errorf("limits are %d and %d under a 5000 byte cap; want 2000 and 3000", g1.CacheBytes(), g2.CacheBytes())
+	}
+	if held(g1) > 2000 || held(g2) > 3000 {
+		t.Errorf("groups hold %d and %d bytes; want at most 2000 and 3000", held(g1), held(g2))
+	}
+	fill(g1)
+	fill(g2)
+	if total := held(g1) + held(g2); total > 5000 {
+		t.Errorf("groups hold %d bytes after loading more; want at most 5000", total)
+	}
+
+	// Asking for more takes a larger share of the same cap.
+	g1.SetCacheBytes(14000)
+	if g1.CacheBytes() != 3500 || g2.CacheBytes() != 1500 {
+		t.Errorf("limits are %d and %d after growing g1; want 3500 and 1500", g1.CacheBytes(), g2.CacheBytes())
+	}
+	if held(g2) > 1500 {
+		t.Errorf("g2 holds %d bytes; want at most 1500", held(g2))
+	}
+
+	// Under the cap, each group gets what it asks for.
+	m.SetLimit(1 << 20)
+	if g1.CacheBytes() != 14000 || g2.CacheBytes() != 6000 {
+		t.Errorf("limits are %d and %d under a large cap; want 14000 and 6000", g1.CacheBytes(), g2.CacheBytes())
+	}
+	m.SetLimit(1000)
+	m.Remove(g2)
+	if g1.CacheBytes() != 1000 || g2.CacheBytes() != 6000 {
+		t.Errorf("limits are %d and %d after removing g2; want 1000 and 6000", g1.CacheBytes(), g2.CacheBytes())
+	}
+}
+
+func TestSetCacheBytes(t *testing.T) {
+	g := newGroup("setCacheBytesTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString(strings.Repeat("x", 94))
+	}), NoPeers{})
+
+	// 100 entries of 100 bytes each.
+	for i := 0; i < 100; i++ {
+		var s string
+		if err := g.Get(context.Background(), fmt.Sprintf("key-%02d", i), StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g.localSet("hot-00", []byte(strings.Repeat("x", 94)), &g.hotCache)
+	if got := g.CacheStats(MainCache).Bytes + g.CacheStats(HotCache).Bytes; got != 10100 {
+		t.Fatalf("caches hold %d bytes; want 10100", got)
+	}
+
+	g.SetCacheBytes(2500)
+	main, hot := g.CacheStats(MainCache), g.CacheStats(HotCache)
+	if main.Bytes+hot.Bytes > 2500 {
+		t.Errorf("caches hold %d bytes after shrinking to 2500", main.Bytes+hot.Bytes)
+	}
+	// The hot entry keeps its share, so 24 main entries fit beside it.
+	if main.Items != 24 || main.Evictions != 76 || hot.Items != 1 {
+		t.Errorf("main cache has %d items after %d evictions and hot cache %d; want 24 after 76 and 1",
+			main.Items, main.Evictions, hot.Items)
+	}
+
+	// Growing keeps every entry and lets more in.
+	g.SetCacheBytes(1 << 20)
+	if got := g.CacheStats(MainCache).Items; got != 24 {
+		t.Errorf("main cache has %d items after growing; want 24", got)
+	}
+	var s string
+	if err := g.Get(context.Background(), "key-00", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.CacheStats(MainCache).Items; got != 25 {
+		t.Errorf("main cache has %d items; want 25", got)
+	}
+
+	g.SetCacheBytes(0)
+	if main, hot := g.CacheStats(MainCache), g.CacheStats(HotCache); main.Items+hot.Items != 0 {
+		t.Errorf("caches hold %d items after disabling caching", main.Items+hot.Items)
+	}
+}
+
+func TestMaxStale(t *testing.T) {
+	clock := newFakeClock()
+	var fail bool
+	g := newGroupOpts("maxStaleTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		if fail {
+			return errors.New("backend unavailable")
+		}
+		return dest.SetString("fresh")
+	}), NoPeers{}, &GroupOptions{TTL: time.Minute, MaxStale: 10 * time.Minute, Clock: clock.Now})
+
+	get := func() (string, error) {
+		var s string
+		err := g.Get(context.Background(), "key", StringSink(&s))
+		return s, err
+	}
+	if _, err := get(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Past its TTL, a failed reload serves the expired value.
+	fail = true
+	clock.Advance(5 * time.Minute)
+	if s, err := get(); err != nil || s != "fresh" {
+		t.Errorf("got %q, %v; want the stale value", s, err)
+	}
+	if got := g.Stats.StaleHits.Get(); got != 1 {
+		t.Errorf("StaleHits = %d; want 1", got)
+	}
+
+	// Beyond MaxStale the error is returned.
+	clock.Advance(10 * time.Minute)
+	if _, err := get(); err == nil {
+		t.Error("expected the load error once the value is too stale")
+	}
+
+	// A successful reload replaces the stale value.
+	fail = false
+	if s, err := get(); err != nil || s != "fresh" {
+		t.Errorf("got %q, %v; want a reloaded value", s, err)
+	}
+}
+
+func TestLoadAll(t *testing.T) {
+	clock := newFakeClock()
+	g := newGroupOpts("loadAllTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return errors.New("getter called for a preloaded key")
+	}), NoPeers{}, &GroupOptions{TTL: time.Hour, Clock: clock.Now})
+
+	const n = 1000
+	var i int
+	buf := make([]byte, 0, 16)
+	err := g.LoadAll(context.Background(), func() (string, []byte, time.Duration, bool) {
+		if i == n {
+			return "", nil, 0, false
+		}
+		defer func() { i++ }()
+		// The iterator reuses its buffer, as streaming sources do.
+		buf = append(buf[:0], fmt.Sprintf("value-%d", i)...)
+		var ttl time.Duration
+		if i == 0 {
+			ttl = time.Minute
+		}
+		return fmt.Sprintf("key-%d", i), buf, ttl, true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < n; i++ {
+		var s string
+		if err := g.Get(context.Background(), fmt.Sprintf("key-%d", i), StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("value-%d", i); s != want {
+			t.Fatalf("got %q; want %q", s, want)
+		}
+	}
+	if hits := g.Stats.CacheHits.Get(); hits != n {
+		t.Errorf("got %d cache hits; want %d", hits, n)
+	}
+
+	// key-0 was loaded with its own TTL, the rest with the group's.
+	clock.Advance(2 * time.Minute)
+	if g.Contains("key-0") || !g.Contains("key-1") {
+		t.Errorf("after 2 minutes Contains(key-0) = %v, Contains(key-1) = %v; want false, true",
+			g.Contains("key-0"), g.Contains("key-1"))
+	}
+}
+
+func TestLoadAllStops(t *testing.T) {
+	g := newGroup("loadAllStopsTest", 100, nil, NoPeers{})
+	next := func() (string, []byte, time.Duration, bool) {
+		return "key", []byte(strings.Repeat("x", 60)), 0, true
+	}
+	if err := g.LoadAll(context.Background(), next); err == nil {
+		t.Error("expected an error once the cache is full")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := g.LoadAll(ctx, next); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled; got %v", err)
+	}
+}
+
+func TestGetWithInfo(t *testing.T) {
+	release := make(chan struct{})
+	g := newGroup("getWithInfoTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		<-release
+		return dest.SetString("value")
+	}), NoPeers{})
+
+	const n = 10
+	infos := make(chan GetInfo, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var s string
+			info, err := g.GetWithInfo(context.Background(), "key", StringSink(&s))
+			if err != nil || s != "value" {
+				t.Errorf("GetWithInfo = %q, %v", s, err)
+			}
+			infos <- info
+		}()
+	}
+	time.Sleep(100 * time.Millisecond) // let the Gets join the flight
+	close(release)
+	wg.Wait()
+	close(infos)
+
+	var leaders, followers int
+	for info := range infos {
+		if info.CacheHit {
+			t.Errorf("unexpected cache hit")
+		}
+		if info.Follower {
+			followers++
+		} else {
+			leaders++
+		}
+	}
+	if leaders != 1 || followers != n-1 {
+		t.Errorf("got %d leaders and %d followers; want 1 and %d", leaders, followers, n-1)
+	}
+	if got := g.Stats.CoalescedLoads.Get(); got != n-1 {
+		t.Errorf("CoalescedLoads = %d; want %d", got, n-1)
+	}
+
+	var s string
+	if info, err := g.GetWithInfo(context.Background(), "key", StringSink(&s)); err != nil || info != (GetInfo{CacheHit: true, Source: MainCacheAccess}) {
+		t.Errorf("GetWithInfo after load = %+v, %v; want a cache hit", info, err)
+	}
+}
+
+func TestWeight(t *testing.T) {
+	getter := GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		if key == "heavy" {
+			return dest.SetString("small")
+		}
+		return dest.SetString(strings.Repeat("x", 100))
+	})
+	weighted := newGroupOpts("weightTest", 1000, getter, NoPeers{}, &GroupOptions{
+		Weight: func(key string, value ByteView) int {
+			if key == "heavy" {
+				return 50
+			}
+			return 1
+		},
+	})
+	unweighted := newGroup("weightTestUnweighted", 1000, getter, NoPeers{})
+
+	for _, g := range []*Group{weighted, unweighted} {
+		var s string
+		if err := g.Get(context.Background(), "heavy", StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 30; i++ {
+			if err := g.Get(context.Background(), fmt.Sprintf("light-%d", i), StringSink(&s)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := g.mainCache.bytes(); got > 1000 {
+			t.Errorf("%s: cache holds %d bytes; want at most 1000", g.Name(), got)
+		}
+	}
+
+	if !weighted.Contains("heavy") {
+		t.Error("expected the heavy entry to outlive the light ones")
+	}
+	if weighted.Contains("light-0") {
+		t.Error("expected the oldest light entry to be evicted")
+	}
+	if unweighted.Contains("heavy") {
+		t.Error("expected plain eviction to evict the oldest entry")
+	}
+}
+
+func TestWeightWithPins(t *testing.T) {
+	c := &cache{pins: &pinSet{}}
+	c.pins.add("pinned")
+	c.add("pinned", ByteView{s: "value"})
+	c.add("heavy", ByteView{s: "value"}.withEntry(func(e *entry) {
+		e.setExtra(func(x *entryExtra) { x.weight = 3 })
+	}))
+	// The hand passes the pinned entry more often than the cache holds
+	// entries while it spends the heavy entry's credits.
+	if key, ok := c.victimLocked(); !ok || key != "heavy" {
+		t.Errorf("victim = %q, %v; want heavy", key, ok)
+	}
+}
+
+func TestPeekVictim(t *testing.T) {
+	c := &cache{pins: &pinSet{}}
+	c.pins.add("pinned")
+	c.add("pinned", ByteView{s: "value"})
+	c.add("heavy", ByteView{s: "value"}.withEntry(func(e *entry) {
+		e.setExtra(func(x *entryExtra) { x.weight = 3 })
+	}))
+	c.add("light", ByteView{s: "value"})
+	for i := 0; i < 5; i++ {
+		if key, ok := c.peekVictim(); !ok || key != "light" {
+			t.Fatalf("peekVictim = %q, %v; want light", key, ok)
+		}
+	}
+	// Peeking spent no credits, so the heavy entry still outlasts the
+	// light one.
+	if got := c.credits["heavy"]; got != 2 {
+		t.Errorf("heavy has %d credits after peeking; want 2", got)
+	}
+	if key, ok := c.victimLocked(); !ok || key != "light" {
+		t.Errorf("victim = %q, %v; want light", key, ok)
+	}
+}
+
+func TestAgeStats(t *testing.T) {
+	clock := newFakeClock()
+	g := newGroupOpts("ageStatsTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value")
+	}), NoPeers{}, &GroupOptions{Clock: clock.Now})
+
+	if got := g.AgeStats(); got != (AgeStats{}) {
+		t.Errorf("AgeStats of an empty group = %+v", got)
+	}
+
+	// Cache one entry per second for 10 seconds, so the entries are
+	// 1s to 10s old.
+	for i := 0; i < 10; i++ {
+		if err := g.Get(context.Background(), fmt.Sprintf("key-%d", i), StringSink(new(string))); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Second)
+	}
+	want := AgeStats{Items: 10, P50: 5 * time.Second, P90: 9 * time.Second, Max: 10 * time.Second}
+	if got := g.AgeStats(); got != want {
+		t.Errorf("AgeStats = %+v; want %+v", got, want)
+	}
+
+	// Re-adding an entry makes it young again.
+	g.localSet("key-0", []byte("value"), &g.mainCache)
+	want = AgeStats{Items: 10, P50: 4 * time.Second, P90: 8 * time.Second, Max: 9 * time.Second}
+	if got := g.AgeStats(); got != want {
+		t.Errorf("AgeStats after Set = %+v; want %+v", got, want)
+	}
+}
+
+func TestSizeStats(t *testing.T) {
+	g := newGroupOpts("sizeStatsTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		n, err := strconv.Atoi(key)
+		if err != nil {
+			return err
+		}
+		return dest.SetString(strings.Repeat("x", n))
+	}), NoPeers{}, &GroupOptions{})
+
+	if got := g.SizeStats(); got != (SizeStats{}) {
+		t.Errorf("SizeStats of an empty group = %+v", got)
+	}
+
+	// Cache values of 10, 20, ..., 100 bytes.
+	for i := 1; i <= 10; i++ {
+		if err := g.Get(context.Background(), strconv.Itoa(i*10), StringSink(new(string))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := SizeStats{Items: 10, Min: 10, Mean: 55, P50: 50, P90: 90, P99: 100, Max: 100}
+	if got := g.SizeStats(); got != want {
+		t.Errorf("SizeStats = %+v; want %+v", got, want)
+	}
+}
+
+// waitForGoroutines fails t unless the number of goroutines drops to at
+// most n within a second.
+func waitForGoroutines(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines are running; want at most %d", runtime.NumGoroutine(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGroupClose(t *testing.T) {
+	g := newGroupOpts("groupCloseTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value")
+	}), NoPeers{}, &GroupOptions{DeregisterOnClose: true})
+	if err := g.Get(context.Background(), "key", StringSink(new(string))); err != nil {
+		t.Fatal(err)
+	}
+
+	before := runtime.NumGoroutine()
+	done := make(chan struct{})
+	go func() {
+		g.DropNonOwnedEvery(context.Background(), time.Millisecond)
+		close(done)
+	}()
+
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("DropNonOwnedEvery kept running after Close")
+	}
+	waitForGoroutines(t, before)
+
+	if err := g.Get(context.Background(), "key", StringSink(new(string))); !errors.Is(err, &ErrGroupClosed{}) {
+		t.Errorf("Get after Close = %v; want ErrGroupClosed", err)
+	}
+	if err := g.Set(context.Background(), "key", []byte("value"), false); !errors.Is(err, &ErrGroupClosed{}) {
+		t.Errorf("Set after Close = %v; want ErrGroupClosed", err)
+	}
+	if GetGroup("groupCloseTest") != nil {
+		t.Error("expected Close to deregister the group")
+	}
+	if err := g.Close(); err != nil {
+		t.Errorf("second Close = %v", err)
+	}
+}
+
+// multiPeer is a fakePeer which also answers multi-gets, failing after
+// answering the first answer keys if answer is positive.
+type multiPeer struct {
+	fakePeer
+	calls  int
+	answer int
+}
+
+func (p *multiPeer) GetMulti(_ context.Context, in *pb.GetMultiRequest, fn func(key string, out *pb.GetResponse, err error)) error {
+	p.calls++
+	for i, key := range in.GetKeys() {
+		if p.answer > 0 && i == p.answer {
+			return errors.New("simulated broken stream")
+		}
+		fn(key, &pb.GetResponse{Value: []byte("got:" + key)}, nil)
+	}
+	return nil
+}
+
+func (p *multiPeer) GetURL() string {
+	return "multiPeer"
+}
+
+func TestGetMulti(t *testing.T) {
+	peer := &multiPeer{}
+	g := newGroup("getMultiTest", 0, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("local:" + key)
+	}), fakePeers([]ProtoGetter{peer, nil}))
+
+	var keys []string
+	want := map[string]string{}
+	var remote int
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		keys = append(keys, key)
+		if _, ok := g.pickPeer(key); ok {
+			want[key] = "got:" + key
+			remote++
+		} else {
+			want[key] = "local:" + key
+		}
+	}
+	if remote < 5 {
+		t.Fatalf("only %d of the keys are remote", remote)
+	}
+
+	check := func(res map[string]ByteView) {
+		t.Helper()
+		got := map[string]string{}
+		for key, v := range res {
+			got[key] = v.String()
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("GetMulti = %v; want %v", got, want)
+		}
+	}
+
+	res, err := g.GetMulti(context.Background(), append(keys, keys[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(res)
+	if peer.calls != 1 || peer.hits != 0 {
+		t.Errorf("peer got %d multi-gets and %d gets; want 1 and 0", peer.calls, peer.hits)
+	}
+
+	// Keys the broken stream didn't answer are fetched one by one.
+	peer.calls, peer.answer = 0, 3
+	res, err = g.GetMulti(context.Background(), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(res)
+	if peer.calls != 1 || peer.hits != remote-3 {
+		t.Errorf("peer got %d multi-gets and %d gets; want 1 and %d", peer.calls, peer.hits, remote-3)
+	}
+}
+
+func TestGetMultiPartial(t *testing.T) {
+	g := newGroup("getMultiPartialTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		if key == "missing" {
+			return &ErrNotFound{Msg: "not found"}
+		}
+		return dest.SetString("local:" + key)
+	}), prefixPeers{"good": &multiPeer{}, "bad": &remoteErrPeer{}})
+
+	keys := []string{"good-1", "bad-1", "local", "good-2", "bad-2", "missing"}
+	res, errs := g.GetMultiPartial(context.Background(), keys)
+	got := map[string]string{}
+	for key, v := range res {
+		got[key] = v.String()
+	}
+	want := map[string]string{"good-1": "got:good-1", "good-2": "got:good-2", "local": "local:local"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetMultiPartial values = %v; want %v", got, want)
+	}
+	if len(errs) != 3 {
+		t.Errorf("GetMultiPartial errors = %v; want bad-1, bad-2 and missing", errs)
+	}
+	for _, key := range []string{"bad-1", "bad-2"} {
+		if !errors.Is(errs[key], &ErrRemoteCall{}) {
+			t.Errorf("error of %s = %v; want ErrRemoteCall", key, errs[key])
+		}
+	}
+	if !errors.Is(errs["missing"], &ErrNotFound{}) {
+		t.Errorf("error of missing = %v; want ErrNotFound", errs["missing"])
+	}
+
+	// GetMulti fails as a whole.
+	if _, err := g.GetMulti(context.Background(), keys); err == nil {
+		t.Error("GetMulti with failing keys succeeded")
+	}
+
+	g.Close()
+	res, errs = g.GetMultiPartial(context.Background(), keys)
+	if len(res) != 0 || len(errs) != len(keys) || !errors.Is(errs["good-1"], &ErrGroupClosed{}) {
+		t.Errorf("GetMultiPartial on a closed group = %v, %v; want every key to fail with ErrGroupClosed", res, errs)
+	}
+}
+
+// remoteErrPeer is a peer which is reachable but fails every load.
+type remoteErrPeer struct {
+	fakePeer
+}
+
+func (p *remoteErrPeer) Get(_ context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	p.hits++
+	return &ErrRemoteCall{Msg: "simulated remote load failure"}
+}
+
+func TestOnAccessOfGetVariants(t *testing.T) {
+	clock := newFakeClock()
+	var mu sync.Mutex
+	var events []AccessEvent
+	g := newGroupOpts("onAccessVariantsTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("local:" + key)
+	}), prefixPeers{"good": &multiPeer{}, "bad": &remoteErrPeer{}}, &GroupOptions{
+		TTL:   time.Minute,
+		Clock: clock.Now,
+		OnAccess: func(e AccessEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, e)
+		},
+	})
+	defer DeregisterGroup(g.Name())
+	type access struct {
+		key    string
+		source AccessSource
+		err    bool
+	}
+	expect := func(what string, want ...access) {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		var got []access
+		for _, e := range events {
+			got = append(got, access{e.Key, e.Source, e.Err != nil})
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i].key < got[j].key })
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s reported %v; want %v", what, got, want)
+		}
+		events = nil
+	}
+
+	g.GetMultiPartial(context.Background(), []string{"good-1", "bad-1", "local"})
+	expect("GetMultiPartial", access{"bad-1", PeerAccess, true}, access{"good-1", PeerAccess, false}, access{"local", LoadAccess, false})
+	g.GetMulti(context.Background(), []string{"good-2", "local"})
+	expect("GetMulti", access{"good-2", PeerAccess, false}, access{"local", MainCacheAccess, false})
+
+	g.GetRange(context.Background(), "good-3", 0, 2, ByteViewSink(new(ByteView)))
+	expect("GetRange", access{"good-3", PeerAccess, false})
+	g.GetIfModified(context.Background(), "good-4", "", ByteViewSink(new(ByteView)))
+	expect("GetIfModified", access{"good-4", PeerAccess, false})
+
+	clock.Advance(61 * time.Second)
+	if err := g.GetStale(context.Background(), "local", ByteViewSink(new(ByteView)), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	g.Close()
+	expect("GetStale", access{"local", MainCacheAccess, false})
+}
+
+func TestLocalFallback(t *testing.T) {
+	// A peer nothing listens on.
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	unreachable := &httpGetter{baseURL: server.URL + defaultBasePath}
+	failing := &remoteErrPeer{}
+
+	var loads int
+	getter := GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		loads++
+		return dest.SetString("local:" + key)
+	})
+	g := newGroupOpts("localFallbackTest", 1<<20, getter,
+		fakePeers([]ProtoGetter{unreachable, failing}), &GroupOptions{LocalFallback: true})
+	strict := newGroup("localFallbackTestStrict", 1<<20, getter, fakePeers([]ProtoGetter{failing}))
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		var s string
+		if err := g.Get(context.Background(), key, StringSink(&s)); err != nil {
+			t.Fatalf("Get(%q) = %v", key, err)
+		}
+		if s != "local:"+key {
+			t.Errorf("Get(%q) = %q; want the local value", key, s)
+		}
+		if !g.hotCache.contains(key) || g.mainCache.contains(key) {
+			t.Errorf("expected %q to be cached in the hot cache only", key)
+		}
+	}
+	if loads != 10 || failing.hits == 0 {
+		t.Errorf("got %d local loads and %d failing peer loads; want 10 and some", loads, failing.hits)
+	}
+
+	if err := strict.Get(context.Background(), "key", StringSink(new(string))); !errors.Is(err, &ErrRemoteCall{}) {
+		t.Errorf("Get without LocalFallback = %v; want ErrRemoteCall", err)
+	}
+}
+
+func TestMaxKeyBytes(t *testing.T) {
+	var loads int
+	g := newGroupOpts("maxKeyBytesTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		loads++
+		return dest.SetString("value")
+	}), NoPeers{}, &GroupOptions{MaxKeyBytes: 8})
+
+	if err := g.Get(context.Background(), "12345678", StringSink(new(string))); err != nil {
+		t.Fatalf("Get of a key at the limit = %v", err)
+	}
+	long := strings.Repeat("k", 9)
+	if err := g.Get(context.Background(), long, StringSink(new(string))); !errors.Is(err, &ErrKeyTooLong{}) {
+		t.Errorf("Get of an over-long key = %v; want ErrKeyTooLong", err)
+	}
+	if err := g.Set(context.Background(), long, []byte("value"), false); !errors.Is(err, &ErrKeyTooLong{}) {
+		t.Errorf("Set of an over-long key = %v; want ErrKeyTooLong", err)
+	}
+	if _, err := g.GetMulti(context.Background(), []string{"a", long}); !errors.Is(err, &ErrKeyTooLong{}) {
+		t.Errorf("GetMulti with an over-long key = %v; want ErrKeyTooLong", err)
+	}
+	if loads != 1 {
+		t.Errorf("Getter ran %d times; want 1", loads)
+	}
+}
+
+func TestGroupNames(t *testing.T) {
+	want := []string{"groupNamesTest-a", "groupNamesTest-b", "groupNamesTest-c"}
+	var wg sync.WaitGroup
+	for _, name := range want {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			newGroup(name, 1<<20, nil, NoPeers{})
+		}(name)
+	}
+	wg.Wait()
+	defer func() {
+		for _, name := range want {
+			DeregisterGroup(name)
+		}
+	}()
+
+	var got []string
+	for _, name := range GroupNames() {
+		if strings.HasPrefix(name, "groupNamesTest-") {
+			got = append(got, name)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GroupNames() = %q; want %q", got, want)
+	}
+
+	got = nil
+	EachGroup(func(g *Group) {
+		if strings.HasPrefix(g.Name(), "groupNamesTest-") {
+			got = append(got, g.Name())
+		}
+	})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EachGroup visited %q; want %q", got, want)
+	}
+}
+
+// namedPeer is a failing peer which names itself.
+type namedPeer struct {
+	remoteErrPeer
+}
+
+func (p *namedPeer) PeerID() string {
+	return "peer-7"
+}
+
+func TestPeerIDInErrors(t *testing.T) {
+	g := newGroup("peerIDTest", 1<<20, nil, fakePeers([]ProtoGetter{&namedPeer{}}))
+	err := g.Get(context.Background(), "key", StringSink(new(string)))
+	if !errors.Is(err, &ErrRemoteCall{}) {
+		t.Fatalf("Get = %v; want ErrRemoteCall", err)
+	}
+	if !strings.Contains(err.Error(), "peer-7") {
+		t.Errorf("error %q does not name the peer", err)
+	}
+
+	if got := peerID(&fakePeer{}); got != "fakePeer" {
+		t.Errorf("peerID of a peer without PeerID = %q; want its URL", got)
+	}
+	if got := (&HTTPPool{opts: HTTPPoolOptions{BasePath: defaultBasePath}}).newHTTPGetter("http://10.0.0.2:8008").PeerID(); got != "http://10.0.0.2:8008" {
+		t.Errorf("httpGetter.PeerID() = %q", got)
+	}
+}
+
+func TestTenantBudgets(t *testing.T) {
+	// Without budgets, the noisy tenant would evict every quiet entry.
+	g := newGroupOpts("tenantBudgetsTest", 2000, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString(strings.Repeat("x", 90))
+	}), NoPeers{}, &GroupOptions{
+		Tenant: func(key string) string {
+			return strings.SplitN(key, "/", 2)[0]
+		},
+		TenantBytes:        map[string]int64{"quiet": 10000},
+		DefaultTenantBytes: 1000,
+	})
+
+	get := func(key string) {
+		t.Helper()
+		if err := g.Get(context.Background(), key, StringSink(new(string))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		get(fmt.Sprintf("quiet/%d", i))
+	}
+	for i := 0; i < 1000; i++ {
+		get(fmt.Sprintf("noisy/%03d", i))
+	}
+
+	for i := 0; i < 5; i++ {
+		if key := fmt.Sprintf("quiet/%d", i); !g.Contains(key) {
+			t.Errorf("expected %s to survive the noisy tenant", key)
+		}
+	}
+	noisy := g.mainCache.tenantBytes["noisy"]
+	if noisy > 1000 || noisy < 900 {
+		t.Errorf("noisy tenant holds %d bytes; want its budget of 1000 nearly full", noisy)
+	}
+	if !g.Contains("noisy/999") || g.Contains("noisy/000") {
+		t.Error("expected the noisy tenant to lose its oldest entries")
+	}
+	if got, want := g.mainCache.bytes(), noisy+g.mainCache.tenantBytes["quiet"]; got != want {
+		t.Errorf("cache holds %d bytes; tenants account for %d", got, want)
+	}
+	if got, want := len(g.mainCache.tenantElems), g.mainCache.items(); int64(got) != want {
+		t.Errorf("tenants list %d entries; cache holds %d", got, want)
+	}
+}
+
+func TestOnLoad(t *testing.T) {
+	type sample struct {
+		key    string
+		d      time.Duration
+		source LoadSource
+	}
+	var mu sync.Mutex
+	var samples []sample
+	onLoad := func(key string, d time.Duration, source LoadSource) {
+		mu.Lock()
+		defer mu.Unlock()
+		samples = append(samples, sample{key, d, source})
+	}
+
+	peer := &fakePeer{}
+	g := newGroupOpts("onLoadTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		time.Sleep(10 * time.Millisecond)
+		return dest.SetString("value")
+	}), fakePeers([]ProtoGetter{peer, nil}), &GroupOptions{OnLoad: onLoad})
+
+	var localKey, peerKey string
+	for i := 0; localKey == "" || peerKey == ""; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if _, ok := g.pickPeer(key); ok {
+			peerKey = key
+		} else {
+			localKey = key
+		}
+	}
+	for _, key := range []string{localKey, peerKey, localKey} {
+		if err := g.Get(context.Background(), key, StringSink(new(string))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(samples) != 2 {
+		t.Fatalf("OnLoad fired %d times; want once per load", len(samples))
+	}
+	if s := samples[0]; s.key != localKey || s.source != LocalLoad || s.d < 10*time.Millisecond {
+		t.Errorf("local load sample = %+v", s)
+	}
+	if s := samples[1]; s.key != peerKey || s.source != PeerLoad || s.d < 0 {
+		t.Errorf("peer load sample = %+v", s)
+	}
+
+	samples = nil
+	sampled := newGroupOpts("onLoadSamplingTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value")
+	}), NoPeers{}, &GroupOptions{OnLoad: onLoad, OnLoadSampling: 10})
+	for i := 0; i < 100; i++ {
+		if err := sampled.Get(context.Background(), fmt.Sprintf("key-%d", i), StringSink(new(string))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(samples) != 10 {
+		t.Errorf("OnLoad fired %d times for 100 loads sampled 1 in 10; want 10", len(samples))
+	}
+}
+
+func TestEvictionRate(t *testing.T) {
+	clock := newFakeClock()
+	g := newGroupOpts("evictionRateTest", 1000, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString(strings.Repeat("x", 93))
+	}), NoPeers{}, &GroupOptions{Clock: clock.Now})
+
+	// Each entry takes 100 bytes, so every load past the tenth evicts.
+	for i := 0; i < 110; i++ {
+		if err := g.Get(context.Background(), fmt.Sprintf("key-%03d", i), StringSink(new(string))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := g.EvictionRate(10 * time.Second); got != 10 {
+		t.Errorf("EvictionRate over 10s after 100 evictions = %v; want 10", got)
+	}
+	if got := g.EvictionRate(time.Second); got != 100 {
+		t.Errorf("EvictionRate over 1s after 100 evictions = %v; want 100", got)
+	}
+
+	g.Remove(context.Background(), "key-109")
+	if got := g.EvictionRate(time.Second); got != 100 {
+		t.Errorf("EvictionRate after Remove = %v; want removals not to count", got)
+	}
+
+	clock.Advance(5 * time.Second)
+	if got := g.EvictionRate(10 * time.Second); got != 10 {
+		t.Errorf("EvictionRate 5s after the burst = %v; want 10", got)
+	}
+	if got := g.EvictionRate(time.Second); got != 0 {
+		t.Errorf("EvictionRate over the last second, 5s after the burst = %v; want 0", got)
+	}
+	clock.Advance(10 * time.Second)
+	if got := g.EvictionRate(10 * time.Second); got != 0 {
+		t.Errorf("EvictionRate 15s after the burst = %v; want 0", got)
+	}
+}
+
+// sourcePeer serves 90 byte values as the peer named name.
+type sourcePeer struct {
+	fakePeer
+	name string
+}
+
+func (p *sourcePeer) Get(_ context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	out.Value = []byte(strings.Repeat("x", 90))
+	return nil
+}
+
+func (p *sourcePeer) GetURL() string {
+	return p.name
+}
+
+// prefixPeers picks the peer named by the part of a key before "-".
+type prefixPeers map[string]ProtoGetter
+
+func (p prefixPeers) PickPeer(key string) (ProtoGetter, bool) {
+	peer, ok := p[strings.SplitN(key, "-", 2)[0]]
+	return peer, ok
+}
+
+func (p prefixPeers) GetAll() []ProtoGetter {
+	var res []ProtoGetter
+	for _, peer := range p {
+		res = append(res, peer)
+	}
+	return res
+}
+
+func TestHotCachePeerShare(t *testing.T) {
+	peers := prefixPeers{"a": &sourcePeer{name: "a"}, "b": &sourcePeer{name: "b"}}
+	for _, share := range []float64{0, 0.5} {
+		// The hot cache's share is 1000 bytes, and entries take 94.
+		g := newGroupOpts(fmt.Sprintf("hotCachePeerShareTest-%v", share), 9000, nil, peers,
+			&GroupOptions{HotCachePeerShare: share})
+		get := func(key string) {
+			var s string
+			if err := g.Get(context.Background(), key, StringSink(&s)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 0; i < 3; i++ {
+			get(fmt.Sprintf("b-%d", i))
+		}
+		// a floods the hot cache with more than the whole cache holds.
+		for i := 0; i < 100; i++ {
+			get(fmt.Sprintf("a-%02d", i))
+		}
+
+		bKept := 0
+		for i := 0; i < 3; i++ {
+			if g.hotCache.contains(fmt.Sprintf("b-%d", i)) {
+				bKept++
+			}
+		}
+		if share == 0 {
+			if bKept != 0 {
+				t.Errorf("without a share, kept %d of b's entries; want a to evict them", bKept)
+			}
+			continue
+		}
+		if bKept != 3 {
+			t.Errorf("kept %d of b's entries; want all 3", bKept)
+		}
+		if got := g.hotCache.tenantBytes["a"]; got > 500 || got == 0 {
+			t.Errorf("a holds %d hot cache bytes; want up to its 500 byte share", got)
+		}
+		if !g.hotCache.contains("a-99") {
+			t.Error("expected a's most recent entry to be kept")
+		}
+	}
+}
+
+func TestGetBytes(t *testing.T) {
+	var loads int
+	g := newGroup("getBytesTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		loads++
+		if key == "missing" {
+			return &ErrNotFound{Msg: "not found"}
+		}
+		return dest.SetBytes([]byte("value:" + key))
+	}), NoPeers{})
+
+	b, err := g.GetBytes(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "value:key" {
+		t.Fatalf("GetBytes = %q; want value:key", b)
+	}
+	b[0] = 'X'
+
+	s, err := g.GetString(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "value:key" {
+		t.Errorf("GetString after modifying GetBytes' result = %q; want value:key", s)
+	}
+	if loads != 1 {
+		t.Errorf("loaded %d times; want 1", loads)
+	}
+
+	if _, err := g.GetBytes(context.Background(), "missing"); !errors.Is(err, &ErrNotFound{}) {
+		t.Errorf("GetBytes of a missing key = %v; want ErrNotFound", err)
+	}
+	if _, err := g.GetString(context.Background(), "missing"); !errors.Is(err, &ErrNotFound{}) {
+		t.Errorf("GetString of a missing key = %v; want ErrNotFound", err)
+	}
+}
+
+func TestEntryInfo(t *testing.T) {
+	clock := newFakeClock()
+	peers := prefixPeers{"remote": &sourcePeer{name: "http://peer-b:8080"}}
+	getter := GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("local")
+	})
+	g := newGroupOpts("entryInfoTest", 1<<20, getter, peers, &GroupOptions{TrackEntryInfo: true, Clock: clock.Now})
+
+	for _, key := range []string{"remote-key", "local-key"} {
+		if _, err := g.GetString(context.Background(), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, ok := g.EntryInfo("remote-key")
+	if !ok {
+		t.Fatal("no EntryInfo for a fetched key")
+	}
+	want := EntryInfo{Cache: HotCache, Source: PeerLoad, Peer: "http://peer-b:8080", Added: clock.Now(), Bytes: int64(len("remote-key") + 90)}
+	if *info != want {
+		t.Errorf("EntryInfo(remote-key) = %+v; want %+v", *info, want)
+	}
+	info, ok = g.EntryInfo("local-key")
+	if !ok {
+		t.Fatal("no EntryInfo for a loaded key")
+	}
+	want = EntryInfo{Cache: MainCache, Source: LocalLoad, Added: clock.Now(), Bytes: int64(len("local-key") + len("local"))}
+	if *info != want {
+		t.Errorf("EntryInfo(local-key) = %+v; want %+v", *info, want)
+	}
+	if _, ok := g.EntryInfo("uncached"); ok {
+		t.Error("EntryInfo of an uncached key reported true")
+	}
+
+	off := newGroupOpts("entryInfoOffTest", 1<<20, getter, peers, nil)
+	if _, err := off.GetString(context.Background(), "remote-key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := off.EntryInfo("remote-key"); ok {
+		t.Error("EntryInfo reported true without TrackEntryInfo")
+	}
+	if value, _ := off.hotCache.peek("remote-key"); value.source() != "" {
+		t.Errorf("recorded source %q without TrackEntryInfo", value.source())
+	}
+}
+
+func TestGetStale(t *testing.T) {
+	clock := newFakeClock()
+	var loads AtomicInt
+	release := make(chan struct{})
+	g := newGroupOpts("getStaleTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		n := atomic.AddInt64((*int64)(&loads), 1)
+		if n == 2 {
+			<-release
+		}
+		return dest.SetString(fmt.Sprintf("value-%d", n))
+	}), NoPeers{}, &GroupOptions{TTL: time.Minute, Clock: clock.Now})
+	getStale := func() string {
+		var s string
+		if err := g.GetStale(context.Background(), "key", StringSink(&s), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	if got := getStale(); got != "value-1" {
+		t.Fatalf("got %q; want value-1", got)
+	}
+
+	// Just past the TTL, the expired value is returned while the
+	// refresh is blocked in the Getter.
+	clock.Advance(61 * time.Second)
+	if got := getStale(); got != "value-1" {
+		t.Errorf("got %q just past the TTL; want the stale value-1", got)
+	}
+	if got := g.Stats.StaleHits.Get(); got != 1 {
+		t.Errorf("StaleHits = %d; want 1", got)
+	}
+	close(release)
+	g.background.Wait()
+	if got := getStale(); got != "value-2" {
+		t.Errorf("got %q after the refresh; want value-2", got)
+	}
+
+	// Too stale, the key is loaded before returning.
+	clock.Advance(3 * time.Minute)
+	if got := getStale(); got != "value-3" {
+		t.Errorf("got %q past maxStale; want value-3", got)
+	}
+	if got := loads.Get(); got != 3 {
+		t.Errorf("loaded %d times; want 3", got)
+	}
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	record := func(name string) GetterMiddleware {
+		return func(next Getter) Getter {
+			return GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+				calls = append(calls, name+" before")
+				err := next.Get(ctx, key, dest)
+				calls = append(calls, name+" after")
+				return err
+			})
+		}
+	}
+	base := GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		calls = append(calls, "base")
+		return dest.SetString("value:" + key)
+	})
+
+	g := newGroup("chainTest", 1<<20, Chain(base, record("outer"), record("inner")), NoPeers{})
+	s, err := g.GetString(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "value:key" {
+		t.Errorf("got %q; want value:key", s)
+	}
+	want := []string{"outer before", "inner before", "base", "inner after", "outer after"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q; want %q", calls, want)
+	}
+}
+
+func TestPin(t *testing.T) {
+	g := newGroup("pinTest", 1000, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString(strings.Repeat("x", 93))
+	}), NoPeers{})
+	// Pinning works before the key is cached.
+	g.Pin("config")
+	if _, err := g.GetString(context.Background(), "config"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := g.GetString(context.Background(), fmt.Sprintf("key-%02d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !g.Contains("config") {
+		t.Error("expected the pinned key to survive filling the cache")
+	}
+	if g.Contains("key-00") {
+		t.Error("expected unpinned keys to be evicted")
+	}
+	if got := g.mainCache.bytes(); got > 1000 {
+		t.Errorf("cache holds %d bytes; want at most 1000", got)
+	}
+
+	// Shrinking the cache to one entry keeps the pinned one.
+	g.SetCacheBytes(150)
+	if !g.Contains("config") || g.mainCache.items() != 1 {
+		t.Errorf("after shrinking, config cached: %v, with %d entries; want only config", g.Contains("config"), g.mainCache.items())
+	}
+
+	g.Unpin("config")
+	if _, err := g.GetString(context.Background(), "key-new"); err != nil {
+		t.Fatal(err)
+	}
+	if g.Contains("config") {
+		t.Error("expected the unpinned key to be evicted")
+	}
+
+	// When every entry is pinned, the cache outgrows its limit instead
+	// of evicting.
+	all := newGroup("pinAllTest", 200, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString(strings.Repeat("x", 93))
+	}), NoPeers{})
+	for i := 0; i < 3; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		all.Pin(key)
+		if _, err := all.GetString(context.Background(), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := all.mainCache.items(); got != 3 {
+		t.Errorf("cache holds %d pinned entries; want 3", got)
+	}
+}
+
+func TestWriterSink(t *testing.T) {
+	var loads int
+	peer := &fakePeer{}
+	g := newGroup("writerSinkTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		loads++
+		return dest.SetBytes([]byte("value:" + key))
+	}), prefixPeers{"remote": peer})
+
+	for _, key := range []string{"key", "key", "remote-key", "remote-key"} {
+		var buf bytes.Buffer
+		if err := g.Get(context.Background(), key, WriterSink(&buf)); err != nil {
+			t.Fatal(err)
+		}
+		want := "value:" + key
+		if strings.HasPrefix(key, "remote") {
+			want = "got:" + key
+		}
+		if buf.String() != want {
+			t.Errorf("wrote %q for %s; want %q", buf.String(), key, want)
+		}
+	}
+	if loads != 1 || peer.hits != 1 {
+		t.Errorf("loaded %d times and fetched %d times; want each value cached after once", loads, peer.hits)
+	}
+
+	w := &failingWriter{}
+	if err := g.Get(context.Background(), "key", WriterSink(w)); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("Get into a failing writer = %v; want its error", err)
+	}
+}
+
+func TestWriterSinkWritesOnSuccess(t *testing.T) {
+	var loads int
+	g := NewGroupWithOptions("writerSinkSuccessTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		loads++
+		if err := dest.SetString("partial"); err != nil {
+			return err
+		}
+		if key == "fallback" {
+			return errors.New("primary failed")
+		}
+		return dest.SetString("value:" + key)
+	}), &GroupOptions{
+		LocalOnly: true,
+		FallbackGetter: GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+			return dest.SetString("fallback:" + key)
+		}),
+		Validate: func(key string, value []byte) error {
+			if key == "invalid" {
+				return errors.New("invalid value")
+			}
+			return nil
+		},
+	})
+	defer DeregisterGroup("writerSinkSuccessTest")
+	ctx := context.Background()
+
+	// A failing writer fails the Get but not the load.
+	if err := g.Get(ctx, "key", WriterSink(failingWriter{})); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("Get into a failing writer = %v; want its error", err)
+	}
+	var buf bytes.Buffer
+	if err := g.Get(ctx, "key", WriterSink(&buf)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "value:key" || loads != 1 {
+		t.Errorf("wrote %q after %d loads; want %q cached after the first", buf.String(), loads, "value:key")
+	}
+
+	buf.Reset()
+	if err := g.Get(ctx, "fallback", WriterSink(&buf)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "fallback:fallback" {
+		t.Errorf("wrote %q with the fallback; want only its value", buf.String())
+	}
+
+	buf.Reset()
+	if err := g.Get(ctx, "invalid", WriterSink(&buf)); err == nil {
+		t.Error("Get of an invalid value succeeded")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("wrote %q for an invalid value; want nothing", buf.String())
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func TestStatsSnapshot(t *testing.T) {
+	// Every counter has a snapshot field.
+	st, snap := reflect.TypeOf(Stats{}), reflect.TypeOf(StatsSnapshot{})
+	if st.NumField() != snap.NumField() {
+		t.Fatalf("Stats has %d fields and StatsSnapshot %d", st.NumField(), snap.NumField())
+	}
+	for i := 0; i < st.NumField(); i++ {
+		name := st.Field(i).Name
+		f, ok := snap.FieldByName(name)
+		if !ok || f.Type.Kind() != reflect.Int64 {
+			t.Errorf("StatsSnapshot has no int64 field %s", name)
+		}
+	}
+
+	g := newGroup("statsSnapshotTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value")
+	}), NoPeers{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.GetString(context.Background(), "key")
+			g.Stats.Snapshot()
+		}()
+	}
+	wg.Wait()
+
+	s := g.Stats.Snapshot()
+	copied := s
+	g.Stats.Gets.Add(1)
+	if copied.Gets != 10 || s.Gets != 10 {
+		t.Errorf("snapshot Gets = %d and copy %d; want both to stay 10", s.Gets, copied.Gets)
+	}
+	if s.LocalLoads != 1 || s.CacheHits+s.CoalescedLoads+s.LocalLoads < 10 {
+		t.Errorf("snapshot = %+v; want one local load answering every Get", s)
+	}
+}
+
+func TestStatsSnapshotCounters(t *testing.T) {
+	var s StatsSnapshot
+	v := reflect.ValueOf(&s).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		v.Field(i).SetInt(int64(i + 1))
+	}
+
+	counters := s.Counters()
+	if len(counters) != v.NumField() {
+		t.Fatalf("got %d counters; want one for each of the %d fields", len(counters), v.NumField())
+	}
+	for i, c := range counters {
+		if f := v.FieldByName(c.Name); !f.IsValid() || f.Int() != c.Value {
+			t.Errorf("counter %s = %d; want the value of its field", c.Name, c.Value)
+		}
+		if i > 0 && counters[i-1].Name >= c.Name {
+			t.Errorf("counter %s follows %s; want them sorted by name", c.Name, counters[i-1].Name)
+		}
+	}
+	if again := s.Counters(); !reflect.DeepEqual(again, counters) {
+		t.Errorf("second Counters() = %v; want %v", again, counters)
+	}
+}
+
+func TestAllStats(t *testing.T) {
+	g := newGroupOpts("allStatsTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value:" + key)
+	}), NoPeers{}, &GroupOptions{})
+	for i := 0; i < 10; i++ {
+		if _, err := g.GetString(context.Background(), fmt.Sprintf("key-%d", i%4)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g.localSet("hot", []byte("value"), &g.hotCache)
+
+	got := g.AllStats()
+	want := GroupStats{
+		Name:      "allStatsTest",
+		Group:     g.Stats.Snapshot(),
+		MainCache: g.CacheStats(MainCache),
+		HotCache:  g.CacheStats(HotCache),
+	}
+	if got != want {
+		t.Errorf("AllStats = %+v; want %+v", got, want)
+	}
+	if got.Group.Gets != 10 || got.Group.LocalLoads != 4 || got.MainCache.Items != 4 || got.HotCache.Items != 1 {
+		t.Errorf("AllStats = %+v; want 10 gets, 4 loads and 4 main and 1 hot items", got)
+	}
+}
+
+func TestHashSeed(t *testing.T) {
+	getter := GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("same content")
+	})
+	etag := func(name string, seed uint64) string {
+		g := newGroupOpts(name, 1<<20, getter, NoPeers{}, &GroupOptions{HashSeed: seed})
+		tag, _, err := g.GetIfModified(context.Background(), "key", "", StringSink(new(string)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The cached value has the same ETag as the loaded one.
+		if again, _, _ := g.GetIfModified(context.Background(), "key", "", StringSink(new(string))); again != tag {
+			t.Errorf("seed %d: cached ETag %s differs from loaded ETag %s", seed, again, tag)
+		}
+		return tag
+	}
+
+	unseeded := etag("hashSeedTest-0", 0)
+	if want := (ByteView{s: "same content"}).ETag(); unseeded != want {
+		t.Errorf("ETag without a seed = %s; want ByteView.ETag %s", unseeded, want)
+	}
+	a, b := etag("hashSeedTest-1", 1), etag("hashSeedTest-2", 2)
+	if a == b || a == unseeded {
+		t.Errorf("seeds 0, 1 and 2 gave ETags %s, %s and %s; want them all to differ", unseeded, a, b)
+	}
+	if again := etag("hashSeedTest-1b", 1); again != a {
+		t.Errorf("seed 1 gave ETags %s and %s; want them equal", a, again)
+	}
+}
+
+func TestFallbackGetter(t *testing.T) {
+	errPrimary := errors.New("primary is down")
+	var primary, fallback int
+	var fallbackErr error
+	g := NewGroupWithOptions("fallbackGetterTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		primary++
+		return errPrimary
+	}), &GroupOptions{
+		LocalOnly: true,
+		FallbackGetter: GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+			fallback++
+			if fallbackErr != nil {
+				return fallbackErr
+			}
+			return dest.SetString("backup:" + key)
+		}),
+	})
+	defer DeregisterGroup("fallbackGetterTest")
+
+	for i := 0; i < 2; i++ {
+		s, err := g.GetString(context.Background(), "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s != "backup:key" {
+			t.Errorf("Get #%d = %q; want backup:key", i, s)
+		}
+	}
+	if primary != 1 || fallback != 1 {
+		t.Errorf("primary ran %d and fallback %d times; want each once, then a cache hit", primary, fallback)
+	}
+
+	fallbackErr = errors.New("backup is down")
+	_, err := g.GetString(context.Background(), "other")
+	if !errors.Is(err, errPrimary) || !strings.Contains(err.Error(), fallbackErr.Error()) {
+		t.Errorf("got error %v; want one combining both failures", err)
+	}
+}
+
+func TestGroupOnEvict(t *testing.T) {
+	g := NewGroupWithOptions("groupOnEvictTest", 100, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString(strings.Repeat("v", 15) + key)
+	}), &GroupOptions{LocalOnly: true})
+	defer DeregisterGroup("groupOnEvictTest")
+
+	evicted := map[string]string{}
+	g.OnEvict(func(key string, value ByteView) {
+		evicted[key] = value.String()
+	})
+	var hotEvictions int
+	g.OnHotEvict(func(key string, value ByteView) { hotEvictions++ })
+
+	// Entries take 25 bytes, so only four fit.
+	for i := 0; i < 10; i++ {
+		if _, err := g.GetString(context.Background(), fmt.Sprintf("key-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(evicted) != 6 {
+		t.Errorf("got %d evictions; want 6", len(evicted))
+	}
+	for key, value := range evicted {
+		if want := strings.Repeat("v", 15) + key; value != want {
+			t.Errorf("evicted %s with value %q; want %q", key, value, want)
+		}
+		if g.Contains(key) {
+			t.Errorf("evicted %s is still cached", key)
+		}
+	}
+	if hotEvictions != 0 {
+		t.Errorf("got %d hot cache evictions; want 0", hotEvictions)
+	}
+
+	// Removals are not evictions.
+	evicted = map[string]string{}
+	if err := g.Remove(context.Background(), "key-9"); err != nil {
+		t.Fatal(err)
+	}
+	if len(evicted) != 0 {
+		t.Errorf("Remove reported evictions %v", evicted)
+	}
+}
+
+func TestCacheShards(t *testing.T) {
+	var loads int32
+	g := NewGroupWithOptions("cacheShardsTest", 1000, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		atomic.AddInt32(&loads, 1)
+		return dest.SetString(strings.Repeat("v", 40))
+	}), &GroupOptions{LocalOnly: true, CacheShards: 4})
+	defer DeregisterGroup("cacheShardsTest")
+	if len(g.mainCache.shards) != 4 || len(g.hotCache.shards) != 4 {
+		t.Fatalf("got %d main and %d hot shards; want 4 each", len(g.mainCache.shards), len(g.hotCache.shards))
+	}
+
+	var evicted int
+	g.OnEvict(func(key string, value ByteView) { evicted++ })
+	for i := 0; i < 100; i++ {
+		if _, err := g.GetString(context.Background(), fmt.Sprintf("key-%02d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := g.CacheStats(MainCache)
+	if stats.Bytes > 1000 || stats.Bytes < 800 {
+		t.Errorf("main cache holds %d bytes; want nearly the 1000 byte budget", stats.Bytes)
+	}
+	if stats.Items+int64(evicted) != 100 || stats.Evictions != int64(evicted) {
+		t.Errorf("stats = %+v after %d evictions; want every key cached or evicted", stats, evicted)
+	}
+	for _, shard := range g.mainCache.shards {
+		if n := shard.bytes(); n == 0 || n > 500 {
+			t.Errorf("a shard holds %d bytes; want about a quarter of the budget", n)
+		}
+	}
+	if got := len(g.mainCache.keys(0)); int64(got) != stats.Items {
+		t.Errorf("listed %d keys; want %d", got, stats.Items)
+	}
+
+	// A cached key is served from its shard until it is removed.
+	key := g.mainCache.keys(1)[0]
+	before := atomic.LoadInt32(&loads)
+	if _, err := g.GetString(context.Background(), key); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Remove(context.Background(), key); err != nil {
+		t.Fatal(err)
+	}
+	if g.Contains(key) {
+		t.Errorf("expected %s to have been removed", key)
+	}
+	if got := atomic.LoadInt32(&loads); got != before {
+		t.Errorf("getting a cached key made %d loads", got-before)
+	}
+
+	for _, o := range []*GroupOptions{
+		{CacheShards: 4, MainCachePolicy: NewLFUPolicy()},
+		{CacheShards: 4, HotCachePolicy: NewLFUPolicy()},
+	} {
+		if _, err := tryNewGroupOpts("cacheShardsPolicyTest", 1000, nil, NoPeers{}, o); err == nil {
+			DeregisterGroup("cacheShardsPolicyTest")
+			t.Error("expected CacheShards with an EvictionPolicy to be rejected")
+		}
+	}
+}
+
+func BenchmarkCacheShards(b *testing.B) {
+	const keys = 1024
+	for _, shards := range []int{1, 16} {
+		c := &cache{}
+		if shards > 1 {
+			c.split(shards)
+		}
+		var names []string
+		for i := 0; i < keys; i++ {
+			names = append(names, fmt.Sprintf("key-%d", i))
+			c.add(names[i], ByteView{s: "value"})
+		}
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			b.SetParallelism(8)
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					key := names[i%keys]
+					if i%8 == 0 {
+						c.add(key, ByteView{s: "value"})
+					} else {
+						c.get(key)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestGetWithTTL(t *testing.T) {
+	clock := newFakeClock()
+	g := NewGroupWithOptions("getWithTTLTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value")
+	}), &GroupOptions{LocalOnly: true, TTL: time.Minute, Clock: clock.Now})
+	defer DeregisterGroup("getWithTTLTest")
+
+	var s string
+	ttl, err := g.GetWithTTL(context.Background(), "key", StringSink(&s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "value" || ttl != time.Minute {
+		t.Errorf("got %q, fresh for %v; want value fresh for 1m", s, ttl)
+	}
+
+	clock.Advance(20 * time.Second)
+	if ttl, err = g.GetWithTTL(context.Background(), "key", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if want := 40 * time.Second; ttl < want-time.Second || ttl > want {
+		t.Errorf("cached value is fresh for %v; want about %v", ttl, want)
+	}
+
+	forever := NewGroupWithOptions("getWithTTLTest-forever", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value")
+	}), &GroupOptions{LocalOnly: true})
+	defer DeregisterGroup("getWithTTLTest-forever")
+	for i := 0; i < 2; i++ {
+		if ttl, err = forever.GetWithTTL(context.Background(), "key", StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+		if ttl != NoExpiry {
+			t.Errorf("Get #%d: got TTL %v for a value which doesn't expire; want NoExpiry", i, ttl)
+		}
+	}
+}
+
+func TestMaxFlights(t *testing.T) {
+	const limit = 3
+	var running, max int32
+	g := NewGroupWithOptions("maxFlightsTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return dest.SetString("value:" + key)
+	}), &GroupOptions{LocalOnly: true, MaxFlights: limit})
+	defer DeregisterGroup("maxFlightsTest")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key-%d", i)
+			if s, err := g.GetString(context.Background(), key); err != nil || s != "value:"+key {
+				t.Errorf("Get(%q) = %q, %v", key, s, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := atomic.LoadInt32(&max); got > limit || got == 0 {
+		t.Errorf("%d loads ran at once; want at most %d", got, limit)
+	}
+
+	// A Get waiting for a free slot gives up with its context.
+	release := make(chan struct{})
+	blocked := NewGroupWithOptions("maxFlightsTest-blocked", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		<-release
+		return dest.SetString("value")
+	}), &GroupOptions{LocalOnly: true, MaxFlights: 1})
+	defer DeregisterGroup("maxFlightsTest-blocked")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		blocked.GetString(context.Background(), "slow")
+	}()
+	for len(blocked.flights) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	var s string
+	if err := blocked.Get(ctx, "waiting", StringSink(&s)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the waiting Get to time out; got %v", err)
+	}
+	close(release)
+	<-done
+}
+
+func TestLoadWaitTimeout(t *testing.T) {
+	var loads int32
+	started, release := make(chan struct{}), make(chan struct{})
+	g := NewGroupWithOptions("loadWaitTimeoutTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		if atomic.AddInt32(&loads, 1) == 1 {
+			close(started)
+			<-release
+		}
+		return dest.SetString("value")
+	}), &GroupOptions{LocalOnly: true, LoadWaitTimeout: 10 * time.Millisecond})
+	defer DeregisterGroup("loadWaitTimeoutTest")
+
+	// The leader's load outlasts LoadWaitTimeout, since it only bounds
+	// waiting on the loads of others.
+	var leader string
+	done := make(chan error)
+	go func() {
+		<-started
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	go func() {
+		done <- g.Get(context.Background(), "key", StringSink(&leader))
+	}()
+	<-started
+
+	var s string
+	if err := g.Get(context.Background(), "key", StringSink(&s)); !errors.Is(err, &ErrLoadWaitTimeout{}) {
+		t.Errorf("expected the waiting Get to time out; got %v", err)
+	}
+	if err := <-done; err != nil || leader != "value" {
+		t.Errorf("leader Get = %q, %v; want value", leader, err)
+	}
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("loaded %d times; want 1", n)
+	}
+	if err := g.Get(context.Background(), "key", StringSink(&s)); err != nil || s != "value" {
+		t.Errorf("Get after the load = %q, %v; want the cached value", s, err)
+	}
+}
+
+func TestTryNewGroup(t *testing.T) {
+	getter := GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value")
+	})
+	g, err := TryNewGroup("tryNewGroupTest", 1<<20, getter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeregisterGroup("tryNewGroupTest")
+
+	again, err := TryNewGroup("tryNewGroupTest", 1<<20, getter)
+	if !errors.Is(err, &ErrDuplicateGroup{}) || again != nil {
+		t.Errorf("second TryNewGroup = %v, %v; want ErrDuplicateGroup", again, err)
+	}
+	if GetGroup("tryNewGroupTest") != g {
+		t.Error("the failed registration replaced the existing group")
+	}
+}
+
+func TestTTLJitter(t *testing.T) {
+	clock := newFakeClock()
+	g := NewGroupWithOptions("ttlJitterTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value")
+	}), &GroupOptions{LocalOnly: true, TTL: 100 * time.Second, TTLJitter: 0.2, Clock: clock.Now})
+	defer DeregisterGroup("ttlJitterTest")
+
+	var minTTL, maxTTL time.Duration
+	distinct := map[time.Duration]bool{}
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		var s string
+		// The clock stands still, so the remaining TTL is the whole TTL.
+		ttl, err := g.GetWithTTL(context.Background(), key, StringSink(&s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl < 80*time.Second || ttl > 120*time.Second {
+			t.Errorf("%s expires after %v; want within 20%% of 100s", key, ttl)
+		}
+		if i == 0 || ttl < minTTL {
+			minTTL = ttl
+		}
+		if ttl > maxTTL {
+			maxTTL = ttl
+		}
+		distinct[ttl] = true
+	}
+	if len(distinct) < 100 || maxTTL-minTTL < 30*time.Second {
+		t.Errorf("%d distinct expiries between %v and %v; want them spread over the 40s window", len(distinct), minTTL, maxTTL)
+	}
+}
+
+func TestRand(t *testing.T) {
+	clock := newFakeClock()
+	getter := GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value")
+	})
+	for _, tt := range []struct {
+		rand float64
+		want time.Duration
+	}{
+		{0, 80 * time.Second},
+		{0.5, 100 * time.Second},
+		{0.75, 110 * time.Second},
+	} {
+		name := fmt.Sprintf("randTest-%v", tt.rand)
+		r := tt.rand
+		g := NewGroupWithOptions(name, 1<<20, getter, &GroupOptions{
+			LocalOnly: true,
+			TTL:       100 * time.Second,
+			TTLJitter: 0.2,
+			Clock:     clock.Now,
+			Rand:      func() float64 { return r },
+		})
+		defer DeregisterGroup(name)
+		for i := 0; i < 3; i++ {
+			var s string
+			ttl, err := g.GetWithTTL(context.Background(), fmt.Sprintf("key-%d", i), StringSink(&s))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ttl != tt.want {
+				t.Errorf("with Rand %v, key-%d expires after %v; want %v", tt.rand, i, ttl, tt.want)
+			}
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	g := NewGroupWithOptions("rangeTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value:" + key)
+	}), &GroupOptions{LocalOnly: true})
+	defer DeregisterGroup("rangeTest")
+	for i := 0; i < 5; i++ {
+		if _, err := g.GetString(context.Background(), fmt.Sprintf("key-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before := g.mainCache.keys(0)
+	gets := g.CacheStats(MainCache).Gets
+	var visited []string
+	g.Range(func(key string, value ByteView) bool {
+		if value.String() != "value:"+key {
+			t.Errorf("Range passed %s with value %q", key, value)
+		}
+		visited = append(visited, key)
+		return true
+	})
+	if !reflect.DeepEqual(visited, before) {
+		t.Errorf("Range visited %q; want %q", visited, before)
+	}
+	if after := g.mainCache.keys(0); !reflect.DeepEqual(after, before) {
+		t.Errorf("keys were %q after Range; want %q", after, before)
+	}
+	if got := g.CacheStats(MainCache).Gets; got != gets {
+		t.Errorf("Range made %d cache gets", got-gets)
+	}
+	// Range accessed nothing, so the oldest key is still evicted first.
+	if victim, ok := g.mainCache.peekVictim(); !ok || victim != "key-0" {
+		t.Errorf("next victim is %q; want key-0", victim)
+	}
+
+	var n int
+	g.Range(func(key string, value ByteView) bool {
+		n++
+		return n < 2
+	})
+	if n != 2 {
+		t.Errorf("Range called fn %d times; want it to stop once fn returned false", n)
+	}
+}
+
+func TestAsGetter(t *testing.T) {
+	var loads int
+	back := NewGroupWithOptions("asGetterTest-back", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		loads++
+		if key == "missing" {
+			return &ErrNotFound{Msg: "not found"}
+		}
+		return dest.SetString("value:" + key)
+	}), &GroupOptions{LocalOnly: true})
+	defer DeregisterGroup("asGetterTest-back")
+	front := NewGroupWithOptions("asGetterTest-front", 1<<20, back.AsGetter(), &GroupOptions{LocalOnly: true})
+	defer DeregisterGroup("asGetterTest-front")
+
+	s, err := front.GetString(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "value:key" || loads != 1 {
+		t.Errorf("got %q after %d loads; want value:key loaded by the back group", s, loads)
+	}
+	if !back.Contains("key") || !front.Contains("key") {
+		t.Error("expected both groups to cache the key")
+	}
+
+	// A miss of the front group only is served from the back group's cache.
+	front.localRemove("key")
+	if s, err = front.GetString(context.Background(), "key"); err != nil || s != "value:key" {
+		t.Errorf("got %q, %v", s, err)
+	}
+	if loads != 1 {
+		t.Errorf("the back group loaded the key %d times; want once", loads)
+	}
+
+	if _, err := front.GetString(context.Background(), "missing"); !errors.Is(err, &ErrNotFound{}) {
+		t.Errorf("expected ErrNotFound through the front group; got %v", err)
+	}
+}
+
+func TestGetDerived(t *testing.T) {
+	var loads, derives int
+	g := NewGroupWithOptions("getDerivedTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		loads++
+		return dest.SetString("title=" + key + ";" + strings.Repeat("body", 1000))
+	}), &GroupOptions{
+		LocalOnly: true,
+		Derive: func(key string, value ByteView) []byte {
+			derives++
+			doc := value.String()
+			return []byte(doc[len("title="):strings.Index(doc, ";")])
+		},
+	})
+	defer DeregisterGroup("getDerivedTest")
+
+	for i := 0; i < 3; i++ {
+		title, err := g.GetDerived(context.Background(), "doc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if title.String() != "doc" {
+			t.Errorf("GetDerived #%d = %q; want doc", i, title)
+		}
+	}
+	if loads != 1 || derives != 1 {
+		t.Errorf("got %d loads and %d derives; want the cached index reused", loads, derives)
+	}
+
+	// The full value is still there for Get.
+	s, err := g.GetString(context.Background(), "doc")
+	if err != nil || len(s) != len("title=doc;")+4000 {
+		t.Errorf("Get = %d bytes, %v; want the whole document", len(s), err)
+	}
+
+	plain := NewGroupWithOptions("getDerivedTest-plain", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value")
+	}), &GroupOptions{LocalOnly: true})
+	defer DeregisterGroup("getDerivedTest-plain")
+	if _, err := plain.GetDerived(context.Background(), "doc"); err == nil {
+		t.Error("expected GetDerived to fail without Derive")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	errCorrupt := errors.New("corrupt value")
+	var loads, rejected int
+	g := NewGroupWithOptions("validateTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		loads++
+		if key == "bad" {
+			return dest.SetString("garbage")
+		}
+		return dest.SetString("ok:" + key)
+	}), &GroupOptions{
+		LocalOnly: true,
+		Validate: func(key string, value []byte) error {
+			if !strings.HasPrefix(string(value), "ok:") {
+				rejected++
+				return errCorrupt
+			}
+			return nil
+		},
+	})
+	defer DeregisterGroup("validateTest")
+
+	var s string
+	if err := g.Get(context.Background(), "bad", StringSink(&s)); !errors.Is(err, errCorrupt) {
+		t.Fatalf("Get(bad) = %v; want the validation error", err)
+	}
+	if s != "" {
+		t.Errorf("Get(bad) set %q; want nothing", s)
+	}
+	if rejected != 1 {
+		t.Errorf("Validate rejected %d values; want 1", rejected)
+	}
+	if _, ok := g.mainCache.get("bad"); ok {
+		t.Error("the invalid value was cached")
+	}
+
+	// The rejected value is loaded again rather than served from cache.
+	if err := g.Get(context.Background(), "bad", StringSink(&s)); !errors.Is(err, errCorrupt) {
+		t.Fatalf("second Get(bad) = %v; want the validation error", err)
+	}
+	if loads != 2 {
+		t.Errorf("loaded %d times; want 2", loads)
+	}
+
+	if err := g.Get(context.Background(), "good", StringSink(&s)); err != nil || s != "ok:good" {
+		t.Errorf("Get(good) = %q, %v; want ok:good", s, err)
+	}
+}
+
+func TestKeyFingerprint(t *testing.T) {
+	var loads int
+	getter := GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		loads++
+		return dest.SetString("value")
+	})
+	g := newGroupOpts("keyFingerprintTest", 1<<20, getter, NoPeers{}, &GroupOptions{KeyFingerprint: FingerprintKey})
+	plain := newGroupOpts("keyFingerprintTest-plain", 1<<20, getter, NoPeers{}, &GroupOptions{})
+
+	long := strings.Repeat("k", 1000)
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("%s-%d", long, i)
+		for _, g := range []*Group{g, plain} {
+			if s, err := g.GetString(context.Background(), key); err != nil || s != "value" {
+				t.Fatalf("Get = %q, %v", s, err)
+			}
+		}
+	}
+	if loads != 20 {
+		t.Fatalf("loaded %d times; want 20", loads)
+	}
+
+	// Lookups find the entries stored under the fingerprints.
+	for i := 0; i < 10; i++ {
+		if s, err := g.GetString(context.Background(), fmt.Sprintf("%s-%d", long, i)); err != nil || s != "value" {
+			t.Fatalf("cached Get = %q, %v", s, err)
+		}
+	}
+	if loads != 20 {
+		t.Errorf("loaded %d times; want the fingerprinted entries to be hit", loads)
+	}
+	if !g.Contains(long+"-0") || g.Contains(long+"-10") {
+		t.Error("Contains does not match the cached keys")
+	}
+
+	// Each entry costs a 16 byte key instead of the full one.
+	if got, want := g.mainCache.bytes(), int64(10*(16+len("value"))); got != want {
+		t.Errorf("fingerprinted cache holds %d bytes; want %d", got, want)
+	}
+	if got, want := plain.mainCache.bytes(), int64(10*(len(long)+2+len("value"))); got != want {
+		t.Errorf("plain cache holds %d bytes; want %d", got, want)
+	}
+
+	if err := g.Remove(context.Background(), long+"-0"); err != nil {
+		t.Fatal(err)
+	}
+	if g.Contains(long + "-0") {
+		t.Error("Remove left the fingerprinted entry")
+	}
+}
+
+func TestKeyFingerprintPinsAndTenants(t *testing.T) {
+	var tenantKeys []string
+	g := newGroupOpts("keyFingerprintPinsTest", 2000, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString(strings.Repeat("x", 90))
+	}), NoPeers{}, &GroupOptions{
+		KeyFingerprint: FingerprintKey,
+		Tenant: func(key string) string {
+			tenantKeys = append(tenantKeys, key)
+			return strings.SplitN(key, "/", 2)[0]
+		},
+		DefaultTenantBytes: 1000,
+	})
+	defer DeregisterGroup(g.Name())
+
+	g.Pin("a/pinned")
+	for _, key := range []string{"a/pinned", "a/0", "a/1", "a/2"} {
+		if _, err := g.GetString(context.Background(), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := g.GetString(context.Background(), fmt.Sprintf("a/%03d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !g.Contains("a/pinned") {
+		t.Error("pinned key was evicted by its tenant")
+	}
+	g.Unpin("a/pinned")
+	if g.pins.has(FingerprintKey("a/pinned")) {
+		t.Error("Unpin left the fingerprint pinned")
+	}
+	for _, key := range tenantKeys {
+		if !strings.HasPrefix(key, "a/") {
+			t.Fatalf("Tenant called with %q; want the full key", key)
+		}
+	}
+	if g.mainCache.tenantBytes["a"] > 1000 {
+		t.Errorf("tenant holds %d bytes; want at most its 1000 byte budget", g.mainCache.tenantBytes["a"])
+	}
+
+	if err := g.RemoveByPrefix(context.Background(), "a/"); err == nil {
+		t.Error("RemoveByPrefix of fingerprinted keys succeeded")
+	}
+	if _, err := g.WarmFromPeers(context.Background(), 0, nil); err == nil {
+		t.Error("WarmFromPeers of fingerprinted keys succeeded")
+	}
+}
+
+func TestNegativeTTL(t *testing.T) {
+	clock := newFakeClock()
+	loads := map[string]int{}
+	g := NewGroupWithOptions("negativeTTLTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		loads[key]++
+		if key == "missing" {
+			return &ErrNotFound{Msg: "missing not found"}
+		}
+		return dest.SetString("value")
+	}), &GroupOptions{LocalOnly: true, Clock: clock.Now, TTL: time.Hour, NegativeTTL: time.Minute})
+	defer DeregisterGroup("negativeTTLTest")
+
+	get := func(key string) error {
+		return g.Get(context.Background(), key, StringSink(new(string)))
+	}
+	for i := 0; i < 4; i++ {
+		if err := get("missing"); !errors.Is(err, &ErrNotFound{}) || err.Error() != "missing not found" {
+			t.Fatalf("Get(missing) = %v; want the ErrNotFound of the Getter", err)
+		}
+		if err := get("present"); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(25 * time.Second)
+	}
+	// By the Gets 75s in, the tombstone expired after its minute despite
+	// the hits, while the value lives on for an hour.
+	if loads["missing"] != 2 || loads["present"] != 1 {
+		t.Errorf("got loads %v; want missing loaded again after its minute", loads)
+	}
+
+	clock.Advance(time.Hour)
+	if err := get("present"); err != nil {
+		t.Fatal(err)
+	}
+	if loads["present"] != 2 {
+		t.Errorf("present was loaded %d times; want it loaded again after its hour", loads["present"])
+	}
+
+	// Setting the key forgets it was missing.
+	get("missing")
+	n := loads["missing"]
+	if err := g.Set(context.Background(), "missing", []byte("found"), false); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := g.Get(context.Background(), "missing", StringSink(&s)); err != nil || s != "found" {
+		t.Errorf("Get after Set = %q, %v; want found", s, err)
+	}
+	if loads["missing"] != n {
+		t.Error("Get after Set loaded the key")
+	}
+}
+
+func TestGetInto(t *testing.T) {
+	g := newGroupOpts("getIntoTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value:" + key)
+	}), NoPeers{}, &GroupOptions{})
+
+	buf := make([]byte, 64)
+	n, err := g.GetInto(context.Background(), "key", buf)
+	if err != nil || string(buf[:n]) != "value:key" {
+		t.Errorf("GetInto = %q, %v; want value:key", buf[:n], err)
+	}
+
+	// A short buffer is left alone and the needed length returned.
+	short := []byte("xxx")
+	n, err = g.GetInto(context.Background(), "key", short)
+	if !errors.Is(err, io.ErrShortBuffer) || n != len("value:key") {
+		t.Errorf("GetInto with a short buffer = %d, %v; want %d, io.ErrShortBuffer", n, err, len("value:key"))
+	}
+	if string(short) != "xxx" {
+		t.Errorf("GetInto wrote %q into a short buffer", short)
+	}
+	n, err = g.GetInto(context.Background(), "key", make([]byte, n))
+	if err != nil || n != len("value:key") {
+		t.Errorf("GetInto with an exact buffer = %d, %v", n, err)
+	}
+
+	// Cache hits do not allocate.
+	if allocs := testing.AllocsPerRun(100, func() {
+		g.GetInto(context.Background(), "key", buf)
+	}); allocs != 0 {
+		t.Errorf("GetInto of a cached key made %v allocations; want 0", allocs)
+	}
+}
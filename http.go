@@ -17,26 +17,44 @@ limitations under the License.
 package groupcache
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/xdbbe/groupcache/v2/consistenthash"
 	pb "github.com/xdbbe/groupcache/v2/groupcachepb"
+	"github.com/zeebo/xxh3"
 )
 
 const defaultBasePath = "/_groupcache/"
 
 const defaultReplicas = 50
 
+const defaultPeerProviderInterval = 5 * time.Second
+
+const defaultReadyInterval = time.Second
+
+const defaultGzipMinBytes = 1024
+
+// maxMultiRequestBytes limits the body of a multi-get request the server
+// reads.
+const maxMultiRequestBytes = 8 << 20
+
 // HTTPPool implements PeerPicker for a pool of HTTP peers.
 type HTTPPool struct {
 	// this peer's base URL, e.g. "https://example.net:8000"
@@ -46,10 +64,41 @@ type HTTPPool struct {
 	opts HTTPPoolOptions
 
 	mu          sync.Mutex // guards peers and httpGetters
-	peers       *consistenthash.Map
+	peers       Ring
 	httpGetters map[string]*httpGetter // keyed by e.g. "http://10.0.0.2:8008"
+	picks       map[string]int64       // PickPeer results, by peer
+
+	// readyMu guards ready, the result of the last readiness probe at
+	// readyAt.
+	readyMu sync.Mutex
+	ready   bool
+	readyAt time.Time
+
+	// closed is closed by Close, and done once watchPeers returned.
+	closed    chan struct{}
+	closeOnce sync.Once
+	done      sync.WaitGroup
 }
 
+// Ring is the consistent hash ring an HTTPPool places keys on. It is
+// satisfied by *consistenthash.Map. Rings which also implement Len or
+// Points like *consistenthash.Map support RingSize and RingPoints
+// respectively.
+type Ring interface {
+	Add(nodes ...string)
+	Remove(nodes ...string)
+	// Get returns the node which owns key. It is only called on rings
+	// which are not empty.
+	Get(key string) string
+	// GetHashed returns the node which owns the key whose hash is
+	// keyHash, for PickPeerHashed. It is only called on rings which are
+	// not empty.
+	GetHashed(keyHash uint64) string
+	IsEmpty() bool
+}
+
+var _ Ring = &consistenthash.Map{}
+
 // HTTPPoolOptions are the configurations of a HTTPPool.
 type HTTPPoolOptions struct {
 	// BasePath specifies the HTTP path that will serve groupcache requests.
@@ -61,18 +110,116 @@ type HTTPPoolOptions struct {
 	Replicas int
 
 	// HashFn specifies the hash function of the consistent hash.
-	// If blank, it defaults to crc32.ChecksumIEEE.
+	// If blank, it defaults to xxh3.Hash.
 	HashFn consistenthash.Hash
 
+	// RingFactory, if non-nil, returns a new empty ring for the pool to
+	// place its peers on, such as a bounded-load or weighted ring, in
+	// place of consistenthash.New(Replicas, HashFn).
+	RingFactory func() Ring
+
 	// Transport optionally specifies an http.RoundTripper for the client
 	// to use when it makes a request.
 	// If nil, the client uses http.DefaultTransport.
 	Transport func(context.Context) http.RoundTripper
 
+	// PeerProvider optionally specifies a function the pool calls to
+	// discover the current list of peers. Each peer value should be a
+	// valid base URL, for example "http://example.net:8000". The pool
+	// reconciles its membership by adding and removing only the peers
+	// that changed, so keys owned by unchanged peers keep their owner.
+	// If nil, peers are only updated by calling Set.
+	PeerProvider func() []string
+
+	// PeerProviderInterval specifies how often PeerProvider is called.
+	// If blank, it defaults to 5 seconds.
+	PeerProviderInterval time.Duration
+
 	// Context optionally specifies a context for the server to use when it
-	// receives a request.
+	// receives a request. The returned context is always canceled when
+	// the http.Request.Context() is done.
 	// If nil, uses the http.Request.Context()
 	Context func(*http.Request) context.Context
+
+	// MaxResponseBytes, if positive, limits how many bytes the client
+	// reads from the body of a peer response. Larger responses fail
+	// with ErrResponseTooLarge instead of being read into memory.
+	MaxResponseBytes int64
+
+	// Authorize, if non-nil, is called with every request the server
+	// receives before it is served. If it returns an error the request
+	// is refused with 403 Forbidden. It can check a shared token or the
+	// TLS identity of the calling peer, for example.
+	Authorize func(*http.Request) error
+
+	// Redirect makes the server answer a GET for an uncached key which
+	// another peer owns, according to this pool's ring, with the URL of
+	// that peer instead of loading the key. This stops peers from
+	// loading keys they no longer own while rings disagree during
+	// membership changes. Clients follow a single redirect.
+	Redirect bool
+
+	// Self, if set, is the base URL other peers reach this process at,
+	// and is used instead of the self argument of NewHTTPPoolOpts to
+	// find this process among the peers. Set it when the address the
+	// server binds, such as in a container, differs from the one it is
+	// advertised at.
+	Self string
+
+	// BatchWindow, if positive, makes the client hold the plain Gets
+	// for a peer for up to BatchWindow, and fetch the keys requested in
+	// the meantime with one multi-get request per group instead of one
+	// request per key. It trades that much latency for fewer requests
+	// when many distinct keys of a peer are loaded at once. A multi-get
+	// gives up once the last deadline of the Gets it answers passes. It
+	// has no effect with Redirect, since multi-gets aren't redirected.
+	BatchWindow time.Duration
+
+	// Checksum makes the client ask peers for an xxh3 checksum of each
+	// value they return, and fail fetches whose value does not match it
+	// with ErrChecksum instead of caching a value corrupted in transit.
+	// Servers answer the request whether or not they set Checksum.
+	Checksum bool
+
+	// IsSelf, if non-nil, reports whether a peer URL refers to this
+	// process, replacing the comparison with the self URL. Use it when
+	// the process is known by several URLs.
+	IsSelf func(peerURL string) bool
+
+	// Gossip makes the server list the pool's peers on a GET of
+	// BasePath, and the pool ask each of its peers for their list every
+	// GossipInterval and add the peers it did not know of. A peer known
+	// to any member of a pool thus spreads to all of them without a
+	// central coordinator. Gossip only adds peers, so the pools converge
+	// on the union of their lists: it suits pools which grow, or whose
+	// members restart with a new list, rather than ones which remove
+	// peers with Set or PeerProvider.
+	Gossip bool
+
+	// GossipInterval specifies how often the pool gossips.
+	// If blank, it defaults to 5 seconds.
+	GossipInterval time.Duration
+
+	// MaxGossipPeers bounds how many peers the pool grows to by gossip.
+	// Past it, the lexically smallest of the learned peers are added.
+	// If blank, it defaults to 1024.
+	MaxGossipPeers int
+
+	// ReadyInterval specifies how long Ready reuses the result of its
+	// probes, which also time out after it.
+	// If blank, it defaults to 1 second.
+	ReadyInterval time.Duration
+
+	// Gzip makes the client ask peers for gzip compressed responses, and
+	// the server compress the responses of at least GzipMinBytes for
+	// clients which ask for it. Smaller responses are sent as they are,
+	// since compressing them costs more than it saves. Compression pays
+	// off for large, compressible values and slow networks.
+	Gzip bool
+
+	// GzipMinBytes specifies the smallest response the server compresses.
+	// If blank, it defaults to 1024.
+	GzipMinBytes int
 }
 
 // NewHTTPPool initializes an HTTP pool of peers, and registers itself as a PeerPicker.
@@ -96,74 +243,463 @@ func NewHTTPPoolOpts(self string, o *HTTPPoolOptions) *HTTPPool {
 	}
 	httpPoolMade = true
 
+	p := newHTTPPoolOpts(self, o)
+	RegisterPeerPicker(func() PeerPicker { return p })
+	return p
+}
+
+// newHTTPPoolOpts creates an HTTPPool without registering it as the
+// process wide PeerPicker.
+func newHTTPPoolOpts(self string, o *HTTPPoolOptions) *HTTPPool {
 	p := &HTTPPool{
 		self:        self,
 		httpGetters: make(map[string]*httpGetter),
+		picks:       make(map[string]int64),
+		closed:      make(chan struct{}),
 	}
 	if o != nil {
 		p.opts = *o
 	}
+	if p.opts.Self != "" {
+		p.self = p.opts.Self
+	}
 	if p.opts.BasePath == "" {
 		p.opts.BasePath = defaultBasePath
 	}
 	if p.opts.Replicas == 0 {
 		p.opts.Replicas = defaultReplicas
 	}
-	p.peers = consistenthash.New(p.opts.Replicas, p.opts.HashFn)
+	if p.opts.PeerProviderInterval == 0 {
+		p.opts.PeerProviderInterval = defaultPeerProviderInterval
+	}
+	if p.opts.GossipInterval == 0 {
+		p.opts.GossipInterval = defaultPeerProviderInterval
+	}
+	if p.opts.MaxGossipPeers == 0 {
+		p.opts.MaxGossipPeers = defaultMaxGossipPeers
+	}
+	if p.opts.ReadyInterval == 0 {
+		p.opts.ReadyInterval = defaultReadyInterval
+	}
+	if p.opts.GzipMinBytes == 0 {
+		p.opts.GzipMinBytes = defaultGzipMinBytes
+	}
+	p.peers = p.newRing()
 
-	RegisterPeerPicker(func() PeerPicker { return p })
+	if p.opts.PeerProvider != nil {
+		p.updatePeers(p.opts.PeerProvider())
+		p.done.Add(1)
+		go p.watchPeers()
+	}
+	if p.opts.Gossip {
+		p.done.Add(1)
+		go p.watchGossip()
+	}
 	return p
 }
 
+// watchPeers periodically reconciles the pool with the PeerProvider
+// until the pool is closed.
+func (p *HTTPPool) watchPeers() {
+	defer p.done.Done()
+	t := time.NewTicker(p.opts.PeerProviderInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			p.updatePeers(p.opts.PeerProvider())
+		case <-p.closed:
+			return
+		}
+	}
+}
+
+// Close stops calling the PeerProvider and gossiping, and waits for the pool's
+// background work to finish. Gets held for a BatchWindow are sent right
+// away. The server refuses requests received
+// after Close with 503 Service Unavailable. Close always returns nil.
+func (p *HTTPPool) Close() error {
+	p.closeOnce.Do(func() { close(p.closed) })
+	p.done.Wait()
+	p.mu.Lock()
+	var batchers []*batcher
+	for _, h := range p.httpGetters {
+		if h.batch != nil {
+			batchers = append(batchers, h.batch)
+		}
+	}
+	p.mu.Unlock()
+	for _, b := range batchers {
+		b.close()
+	}
+	return nil
+}
+
+// Ready reports whether the pool reaches a quorum, more than half, of
+// its peers, counting this process as reachable, so that a process cut
+// off from the others can be kept out of rotation by a readiness check.
+// A pool without peers is ready, and a closed one never is. Each other
+// peer is probed with a request to its server, in parallel, and the
+// result is reused for ReadyInterval.
+func (p *HTTPPool) Ready() bool {
+	select {
+	case <-p.closed:
+		return false
+	default:
+	}
+	p.readyMu.Lock()
+	defer p.readyMu.Unlock()
+	if !p.readyAt.IsZero() && time.Since(p.readyAt) < p.opts.ReadyInterval {
+		return p.ready
+	}
+	p.ready = p.probePeers()
+	p.readyAt = time.Now()
+	return p.ready
+}
+
+// probePeers probes the other peers and reports whether a quorum of the
+// peers is reachable.
+func (p *HTTPPool) probePeers() bool {
+	p.mu.Lock()
+	total := len(p.httpGetters)
+	var reachable int32
+	var others []*httpGetter
+	for peer, h := range p.httpGetters {
+		if p.isSelf(peer) {
+			reachable++
+		} else {
+			others = append(others, h)
+		}
+	}
+	p.mu.Unlock()
+	if total == 0 {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.opts.ReadyInterval)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, h := range others {
+		wg.Add(1)
+		go func(h *httpGetter) {
+			defer wg.Done()
+			if h.probe(ctx) == nil {
+				atomic.AddInt32(&reachable, 1)
+			}
+		}(h)
+	}
+	wg.Wait()
+	return int(reachable)*2 > total
+}
+
+// updatePeers adds and removes peers so the pool matches the provided
+// list, leaving the ring placement of unchanged peers intact.
+func (p *HTTPPool) updatePeers(peers []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	want := make(map[string]bool, len(peers))
+	var added, removed []string
+	for _, peer := range peers {
+		if want[peer] {
+			continue
+		}
+		want[peer] = true
+		if _, ok := p.httpGetters[peer]; !ok {
+			added = append(added, peer)
+		}
+	}
+	for peer := range p.httpGetters {
+		if !want[peer] {
+			removed = append(removed, peer)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	p.warnIfSelfMissing(peers)
+
+	p.peers.Remove(removed...)
+	p.peers.Add(added...)
+	for _, peer := range removed {
+		delete(p.httpGetters, peer)
+	}
+	for _, peer := range added {
+		p.httpGetters[peer] = p.newHTTPGetter(peer)
+	}
+}
+
 // Set updates the pool's list of peers.
 // Each peer value should be a valid base URL,
 // for example "http://example.net:8000".
+// A warning is logged if the peers do not include the pool's own URL.
 func (p *HTTPPool) Set(peers ...string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.peers = consistenthash.New(p.opts.Replicas, p.opts.HashFn)
+	p.peers = p.newRing()
 	p.peers.Add(peers...)
+	p.warnIfSelfMissing(peers)
 	p.httpGetters = make(map[string]*httpGetter, len(peers))
 	for _, peer := range peers {
-		p.httpGetters[peer] = &httpGetter{
-			getTransport: p.opts.Transport,
-			baseURL:      peer + p.opts.BasePath,
+		p.httpGetters[peer] = p.newHTTPGetter(peer)
+	}
+}
+
+func (p *HTTPPool) newRing() Ring {
+	if p.opts.RingFactory != nil {
+		return p.opts.RingFactory()
+	}
+	return consistenthash.New(p.opts.Replicas, p.opts.HashFn)
+}
+
+// HasSelf reports whether the pool's own base URL is one of its peers.
+// If it isn't, every key is owned by another peer, so this process
+// never loads a key itself.
+func (p *HTTPPool) HasSelf() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for peer := range p.httpGetters {
+		if p.isSelf(peer) {
+			return true
 		}
 	}
+	return false
 }
 
-// GetAll returns all the peers in the pool
+// isSelf reports whether peer is this process.
+func (p *HTTPPool) isSelf(peer string) bool {
+	if p.opts.IsSelf != nil {
+		return p.opts.IsSelf(peer)
+	}
+	return peer == p.self
+}
+
+// warnIfSelfMissing logs a warning if peers is not empty and does not
+// include self, which is almost always a misconfiguration.
+func (p *HTTPPool) warnIfSelfMissing(peers []string) {
+	if len(peers) == 0 || logger == nil {
+		return
+	}
+	for _, peer := range peers {
+		if p.isSelf(peer) {
+			return
+		}
+	}
+	logger.Warn().
+		WithFields(map[string]interface{}{
+			"self":     p.self,
+			"category": "groupcache",
+		}).Printf("peers do not include self '%s'; no keys will be loaded locally", p.self)
+}
+
+// GetAll returns all the peers in the pool except this process, so that
+// requests to every peer, such as the removals of Remove, are not sent
+// to this process over HTTP.
 func (p *HTTPPool) GetAll() []ProtoGetter {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	var i int
-	res := make([]ProtoGetter, len(p.httpGetters))
-	for _, v := range p.httpGetters {
-		res[i] = v
-		i++
+	res := make([]ProtoGetter, 0, len(p.httpGetters))
+	for peer, v := range p.httpGetters {
+		if !p.isSelf(peer) {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// Peers returns the sorted base URLs of the peers currently in the pool.
+func (p *HTTPPool) Peers() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	res := make([]string, 0, len(p.httpGetters))
+	for peer := range p.httpGetters {
+		res = append(res, peer)
 	}
+	sort.Strings(res)
 	return res
 }
 
+// RingSize returns the number of replica points on the pool's
+// consistent hash ring, or zero if its custom Ring has no Len method.
+func (p *HTTPPool) RingSize() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if r, ok := p.peers.(interface{ Len() int }); ok {
+		return r.Len()
+	}
+	return 0
+}
+
+// RingPoints returns the replica points of the pool's ring, for
+// debugging how keys are spread over the peers. It returns nil if the
+// pool's custom Ring has no Points method.
+func (p *HTTPPool) RingPoints() []consistenthash.Point {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ringPointsLocked()
+}
+
+func (p *HTTPPool) ringPointsLocked() []consistenthash.Point {
+	if r, ok := p.peers.(interface{ Points() []consistenthash.Point }); ok {
+		return r.Points()
+	}
+	return nil
+}
+
+// RingFingerprint returns a hash of the pool's ring. Pools which place
+// every key on the same peer have the same fingerprint, so comparing
+// the fingerprints of the peers detects whether their views of the
+// membership diverged. It covers the replica points themselves, so it
+// also differs between pools with differing Replicas or HashFn. For a
+// custom Ring without a Points method it covers the peers only.
+func (p *HTTPPool) RingFingerprint() string {
+	p.mu.Lock()
+	points := p.ringPointsLocked()
+	if points == nil {
+		for peer := range p.httpGetters {
+			points = append(points, consistenthash.Point{Node: peer})
+		}
+		sort.Slice(points, func(i, j int) bool { return points[i].Node < points[j].Node })
+	}
+	p.mu.Unlock()
+
+	h := xxh3.New()
+	var b [8]byte
+	for _, point := range points {
+		binary.BigEndian.PutUint64(b[:], point.Hash)
+		h.Write(b[:])
+		h.WriteString(point.Node)
+		h.Write([]byte{0})
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
 func (p *HTTPPool) PickPeer(key string) (ProtoGetter, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	if p.peers.IsEmpty() {
 		return nil, false
 	}
-	if peer := p.peers.Get(key); peer != p.self {
+	peer := p.peers.Get(key)
+	p.picks[peer]++
+	if !p.isSelf(peer) {
+		return p.httpGetters[peer], true
+	}
+	return nil, false
+}
+
+// owner is like PickPeer but doesn't count the pick, for lookups which
+// aren't serving a Get of this process, such as ServeHTTP deciding
+// whether to redirect.
+func (p *HTTPPool) owner(key string) (*httpGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers.IsEmpty() {
+		return nil, false
+	}
+	if peer := p.peers.Get(key); !p.isSelf(peer) {
+		return p.httpGetters[peer], true
+	}
+	return nil, false
+}
+
+// PickPeers implements ReplicaPicker. Rings without a GetN method like
+// *consistenthash.Map's only give the owner of the key.
+func (p *HTTPPool) PickPeers(key string, n int) []ProtoGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers.IsEmpty() || n <= 0 {
+		return nil
+	}
+	peers := []string{p.peers.Get(key)}
+	if r, ok := p.peers.(interface{ GetN(string, int) []string }); ok {
+		// One more, in case this process is among them.
+		peers = r.GetN(key, n+1)
+	}
+	p.picks[peers[0]]++
+	if p.isSelf(peers[0]) {
+		return nil
+	}
+	var res []ProtoGetter
+	for _, peer := range peers {
+		if len(res) < n && !p.isSelf(peer) {
+			res = append(res, p.httpGetters[peer])
+		}
+	}
+	return res
+}
+
+// PickPeerHashed is like PickPeer for a key whose hash is keyHash, as
+// computed by HTTPPoolOptions.HashFn or, by default, xxh3.Hash. It saves
+// hashing keys again whose hash the caller already computed. A custom
+// Ring is asked with its GetHashed method.
+func (p *HTTPPool) PickPeerHashed(keyHash uint64) (ProtoGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers.IsEmpty() {
+		return nil, false
+	}
+	peer := p.peers.GetHashed(keyHash)
+	p.picks[peer]++
+	if !p.isSelf(peer) {
 		return p.httpGetters[peer], true
 	}
 	return nil, false
 }
 
+// PickCounts returns how many times PickPeer and PickPeerHashed picked
+// each peer since the pool was created, including this process, to
+// monitor how real traffic spreads over the peers. Peers which were
+// removed keep their counts.
+func (p *HTTPPool) PickCounts() map[string]int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := make(map[string]int64, len(p.picks))
+	for peer, n := range p.picks {
+		res[peer] = n
+	}
+	return res
+}
+
+// ServeHTTP serves groupcache requests from peers.
+//
+// A GET for a key which is present in the hot cache is answered from the
+// hot cache, even though this peer does not own the key. This offloads
+// the owner while peers disagree about ring membership, at the price of
+// possibly serving a value which has since been Set on the owner. Hot
+// cache copies are dropped by Remove, which is broadcast to every peer.
 func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Parse request.
 	if !strings.HasPrefix(r.URL.Path, p.opts.BasePath) {
 		panic("HTTPPool serving unexpected path: " + r.URL.Path)
 	}
+	select {
+	case <-p.closed:
+		http.Error(w, "groupcache: pool is closed", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+	if p.opts.Authorize != nil {
+		if err := p.opts.Authorize(r); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+	if p.opts.Gossip && r.URL.Path == p.opts.BasePath && r.Method == http.MethodGet {
+		p.servePeers(w, r)
+		return
+	}
 	parts := strings.SplitN(r.URL.Path[len(p.opts.BasePath):], "/", 2)
+	if len(parts) == 1 && r.Method == http.MethodGet {
+		p.serveKeys(w, r, parts[0])
+		return
+	}
+	if len(parts) == 1 && r.Method == http.MethodPost {
+		p.serveMulti(w, r, parts[0])
+		return
+	}
 	if len(parts) != 2 {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
@@ -177,18 +713,29 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
 		return
 	}
-	var ctx context.Context
-	if p.opts.Context != nil {
-		ctx = p.opts.Context(r)
-	} else {
-		ctx = r.Context()
-	}
+	ctx, cancel := p.requestContext(r)
+	defer cancel()
 
 	group.Stats.ServerRequests.Add(1)
 
 	// Delete the key and return 200
 	if r.Method == http.MethodDelete {
+		if r.URL.Query().Get("prefix") != "" {
+			if err := group.fingerprintErr("RemoveByPrefix"); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			group.localRemovePrefix(key)
+			return
+		}
+		if r.URL.Query().Get("tag") != "" {
+			group.localInvalidateTag(key)
+			return
+		}
 		group.localRemove(key)
+		if err := group.pushInvalidations(ctx, key); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
 
@@ -211,14 +758,46 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		group.localSet(*out.Key, out.Value, &group.mainCache)
+		if !group.localSetVersion(out.GetKey(), out.Value, out.GetVersion(), &group.mainCache) {
+			http.Error(w, staleVersion(out.GetKey(), out.GetVersion()).Error(), http.StatusConflict)
+			return
+		}
+		if err := group.pushInvalidations(ctx, out.GetKey()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// The setter may keep a hot copy.
+		group.recordFetcher(out.GetKey(), r.URL.Query().Get("from"))
 		return
 	}
 
-	var b []byte
+	if p.opts.Redirect && r.URL.Query().Get("redirected") == "" && !group.Contains(key) {
+		if owner, ok := p.owner(key); ok {
+			p.serveResponse(w, r, &pb.GetResponse{Redirect: proto.String(owner.GetURL())})
+			return
+		}
+	}
+
+	offset, length, err := parseRange(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if etag := r.URL.Query().Get("etag"); etag != "" && offset == 0 && length == 0 {
+		p.serveIfModified(ctx, w, r, group, key, etag)
+		return
+	}
+
+	var view ByteView
+	var info GetInfo
 
-	value := AllocatingByteSliceSink(&b)
-	err := group.Get(ctx, key, value)
+	value := ByteViewSink(&view)
+	if offset != 0 || length != 0 {
+		err = group.GetRange(ctx, key, offset, length, value)
+	} else {
+		info, err = group.GetWithInfo(ctx, key, value)
+	}
 	if err != nil {
 		if errors.Is(err, &ErrNotFound{}) {
 			http.Error(w, err.Error(), http.StatusNotFound)
@@ -227,26 +806,295 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
 	}
+	if info.Source == HotCacheAccess {
+		group.Stats.ServerHotCacheHits.Add(1)
+	}
+
+	group.recordFetcher(key, r.URL.Query().Get("from"))
 
 	// Write the value to the response body as a proto message.
-	body, err := proto.Marshal(&pb.GetResponse{Value: b})
+	res := &pb.GetResponse{Value: view.ByteSlice()}
+	if v := view.Version(); v != 0 {
+		res.Version = proto.Int64(v)
+	}
+	if info.CacheHit {
+		res.CacheHit = proto.Bool(true)
+	}
+	addChecksum(r, res)
+	p.serveResponse(w, r, res)
+}
+
+// addChecksum adds the checksum of its value to res if r asked for it.
+func addChecksum(r *http.Request, res *pb.GetResponse) {
+	if r.URL.Query().Get("checksum") != "" {
+		res.Checksum = proto.Uint64(xxh3.Hash(res.Value))
+	}
+}
+
+// verifyChecksum checks the value of out against its checksum.
+func verifyChecksum(peer string, out *pb.GetResponse) error {
+	if out.Checksum == nil {
+		return &ErrChecksum{Msg: fmt.Sprintf("groupcache: response from %s has no checksum", peer)}
+	}
+	if sum := xxh3.Hash(out.Value); sum != out.GetChecksum() {
+		return &ErrChecksum{Msg: fmt.Sprintf("groupcache: value from %s has checksum %x; want %x", peer, sum, out.GetChecksum())}
+	}
+	return nil
+}
+
+// serveResponse writes m to the response body.
+func (p *HTTPPool) serveResponse(w http.ResponseWriter, r *http.Request, m proto.Message) {
+	body, err := proto.Marshal(m)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/x-protobuf")
+	if p.opts.Gzip && len(body) >= p.opts.GzipMinBytes && acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzipWriterPool.Get().(*gzip.Writer)
+		zw.Reset(w)
+		defer gzipWriterPool.Put(zw)
+		zw.Write(body)
+		zw.Close()
+		return
+	}
 	w.Write(body)
 }
 
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(nil) },
+}
+
+// acceptsGzip reports whether the client of r accepts gzip compressed
+// responses.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.SplitN(enc, ";", 2)[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// serveIfModified answers a conditional get, leaving the value out of
+// the response when its ETag matches etag.
+func (p *HTTPPool) serveIfModified(ctx context.Context, w http.ResponseWriter, r *http.Request, group *Group, key, etag string) {
+	var value ByteView
+	info, err := group.GetWithInfo(ctx, key, ByteViewSink(&value))
+	if err != nil {
+		if errors.Is(err, &ErrNotFound{}) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if info.Source == HotCacheAccess {
+		group.Stats.ServerHotCacheHits.Add(1)
+	}
+	group.recordFetcher(key, r.URL.Query().Get("from"))
+
+	res := &pb.GetResponse{Etag: proto.String(group.etag(value))}
+	if res.GetEtag() == etag {
+		res.NotModified = proto.Bool(true)
+	} else {
+		res.Value = value.ByteSlice()
+		addChecksum(r, res)
+	}
+	p.serveResponse(w, r, res)
+}
+
+// requestContext returns the context to serve r with.
+func (p *HTTPPool) requestContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if p.opts.Context == nil {
+		return r.Context(), func() {}
+	}
+	return withRequestCancel(p.opts.Context(r), r)
+}
+
+// withRequestCancel returns a copy of ctx which is canceled when the
+// request's context is done, so a peer that drops the connection also
+// cancels any load performed on its behalf.
+func withRequestCancel(ctx context.Context, r *http.Request) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// serveKeys lists the keys in the main cache of a group.
+func (p *HTTPPool) serveKeys(w http.ResponseWriter, r *http.Request, groupName string) {
+	group := GetGroup(groupName)
+	if group == nil {
+		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+		return
+	}
+	var limit int64
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "invalid limit: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := group.fingerprintErr("listing keys"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	group.Stats.ServerRequests.Add(1)
+
+	p.serveResponse(w, r, &pb.KeysResponse{Keys: group.mainCache.keys(limit)})
+}
+
+// serveMulti answers a multi-get of the keys of a group with a framed
+// response, see framedContentType. Each record is flushed as soon as
+// its key is loaded, so the client can use the values as they arrive.
+func (p *HTTPPool) serveMulti(w http.ResponseWriter, r *http.Request, groupName string) {
+	if r.Header.Get("Accept") != framedContentType {
+		http.Error(w, "multi-get responses are only served as "+framedContentType, http.StatusNotAcceptable)
+		return
+	}
+	group := GetGroup(groupName)
+	if group == nil {
+		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+		return
+	}
+
+	defer r.Body.Close()
+	b := bufferPool.Get().(*bytes.Buffer)
+	b.Reset()
+	defer bufferPool.Put(b)
+	if _, err := io.Copy(b, http.MaxBytesReader(w, r.Body, maxMultiRequestBytes)); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var in pb.GetMultiRequest
+	if err := proto.Unmarshal(b.Bytes(), &in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := p.requestContext(r)
+	defer cancel()
+
+	group.Stats.ServerRequests.Add(1)
+
+	from := r.URL.Query().Get("from")
+	w.Header().Set("Content-Type", framedContentType)
+	bw := bufio.NewWriter(w)
+	flusher, _ := w.(http.Flusher)
+	for _, key := range in.GetKeys() {
+		if ctx.Err() != nil {
+			return
+		}
+		status, payload := frameValue, []byte(nil)
+		var view ByteView
+		info, err := group.GetWithInfo(ctx, key, ByteViewSink(&view))
+		if err == nil {
+			group.recordFetcher(key, from)
+			res := &pb.GetResponse{Value: view.ByteSlice()}
+			if v := view.Version(); v != 0 {
+				res.Version = proto.Int64(v)
+			}
+			if info.CacheHit {
+				res.CacheHit = proto.Bool(true)
+			}
+			addChecksum(r, res)
+			payload, err = proto.Marshal(res)
+		}
+		if err != nil {
+			status = frameError
+			if errors.Is(err, &ErrNotFound{}) {
+				status = frameNotFound
+			}
+			payload = []byte(err.Error())
+		}
+		if err := writeRecord(bw, key, status, payload); err != nil {
+			return
+		}
+		if err := bw.Flush(); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// parseRange returns the optional offset and length query parameters
+// of a ranged GET, which must not be negative.
+func parseRange(q url.Values) (offset, length int64, err error) {
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid offset: %w", err)
+		}
+		if offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset: %d is negative", offset)
+		}
+	}
+	if v := q.Get("length"); v != "" {
+		if length, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid length: %w", err)
+		}
+		if length < 0 {
+			return 0, 0, fmt.Errorf("invalid length: %d is negative", length)
+		}
+	}
+	return offset, length, nil
+}
+
 type httpGetter struct {
-	getTransport func(context.Context) http.RoundTripper
-	baseURL      string
+	getTransport     func(context.Context) http.RoundTripper
+	peer             string // as passed to HTTPPool.Set, e.g. "http://10.0.0.2:8008"
+	baseURL          string
+	maxResponseBytes int64  // of a response body; 0 means no limit
+	checksum         bool   // whether to verify the checksums of values
+	self             string // sent to the peer as the fetcher of values
+	gzip             bool   // whether to ask for compressed responses
+	batch            *batcher
+	pool             *HTTPPool // which created the getter, to follow redirects
+}
+
+func (p *HTTPPool) newHTTPGetter(peer string) *httpGetter {
+	h := &httpGetter{
+		getTransport:     p.opts.Transport,
+		peer:             peer,
+		baseURL:          peer + p.opts.BasePath,
+		maxResponseBytes: p.opts.MaxResponseBytes,
+		checksum:         p.opts.Checksum,
+		self:             p.self,
+		gzip:             p.opts.Gzip,
+		pool:             p,
+	}
+	if p.opts.BatchWindow > 0 && !p.opts.Redirect {
+		h.batch = &batcher{window: p.opts.BatchWindow, multi: h.GetMulti}
+	}
+	return h
 }
 
 func (p *httpGetter) GetURL() string {
 	return p.baseURL
 }
 
+// PeerID returns the URL of the peer, or its base URL if it is unknown.
+func (p *httpGetter) PeerID() string {
+	if p.peer == "" {
+		return p.baseURL
+	}
+	return p.peer
+}
+
 var bufferPool = sync.Pool{
 	New: func() interface{} { return new(bytes.Buffer) },
 }
@@ -256,21 +1104,36 @@ type request interface {
 	GetKey() string
 }
 
-func (h *httpGetter) makeRequest(ctx context.Context, m string, in request, b io.Reader, out *http.Response) error {
+func (h *httpGetter) makeRequest(ctx context.Context, m string, in request, q url.Values, b io.Reader, out *http.Response) error {
 	u := fmt.Sprintf(
 		"%v%v/%v",
 		h.baseURL,
 		url.PathEscape(in.GetGroup()),
 		url.PathEscape(in.GetKey()),
 	)
+	return h.do(ctx, m, u, q, b, out)
+}
+
+func (h *httpGetter) do(ctx context.Context, m string, u string, q url.Values, b io.Reader, out *http.Response) error {
+	if len(q) != 0 {
+		u += "?" + q.Encode()
+	}
 	req, err := http.NewRequestWithContext(ctx, m, u, b)
 	if err != nil {
 		return err
 	}
+	if h.gzip {
+		// Setting it ourselves stops http.Transport from decompressing
+		// the response, which readBody does.
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	return h.roundTrip(req, out)
+}
 
+func (h *httpGetter) roundTrip(req *http.Request, out *http.Response) error {
 	tr := http.DefaultTransport
 	if h.getTransport != nil {
-		tr = h.getTransport(ctx)
+		tr = h.getTransport(req.Context())
 	}
 
 	res, err := tr.RoundTrip(req)
@@ -282,8 +1145,49 @@ func (h *httpGetter) makeRequest(ctx context.Context, m string, in request, b io
 }
 
 func (h *httpGetter) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	if h.batch != nil && in.Offset == nil && in.Length == nil && in.GetEtag() == "" {
+		return h.batch.get(ctx, in.GetGroup(), in.GetKey(), out)
+	}
+	if err := h.get(ctx, in, nil, out); err != nil {
+		return err
+	}
+	if redirect := out.GetRedirect(); redirect != "" {
+		// Follow a single redirect, asking the owner not to redirect again.
+		owner := h.pool.newHTTPGetter(strings.TrimSuffix(redirect, h.pool.opts.BasePath))
+		out.Reset()
+		return owner.get(ctx, in, url.Values{"redirected": {"1"}}, out)
+	}
+	return nil
+}
+
+func (h *httpGetter) get(ctx context.Context, in *pb.GetRequest, q url.Values, out *pb.GetResponse) error {
+	if in.Offset != nil || in.Length != nil {
+		if q == nil {
+			q = url.Values{}
+		}
+		q.Set("offset", strconv.FormatInt(in.GetOffset(), 10))
+		q.Set("length", strconv.FormatInt(in.GetLength(), 10))
+	}
+	if in.GetEtag() != "" {
+		if q == nil {
+			q = url.Values{}
+		}
+		q.Set("etag", in.GetEtag())
+	}
+	if h.checksum {
+		if q == nil {
+			q = url.Values{}
+		}
+		q.Set("checksum", "1")
+	}
+	if h.self != "" {
+		if q == nil {
+			q = url.Values{}
+		}
+		q.Set("from", h.self)
+	}
 	var res http.Response
-	if err := h.makeRequest(ctx, http.MethodGet, in, nil, &res); err != nil {
+	if err := h.makeRequest(ctx, http.MethodGet, in, q, nil, &res); err != nil {
 		return err
 	}
 	defer res.Body.Close()
@@ -304,24 +1208,169 @@ func (h *httpGetter) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResp
 	b := bufferPool.Get().(*bytes.Buffer)
 	b.Reset()
 	defer bufferPool.Put(b)
-	_, err := io.Copy(b, res.Body)
+	if err := h.readBody(&res, b); err != nil {
+		return err
+	}
+	err := proto.Unmarshal(b.Bytes(), out)
 	if err != nil {
-		return fmt.Errorf("reading response body: %v", err)
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+	if h.checksum && out.GetRedirect() == "" && !out.GetNotModified() {
+		return verifyChecksum(h.PeerID(), out)
+	}
+	return nil
+}
+
+// readBody reads the body of res into b, failing with
+// ErrResponseTooLarge if it is longer than h.maxResponseBytes.
+func (h *httpGetter) readBody(res *http.Response, b *bytes.Buffer) error {
+	body := res.Body
+	if res.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(res.Body)
+		if err != nil {
+			return fmt.Errorf("reading response body: %v", err)
+		}
+		defer zr.Close()
+		// MaxResponseBytes limits the decompressed body.
+		body = zr
+	}
+	if h.maxResponseBytes <= 0 {
+		if _, err := io.Copy(b, body); err != nil {
+			return fmt.Errorf("reading response body: %v", err)
+		}
+		return nil
 	}
-	err = proto.Unmarshal(b.Bytes(), out)
+	tooLarge := &ErrResponseTooLarge{
+		Msg: fmt.Sprintf("groupcache: response from %s exceeds %d bytes", h.baseURL, h.maxResponseBytes),
+	}
+	if res.ContentLength > h.maxResponseBytes {
+		return tooLarge
+	}
+	n, err := io.Copy(b, io.LimitReader(body, h.maxResponseBytes+1))
 	if err != nil {
+		return fmt.Errorf("reading response body: %v", err)
+	}
+	if n > h.maxResponseBytes {
+		return tooLarge
+	}
+	return nil
+}
+
+// probe checks that the peer's server answers requests. Any answer but
+// 503 Service Unavailable, which closed pools answer with, will do.
+func (h *httpGetter) probe(ctx context.Context) error {
+	var res http.Response
+	if err := h.do(ctx, http.MethodGet, h.baseURL, nil, nil, &res); err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusServiceUnavailable {
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+	return nil
+}
+
+func (h *httpGetter) Keys(ctx context.Context, in *pb.KeysRequest, out *pb.KeysResponse) error {
+	q := url.Values{}
+	q.Set("limit", strconv.FormatInt(in.GetLimit(), 10))
+	var res http.Response
+	if err := h.do(ctx, http.MethodGet, h.baseURL+url.PathEscape(in.GetGroup()), q, nil, &res); err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024*1024))
+		return fmt.Errorf("server returned: %v, %v", res.Status, string(msg))
+	}
+	b := bufferPool.Get().(*bytes.Buffer)
+	b.Reset()
+	defer bufferPool.Put(b)
+	if err := h.readBody(&res, b); err != nil {
+		return err
+	}
+	if err := proto.Unmarshal(b.Bytes(), out); err != nil {
 		return fmt.Errorf("decoding response body: %v", err)
 	}
 	return nil
 }
 
+// GetMulti fetches the keys in one request, calling fn with each value
+// as it is read from the framed response.
+func (h *httpGetter) GetMulti(ctx context.Context, in *pb.GetMultiRequest, fn func(key string, out *pb.GetResponse, err error)) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("while marshaling GetMultiRequest body: %w", err)
+	}
+	u := h.baseURL + url.PathEscape(in.GetGroup())
+	q := url.Values{}
+	if h.checksum {
+		q.Set("checksum", "1")
+	}
+	if h.self != "" {
+		q.Set("from", h.self)
+	}
+	if len(q) != 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", framedContentType)
+	var res http.Response
+	if err := h.roundTrip(req, &res); err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024*1024))
+		return fmt.Errorf("server returned: %v, %v", res.Status, string(msg))
+	}
+	if ct := res.Header.Get("Content-Type"); ct != framedContentType {
+		return fmt.Errorf("groupcache: peer %s answered a multi-get with content type %q", h.baseURL, ct)
+	}
+
+	r := bufio.NewReader(res.Body)
+	for {
+		key, status, payload, err := readRecord(r, h.maxResponseBytes)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading multi-get response: %w", err)
+		}
+		switch status {
+		case frameValue:
+			var out pb.GetResponse
+			if err := proto.Unmarshal(payload, &out); err != nil {
+				return fmt.Errorf("decoding multi-get response: %v", err)
+			}
+			if h.checksum {
+				if err := verifyChecksum(h.PeerID(), &out); err != nil {
+					fn(key, nil, err)
+					continue
+				}
+			}
+			fn(key, &out, nil)
+		case frameNotFound:
+			fn(key, nil, &ErrNotFound{Msg: string(payload)})
+		default:
+			fn(key, nil, &ErrRemoteCall{Msg: string(payload)})
+		}
+	}
+}
+
 func (h *httpGetter) Set(ctx context.Context, in *pb.SetRequest) error {
 	body, err := proto.Marshal(in)
 	if err != nil {
 		return fmt.Errorf("while marshaling SetRequest body: %w", err)
 	}
+	var q url.Values
+	if h.self != "" {
+		q = url.Values{"from": {h.self}}
+	}
 	var res http.Response
-	if err := h.makeRequest(ctx, http.MethodPut, in, bytes.NewReader(body), &res); err != nil {
+	if err := h.makeRequest(ctx, http.MethodPut, in, q, bytes.NewReader(body), &res); err != nil {
 		return err
 	}
 	defer res.Body.Close()
@@ -331,14 +1380,29 @@ func (h *httpGetter) Set(ctx context.Context, in *pb.SetRequest) error {
 		if err != nil {
 			return fmt.Errorf("while reading body response: %v", res.Status)
 		}
+		if res.StatusCode == http.StatusConflict {
+			return &ErrStaleVersion{Msg: strings.Trim(string(body), "\n")}
+		}
 		return fmt.Errorf("server returned status %d: %s", res.StatusCode, body)
 	}
 	return nil
 }
 
 func (h *httpGetter) Remove(ctx context.Context, in *pb.GetRequest) error {
+	return h.remove(ctx, in, nil)
+}
+
+func (h *httpGetter) RemovePrefix(ctx context.Context, in *pb.GetRequest) error {
+	return h.remove(ctx, in, url.Values{"prefix": {"1"}})
+}
+
+func (h *httpGetter) InvalidateTag(ctx context.Context, in *pb.GetRequest) error {
+	return h.remove(ctx, in, url.Values{"tag": {"1"}})
+}
+
+func (h *httpGetter) remove(ctx context.Context, in *pb.GetRequest, q url.Values) error {
 	var res http.Response
-	if err := h.makeRequest(ctx, http.MethodDelete, in, nil, &res); err != nil {
+	if err := h.makeRequest(ctx, http.MethodDelete, in, q, nil, &res); err != nil {
 		return err
 	}
 	defer res.Body.Close()
@@ -17,19 +17,33 @@ limitations under the License.
 package groupcache
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
+	"path"
+	"reflect"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/xdbbe/groupcache/v2/consistenthash"
+	pb "github.com/xdbbe/groupcache/v2/groupcachepb"
+	"github.com/zeebo/xxh3"
 )
 
 var (
@@ -165,3 +179,1507 @@ func awaitAddrReady(t *testing.T, addr string, wg *sync.WaitGroup) {
 		time.Sleep(delay)
 	}
 }
+
+func TestHTTPPoolCancelsLoadWhenPeerDisconnects(t *testing.T) {
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	getter := GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		close(started)
+		select {
+		case <-ctx.Done():
+			close(canceled)
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			return dest.SetString("too late")
+		}
+	})
+	newGroup("httpPoolCancelTest", 1<<20, getter, NoPeers{})
+
+	// A Context that ignores the request must still be canceled when
+	// the requesting peer goes away.
+	p := newHTTPPoolOpts("http://self", &HTTPPoolOptions{
+		Context: func(*http.Request) context.Context { return context.Background() },
+	})
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		h := &httpGetter{baseURL: server.URL + defaultBasePath}
+		errc <- h.Get(ctx, &pb.GetRequest{
+			Group: proto.String("httpPoolCancelTest"),
+			Key:   proto.String("key"),
+		}, &pb.GetResponse{})
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the getter to start")
+	}
+	cancel()
+
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("getter context was not canceled after the client went away")
+	}
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected client to see context.Canceled; got %v", err)
+	}
+}
+
+func TestHTTPPoolPeerProvider(t *testing.T) {
+	var mu sync.Mutex
+	peers := []string{"http://a", "http://b", "http://c"}
+	p := newHTTPPoolOpts("http://self", &HTTPPoolOptions{
+		PeerProvider: func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return peers
+		},
+		PeerProviderInterval: 10 * time.Millisecond,
+	})
+
+	owners := func() map[string]string {
+		res := make(map[string]string)
+		for _, key := range testKeys(1000) {
+			peer, ok := p.PickPeer(key)
+			if !ok {
+				t.Fatalf("PickPeer(%q) found no peer", key)
+			}
+			res[key] = strings.TrimSuffix(peer.GetURL(), defaultBasePath)
+		}
+		return res
+	}
+	before := owners()
+
+	mu.Lock()
+	peers = []string{"http://a", "http://b", "http://d"}
+	mu.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p.mu.Lock()
+		_, ok := p.httpGetters["http://d"]
+		p.mu.Unlock()
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the pool to pick up the new peer")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	after := owners()
+	var moved int
+	for key, was := range before {
+		now := after[key]
+		if was == now {
+			continue
+		}
+		moved++
+		if was != "http://c" && now != "http://d" {
+			t.Errorf("key %q moved from %s to %s; only keys of changed peers should move", key, was, now)
+		}
+	}
+	if moved == 0 {
+		t.Error("expected some keys to move to the new peer")
+	}
+}
+
+func TestHTTPPoolPeers(t *testing.T) {
+	p := newHTTPPoolOpts("http://self", &HTTPPoolOptions{Replicas: 10})
+	if got := p.Peers(); len(got) != 0 {
+		t.Errorf("expected no peers before Set; got %v", got)
+	}
+
+	p.Set("http://c", "http://a", "http://b")
+	want := []string{"http://a", "http://b", "http://c"}
+	if got := p.Peers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Peers() = %v; want %v", got, want)
+	}
+	if got, want := p.RingSize(), 30; got != want {
+		t.Errorf("RingSize() = %d; want %d", got, want)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				p.Set("http://b", "http://a")
+				p.Peers()
+			}
+		}()
+	}
+	wg.Wait()
+	if got, want := p.Peers(), []string{"http://a", "http://b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Peers() = %v; want %v", got, want)
+	}
+}
+
+func TestHTTPPoolRangedGet(t *testing.T) {
+	value := strings.Repeat("0123456789", 100000)
+	getter := GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString(value)
+	})
+	owner := newGroup("httpPoolRangeTest", 1<<22, getter, NoPeers{})
+
+	p := newHTTPPoolOpts("http://self", nil)
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	// Prime the owner's cache with the whole value.
+	var s string
+	if err := owner.Get(context.Background(), "large", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+
+	var received int64
+	h := &httpGetter{
+		baseURL: server.URL + defaultBasePath,
+		getTransport: func(context.Context) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				res, err := http.DefaultTransport.RoundTrip(req)
+				if err == nil {
+					received += res.ContentLength
+				}
+				return res, err
+			})
+		},
+	}
+	res := &pb.GetResponse{}
+	err := h.Get(context.Background(), &pb.GetRequest{
+		Group:  proto.String("httpPoolRangeTest"),
+		Key:    proto.String("large"),
+		Offset: proto.Int64(4095),
+		Length: proto.Int64(4096),
+	}, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := value[4095 : 4095+4096]; string(res.Value) != want {
+		t.Errorf("ranged Get returned %d bytes %q...; want %q...", len(res.Value), res.Value[:10], want[:10])
+	}
+	if received > 4096+64 {
+		t.Errorf("transferred %d bytes for a 4096 byte range", received)
+	}
+}
+
+func TestHTTPPoolRangeBounds(t *testing.T) {
+	getter := GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("0123456789")
+	})
+	newGroup("httpPoolRangeBoundsTest", 1<<20, getter, NoPeers{})
+	defer DeregisterGroup("httpPoolRangeBoundsTest")
+	p := newHTTPPoolOpts("http://self", nil)
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	const maxInt64 = "9223372036854775807"
+	for _, tt := range []struct {
+		query  string
+		status int
+		value  string
+	}{
+		{"offset=1&length=" + maxInt64, http.StatusOK, "123456789"},
+		{"offset=" + maxInt64 + "&length=" + maxInt64, http.StatusOK, ""},
+		{"offset=-1&length=4", http.StatusBadRequest, ""},
+		{"offset=2&length=-4", http.StatusBadRequest, ""},
+	} {
+		res, err := http.Get(server.URL + defaultBasePath + "httpPoolRangeBoundsTest/key?" + tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tt.status {
+			t.Errorf("GET with %s = %s; want %d", tt.query, res.Status, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var out pb.GetResponse
+		if err := proto.Unmarshal(body, &out); err != nil {
+			t.Fatal(err)
+		}
+		if string(out.Value) != tt.value {
+			t.Errorf("GET with %s returned %q; want %q", tt.query, out.Value, tt.value)
+		}
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestHTTPPoolServesFromHotCache(t *testing.T) {
+	owner := &fakePeer{}
+	g := newGroup("httpPoolHotCacheTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return errors.New("getter called; the owner should serve this key")
+	}), fakePeers{owner})
+
+	// Prime the hot cache by fetching from the owner.
+	var s string
+	if err := g.Get(context.Background(), "key", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if owner.hits != 1 {
+		t.Fatalf("expected 1 owner hit; got %d", owner.hits)
+	}
+
+	p := newHTTPPoolOpts("http://self", nil)
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	h := &httpGetter{baseURL: server.URL + defaultBasePath}
+	res := &pb.GetResponse{}
+	err := h.Get(context.Background(), &pb.GetRequest{
+		Group: proto.String("httpPoolHotCacheTest"),
+		Key:   proto.String("key"),
+	}, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(res.Value), "got:key"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if owner.hits != 1 {
+		t.Errorf("expected the hot cache to answer without asking the owner; got %d owner hits", owner.hits)
+	}
+	if got := g.Stats.ServerHotCacheHits.Get(); got != 1 {
+		t.Errorf("expected 1 server hot cache hit; got %d", got)
+	}
+}
+
+func TestHTTPPoolCountsServedHotCacheHits(t *testing.T) {
+	owner := &fakePeer{}
+	g := newGroup("httpPoolHotCacheHitsTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return errors.New("getter called; the owner should serve this key")
+	}), fakePeers{owner})
+	for _, key := range []string{"hot", "both"} {
+		var s string
+		if err := g.Get(context.Background(), key, StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A key in both caches is served from the main cache.
+	g.mainCache.add("both", ByteView{s: "main"})
+
+	p := newHTTPPoolOpts("http://self", nil)
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	h := &httpGetter{baseURL: server.URL + defaultBasePath}
+	get := func(key, etag string) {
+		req := &pb.GetRequest{Group: proto.String("httpPoolHotCacheHitsTest"), Key: proto.String(key)}
+		if etag != "" {
+			req.Etag = proto.String(etag)
+		}
+		if err := h.Get(context.Background(), req, &pb.GetResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get("both", "")
+	if got := g.Stats.ServerHotCacheHits.Get(); got != 0 {
+		t.Errorf("expected a main cache hit not to count as a hot cache hit; got %d", got)
+	}
+	get("hot", "")
+	get("hot", "stale")
+	if got := g.Stats.ServerHotCacheHits.Get(); got != 2 {
+		t.Errorf("expected 2 server hot cache hits; got %d", got)
+	}
+}
+
+func TestHTTPPoolKeys(t *testing.T) {
+	owner := newGroup("httpPoolKeysTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value:" + key)
+	}), NoPeers{})
+	for _, key := range []string{"a", "b", "c"} {
+		var s string
+		if err := owner.Get(context.Background(), key, StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := newHTTPPoolOpts("http://self", nil)
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	h := &httpGetter{baseURL: server.URL + defaultBasePath}
+	res := &pb.KeysResponse{}
+	err := h.Keys(context.Background(), &pb.KeysRequest{Group: proto.String("httpPoolKeysTest")}, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"c", "b", "a"}; !reflect.DeepEqual(res.Keys, want) {
+		t.Errorf("Keys = %v; want %v", res.Keys, want)
+	}
+
+	res = &pb.KeysResponse{}
+	err = h.Keys(context.Background(), &pb.KeysRequest{Group: proto.String("httpPoolKeysTest"), Limit: proto.Int64(2)}, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Keys) != 2 {
+		t.Errorf("Keys with limit 2 returned %v", res.Keys)
+	}
+}
+
+func TestHTTPPoolRemovePrefix(t *testing.T) {
+	owner := newGroup("httpPoolRemovePrefixTest", 1<<20, nil, NoPeers{})
+	for _, key := range []string{"a/1", "a/2", "b/1"} {
+		owner.localSet(key, []byte("value"), &owner.mainCache)
+	}
+
+	p := newHTTPPoolOpts("http://self", nil)
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	h := &httpGetter{baseURL: server.URL + defaultBasePath}
+	err := h.RemovePrefix(context.Background(), &pb.GetRequest{
+		Group: proto.String("httpPoolRemovePrefixTest"),
+		Key:   proto.String("a/"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner.Contains("a/1") || owner.Contains("a/2") {
+		t.Error("expected keys under a/ to be removed")
+	}
+	if !owner.Contains("b/1") {
+		t.Error("expected b/1 to remain cached")
+	}
+}
+
+func TestHTTPPoolConditionalGet(t *testing.T) {
+	value := strings.Repeat("x", 64<<10)
+	owner := newGroup("httpPoolConditionalGetTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString(value)
+	}), NoPeers{})
+	etag, _, err := owner.GetIfModified(context.Background(), "key", "", StringSink(new(string)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newHTTPPoolOpts("http://self", nil)
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	var received int64
+	h := &httpGetter{
+		baseURL: server.URL + defaultBasePath,
+		getTransport: func(context.Context) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				res, err := http.DefaultTransport.RoundTrip(req)
+				if err == nil {
+					received += res.ContentLength
+				}
+				return res, err
+			})
+		},
+	}
+	res := &pb.GetResponse{}
+	err = h.Get(context.Background(), &pb.GetRequest{
+		Group: proto.String("httpPoolConditionalGetTest"),
+		Key:   proto.String("key"),
+		Etag:  proto.String(etag),
+	}, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.GetNotModified() || len(res.Value) != 0 {
+		t.Errorf("expected a not modified response without a value; got %d bytes", len(res.Value))
+	}
+	if received > 64 {
+		t.Errorf("transferred %d bytes for an unmodified value", received)
+	}
+
+	res = &pb.GetResponse{}
+	err = h.Get(context.Background(), &pb.GetRequest{
+		Group: proto.String("httpPoolConditionalGetTest"),
+		Key:   proto.String("key"),
+		Etag:  proto.String("stale"),
+	}, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetNotModified() || string(res.Value) != value || res.GetEtag() != etag {
+		t.Errorf("expected the value with ETag %q; got %d bytes with ETag %q", etag, len(res.Value), res.GetEtag())
+	}
+}
+
+func TestHTTPPoolRedirect(t *testing.T) {
+	newGroup("httpPoolRedirectTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value:" + key)
+	}), NoPeers{})
+
+	owner := httptest.NewServer(newHTTPPoolOpts("http://owner", nil))
+	defer owner.Close()
+
+	// The stale peer's ring says the owner now owns the key.
+	var redirected, served int
+	var stale *HTTPPool
+	staleServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stale.ServeHTTP(w, r)
+	}))
+	defer staleServer.Close()
+	stale = newHTTPPoolOpts(staleServer.URL, &HTTPPoolOptions{Redirect: true})
+	stale.Set(staleServer.URL, owner.URL)
+
+	var key string
+	for i := 0; key == ""; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		if peer, ok := stale.PickPeer(k); ok && peer.GetURL() == owner.URL+defaultBasePath {
+			key = k
+		}
+	}
+
+	picks := stale.PickCounts()
+
+	var gzipped bool
+	client := newHTTPPoolOpts("http://client", &HTTPPoolOptions{
+		Gzip: true,
+		Transport: func(context.Context) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				if strings.HasPrefix(req.URL.String(), owner.URL) {
+					served++
+					gzipped = req.Header.Get("Accept-Encoding") == "gzip"
+				} else {
+					redirected++
+				}
+				return http.DefaultTransport.RoundTrip(req)
+			})
+		},
+	})
+	h := client.newHTTPGetter(staleServer.URL)
+	res := &pb.GetResponse{}
+	err := h.Get(context.Background(), &pb.GetRequest{
+		Group: proto.String("httpPoolRedirectTest"),
+		Key:   proto.String(key),
+	}, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(res.Value), "value:"+key; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if redirected != 1 || served != 1 {
+		t.Errorf("expected one request to the stale peer and one to the owner; got %d and %d", redirected, served)
+	}
+	if !gzipped {
+		t.Error("expected the redirected request to ask for a compressed response")
+	}
+	if got := stale.PickCounts(); !reflect.DeepEqual(got, picks) {
+		t.Errorf("expected deciding to redirect not to count as a pick; got %v, want %v", got, picks)
+	}
+}
+
+func TestHTTPPoolMaxResponseBytes(t *testing.T) {
+	const total = 64 << 20
+	var written int64
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		chunk := make([]byte, 32<<10)
+		for written < total {
+			n, err := w.Write(chunk)
+			written += int64(n)
+			if err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+
+	p := newHTTPPoolOpts("http://self", &HTTPPoolOptions{MaxResponseBytes: 1 << 20})
+	h := p.newHTTPGetter(server.URL)
+	err := h.Get(context.Background(), &pb.GetRequest{
+		Group: proto.String("httpPoolMaxResponseBytesTest"),
+		Key:   proto.String("key"),
+	}, &pb.GetResponse{})
+	if !errors.Is(err, &ErrResponseTooLarge{}) {
+		t.Fatalf("expected ErrResponseTooLarge; got %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server kept streaming after the client gave up")
+	}
+	if written >= total {
+		t.Errorf("client read the whole %d byte response", written)
+	}
+}
+
+func TestHTTPPoolChecksum(t *testing.T) {
+	newGroup("httpPoolChecksumTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value:" + key)
+	}), NoPeers{})
+
+	p := newHTTPPoolOpts("http://self", nil)
+	var tamper atomic.Value
+	tamper.Store(false)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, r)
+		body := rec.Body.Bytes()
+		if tamper.Load().(bool) {
+			// Flip a bit of the value, like a faulty appliance would.
+			i := bytes.Index(body, []byte("value:"))
+			body[i] ^= 1
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	client := newHTTPPoolOpts("http://client", &HTTPPoolOptions{Checksum: true})
+	h := client.newHTTPGetter(server.URL)
+	get := func() (*pb.GetResponse, error) {
+		out := &pb.GetResponse{}
+		err := h.Get(context.Background(), &pb.GetRequest{
+			Group: proto.String("httpPoolChecksumTest"),
+			Key:   proto.String("key"),
+		}, out)
+		return out, err
+	}
+
+	out, err := get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out.Value) != "value:key" || out.Checksum == nil {
+		t.Errorf("got value %q with checksum %v; want value:key with a checksum", out.Value, out.Checksum)
+	}
+
+	tamper.Store(true)
+	if _, err := get(); !errors.Is(err, &ErrChecksum{}) {
+		t.Errorf("expected ErrChecksum for a corrupted value; got %v", err)
+	}
+
+	// Without Checksum, the corruption goes unnoticed.
+	plain := newHTTPPoolOpts("http://plain", nil).newHTTPGetter(server.URL)
+	out = &pb.GetResponse{}
+	err = plain.Get(context.Background(), &pb.GetRequest{
+		Group: proto.String("httpPoolChecksumTest"),
+		Key:   proto.String("key"),
+	}, out)
+	if err != nil || out.Checksum != nil {
+		t.Errorf("got checksum %v, %v without asking for one", out.Checksum, err)
+	}
+}
+
+func TestHTTPPoolSetStaleVersion(t *testing.T) {
+	owner := newGroup("httpPoolSetStaleVersionTest", 1<<20, nil, NoPeers{})
+
+	server := httptest.NewServer(newHTTPPoolOpts("http://self", nil))
+	defer server.Close()
+
+	h := &httpGetter{baseURL: server.URL + defaultBasePath}
+	set := func(value string, version int64) error {
+		return h.Set(context.Background(), &pb.SetRequest{
+			Group:   proto.String("httpPoolSetStaleVersionTest"),
+			Key:     proto.String("key"),
+			Value:   []byte(value),
+			Version: proto.Int64(version),
+		})
+	}
+	if err := set("new", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := set("old", 1); !errors.Is(err, &ErrStaleVersion{}) {
+		t.Errorf("expected ErrStaleVersion; got %v", err)
+	}
+
+	res := &pb.GetResponse{}
+	err := h.Get(context.Background(), &pb.GetRequest{
+		Group: proto.String("httpPoolSetStaleVersionTest"),
+		Key:   proto.String("key"),
+	}, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Value) != "new" || res.GetVersion() != 2 {
+		t.Errorf("got %q at version %d; want new at version 2", res.Value, res.GetVersion())
+	}
+	if view, _ := owner.mainCache.get("key"); view.String() != "new" {
+		t.Errorf("owner has %q; want new", view.String())
+	}
+}
+
+// recordingLogger is a Logger which records the messages it prints.
+type recordingLogger struct {
+	mu       *sync.Mutex
+	messages *[]string
+}
+
+func newRecordingLogger() recordingLogger {
+	return recordingLogger{mu: &sync.Mutex{}, messages: new([]string)}
+}
+
+func (l recordingLogger) Error() Logger                                   { return l }
+func (l recordingLogger) Warn() Logger                                    { return l }
+func (l recordingLogger) Info() Logger                                    { return l }
+func (l recordingLogger) Debug() Logger                                   { return l }
+func (l recordingLogger) ErrorField(label string, err error) Logger       { return l }
+func (l recordingLogger) StringField(label string, val string) Logger     { return l }
+func (l recordingLogger) WithFields(fields map[string]interface{}) Logger { return l }
+
+func (l recordingLogger) Printf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	*l.messages = append(*l.messages, fmt.Sprintf(format, args...))
+}
+
+func (l recordingLogger) Messages() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), *l.messages...)
+}
+
+func TestHTTPPoolWarnsWithoutSelf(t *testing.T) {
+	rec := newRecordingLogger()
+	defer SetLoggerFromLogger(logger)
+	SetLoggerFromLogger(rec)
+
+	p := newHTTPPoolOpts("http://self", nil)
+	p.Set("http://self", "http://other")
+	if !p.HasSelf() || len(rec.Messages()) != 0 {
+		t.Errorf("HasSelf() = %v with messages %q; want true without messages", p.HasSelf(), rec.Messages())
+	}
+
+	p.Set("http://a", "http://b")
+	if p.HasSelf() {
+		t.Error("HasSelf() = true for peers without self")
+	}
+	if msgs := rec.Messages(); len(msgs) != 1 || !strings.Contains(msgs[0], "http://self") {
+		t.Errorf("expected a warning naming self; got %q", msgs)
+	}
+}
+
+func TestHTTPPoolAuthorize(t *testing.T) {
+	newGroup("httpPoolAuthorizeTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("secret")
+	}), NoPeers{})
+
+	p := newHTTPPoolOpts("http://self", &HTTPPoolOptions{
+		Authorize: func(r *http.Request) error {
+			if r.Header.Get("Authorization") != "Bearer peer-token" {
+				return errors.New("unknown peer")
+			}
+			return nil
+		},
+	})
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	get := func(token string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, server.URL+defaultBasePath+"httpPoolAuthorizeTest/key", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+	if res := get(""); res.StatusCode != http.StatusForbidden {
+		t.Errorf("unauthorized request got status %d; want %d", res.StatusCode, http.StatusForbidden)
+	}
+	if res := get("wrong"); res.StatusCode != http.StatusForbidden {
+		t.Errorf("request with a wrong token got status %d; want %d", res.StatusCode, http.StatusForbidden)
+	}
+	if res := get("peer-token"); res.StatusCode != http.StatusOK {
+		t.Errorf("authorized request got status %d; want %d", res.StatusCode, http.StatusOK)
+	}
+}
+
+func TestHTTPPoolSelf(t *testing.T) {
+	peers := []string{"http://cache-1.svc:8080", "http://cache-2.svc:8080"}
+	ring := consistenthash.New(defaultReplicas, nil)
+	ring.Add(peers...)
+
+	pools := map[string]*HTTPPool{
+		"advertised": newHTTPPoolOpts("http://0.0.0.0:8080", &HTTPPoolOptions{Self: peers[0]}),
+		"alias": newHTTPPoolOpts("http://0.0.0.0:8080", &HTTPPoolOptions{
+			IsSelf: func(peerURL string) bool { return peerURL == peers[0] || peerURL == "http://10.0.0.1:8080" },
+		}),
+	}
+	for name, p := range pools {
+		p.Set(peers...)
+		if !p.HasSelf() {
+			t.Errorf("%s: HasSelf() = false", name)
+		}
+		var local int
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			_, remote := p.PickPeer(key)
+			if owner := ring.Get(key); remote != (owner != peers[0]) {
+				t.Fatalf("%s: PickPeer(%q) remote = %v; owner is %s", name, key, remote, owner)
+			}
+			if !remote {
+				local++
+			}
+		}
+		if local == 0 {
+			t.Errorf("%s: no key was routed locally", name)
+		}
+	}
+}
+
+func TestHTTPPoolClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	var calls int32
+	p := newHTTPPoolOpts("http://self", &HTTPPoolOptions{
+		PeerProvider: func() []string {
+			atomic.AddInt32(&calls, 1)
+			return []string{"http://self"}
+		},
+		PeerProviderInterval: time.Millisecond,
+	})
+	time.Sleep(10 * time.Millisecond)
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitForGoroutines(t, before)
+
+	n := atomic.LoadInt32(&calls)
+	time.Sleep(10 * time.Millisecond)
+	if got := atomic.LoadInt32(&calls); got != n {
+		t.Errorf("PeerProvider was called %d times after Close", got-n)
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, defaultBasePath+"group/key", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d after Close; want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestHTTPPoolGetMulti(t *testing.T) {
+	const n = 100
+	// The last value is only loaded once the client has processed all
+	// the others, which it can only do if it reads them as they arrive.
+	received := make(chan struct{})
+	newGroup("httpPoolGetMultiTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		if key == "missing" {
+			return &ErrNotFound{Msg: "not found"}
+		}
+		if key == fmt.Sprintf("key-%d", n-1) {
+			select {
+			case <-received:
+			case <-time.After(5 * time.Second):
+				return errors.New("values were not processed incrementally")
+			}
+		}
+		return dest.SetString("value:" + key)
+	}), NoPeers{})
+
+	p := newHTTPPoolOpts("http://self", nil)
+	server := httptest.NewServer(p)
+	defer server.Close()
+	getter := p.newHTTPGetter(server.URL)
+
+	var keys []string
+	for i := 0; i < n; i++ {
+		keys = append(keys, fmt.Sprintf("key-%d", i))
+	}
+	keys = append(keys, "missing")
+
+	var got []string
+	err := getter.GetMulti(context.Background(), &pb.GetMultiRequest{
+		Group: proto.String("httpPoolGetMultiTest"),
+		Keys:  keys,
+	}, func(key string, out *pb.GetResponse, err error) {
+		if key == "missing" {
+			if !errors.Is(err, &ErrNotFound{}) {
+				t.Errorf("expected ErrNotFound for the missing key; got %v", err)
+			}
+			return
+		}
+		if err != nil {
+			t.Errorf("key %q failed: %v", key, err)
+			return
+		}
+		if want := "value:" + key; string(out.Value) != want {
+			t.Errorf("got %q for %q; want %q", out.Value, key, want)
+		}
+		got = append(got, key)
+		if len(got) == n-1 {
+			close(received)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, keys[:n]) {
+		t.Errorf("received keys %q; want %q in order", got, keys[:n])
+	}
+
+	// The framed response must be asked for.
+	res, err := http.Post(server.URL+defaultBasePath+"httpPoolGetMultiTest", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotAcceptable {
+		t.Errorf("got status %d without Accept; want %d", res.StatusCode, http.StatusNotAcceptable)
+	}
+
+	// Oversized requests are refused.
+	req, err := http.NewRequest(http.MethodPost, server.URL+defaultBasePath+"httpPoolGetMultiTest",
+		bytes.NewReader(make([]byte, maxMultiRequestBytes+1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", framedContentType)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("got status %d for an oversized request; want %d", res.StatusCode, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestHTTPPoolBatchWindow(t *testing.T) {
+	newGroup("httpPoolBatchWindowTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		if key == "missing" {
+			return &ErrNotFound{Msg: "not found"}
+		}
+		return dest.SetString("value:" + key)
+	}), NoPeers{})
+
+	p := newHTTPPoolOpts("http://self", nil)
+	var gets, posts int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			atomic.AddInt64(&posts, 1)
+		} else {
+			atomic.AddInt64(&gets, 1)
+		}
+		p.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	client := newHTTPPoolOpts("http://client", &HTTPPoolOptions{BatchWindow: 100 * time.Millisecond})
+	h := client.newHTTPGetter(server.URL)
+
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		// Two callers for each key share its answer.
+		key := fmt.Sprintf("key-%d", i/2)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out := &pb.GetResponse{}
+			err := h.Get(context.Background(), &pb.GetRequest{
+				Group: proto.String("httpPoolBatchWindowTest"),
+				Key:   proto.String(key),
+			}, out)
+			if err != nil {
+				t.Errorf("Get(%s): %v", key, err)
+				return
+			}
+			if want := "value:" + key; string(out.Value) != want {
+				t.Errorf("Get(%s) = %q; want %q", key, out.Value, want)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := atomic.LoadInt64(&gets); got != 0 {
+		t.Errorf("made %d single gets; want none", got)
+	}
+	if got := atomic.LoadInt64(&posts); got < 1 || got > 2 {
+		t.Errorf("made %d multi-gets for %d concurrent Gets; want 1 or 2", got, n)
+	}
+
+	err := h.Get(context.Background(), &pb.GetRequest{
+		Group: proto.String("httpPoolBatchWindowTest"),
+		Key:   proto.String("missing"),
+	}, &pb.GetResponse{})
+	if !errors.Is(err, &ErrNotFound{}) {
+		t.Errorf("batched Get of a missing key = %v; want ErrNotFound", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = h.Get(ctx, &pb.GetRequest{
+		Group: proto.String("httpPoolBatchWindowTest"),
+		Key:   proto.String("key-0"),
+	}, &pb.GetResponse{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("batched Get with a canceled context = %v; want context.Canceled", err)
+	}
+}
+
+func TestHTTPPoolBatchWindowBounds(t *testing.T) {
+	newGroup("httpPoolBatchBoundsTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value:" + key)
+	}), NoPeers{})
+	defer DeregisterGroup("httpPoolBatchBoundsTest")
+	p := newHTTPPoolOpts("http://self", nil)
+	var hang int32
+	abandoned := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&hang) == 1 {
+			// The server only notices the client going away once the
+			// body is read.
+			io.Copy(io.Discard, r.Body)
+			select {
+			case <-r.Context().Done():
+				abandoned <- struct{}{}
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		p.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	get := func(h *httpGetter, ctx context.Context) (string, error) {
+		out := &pb.GetResponse{}
+		err := h.Get(ctx, &pb.GetRequest{
+			Group: proto.String("httpPoolBatchBoundsTest"),
+			Key:   proto.String("key"),
+		}, out)
+		return string(out.Value), err
+	}
+
+	// A multi-get is given up with the deadline of its Gets.
+	atomic.StoreInt32(&hang, 1)
+	h := newHTTPPoolOpts("http://client", &HTTPPoolOptions{BatchWindow: time.Millisecond}).newHTTPGetter(server.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := get(h, ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get from a hanging peer = %v; want context.DeadlineExceeded", err)
+	}
+	select {
+	case <-abandoned:
+	case <-time.After(time.Second):
+		t.Error("the multi-get outlived the deadline of its Gets")
+	}
+	atomic.StoreInt32(&hang, 0)
+
+	// Close sends the pending gets right away.
+	client := newHTTPPoolOpts("http://client", &HTTPPoolOptions{BatchWindow: time.Hour})
+	client.Set(server.URL)
+	h = client.httpGetters[server.URL]
+	got := make(chan error)
+	go func() {
+		v, err := get(h, context.Background())
+		if err == nil && v != "value:key" {
+			err = fmt.Errorf("got %q", v)
+		}
+		got <- err
+	}()
+	for pending := 0; pending == 0; {
+		time.Sleep(time.Millisecond)
+		h.batch.mu.Lock()
+		pending = len(h.batch.pending)
+		h.batch.mu.Unlock()
+	}
+	client.Close()
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Errorf("Get pending at Close = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Close left the Get waiting for its window")
+	}
+
+	// Redirects are only followed by single gets.
+	if h := newHTTPPoolOpts("http://client", &HTTPPoolOptions{BatchWindow: time.Hour, Redirect: true}).newHTTPGetter(server.URL); h.batch != nil {
+		t.Error("gets are batched with Redirect")
+	}
+}
+
+func TestBatcherCloseWhileGetting(t *testing.T) {
+	b := &batcher{
+		window: time.Millisecond,
+		multi: func(ctx context.Context, in *pb.GetMultiRequest, fn func(string, *pb.GetResponse, error)) error {
+			for _, key := range in.Keys {
+				fn(key, &pb.GetResponse{Value: []byte(key)}, nil)
+			}
+			return nil
+		},
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				key := fmt.Sprintf("key-%d-%d", i, j)
+				out := &pb.GetResponse{}
+				if err := b.get(context.Background(), "group", key, out); err != nil || string(out.Value) != key {
+					t.Errorf("get(%q) = %q, %v", key, out.Value, err)
+					return
+				}
+			}
+		}(i)
+	}
+	// Gets keep starting batches while close waits for them.
+	time.Sleep(5 * time.Millisecond)
+	b.close()
+	wg.Wait()
+}
+
+func TestHTTPPoolPickPeerHashed(t *testing.T) {
+	p := newHTTPPoolOpts("http://a", nil)
+	p.Set("http://a", "http://b", "http://c")
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		peer, ok := p.PickPeer(key)
+		hashedPeer, hashedOK := p.PickPeerHashed(xxh3.HashString(key))
+		if ok != hashedOK || peer != hashedPeer {
+			t.Fatalf("PickPeerHashed(hash(%q)) = %v, %v; PickPeer = %v, %v", key, hashedPeer, hashedOK, peer, ok)
+		}
+	}
+}
+
+func TestHTTPPoolRingFingerprint(t *testing.T) {
+	a := newHTTPPoolOpts("http://a", nil)
+	b := newHTTPPoolOpts("http://b", nil)
+	a.Set("http://a", "http://b", "http://c")
+	b.Set("http://c", "http://b", "http://a")
+	if fa, fb := a.RingFingerprint(), b.RingFingerprint(); fa != fb {
+		t.Errorf("identical peers have fingerprints %s and %s", fa, fb)
+	}
+
+	before := a.RingFingerprint()
+	a.Set("http://a", "http://b")
+	if a.RingFingerprint() == before {
+		t.Error("removing a peer kept the fingerprint")
+	}
+	if a.RingFingerprint() == b.RingFingerprint() {
+		t.Error("differing peers have the same fingerprint")
+	}
+
+	more := newHTTPPoolOpts("http://a", &HTTPPoolOptions{Replicas: 100})
+	more.Set("http://a", "http://b", "http://c")
+	if more.RingFingerprint() == b.RingFingerprint() {
+		t.Error("differing replica counts have the same fingerprint")
+	}
+}
+
+// firstByteRing places a key on the sorted nodes by its first byte.
+type firstByteRing struct {
+	nodes []string
+}
+
+func (r *firstByteRing) Add(nodes ...string) {
+	r.nodes = append(r.nodes, nodes...)
+	sort.Strings(r.nodes)
+}
+
+func (r *firstByteRing) Remove(nodes ...string) {
+	for _, node := range nodes {
+		for i, n := range r.nodes {
+			if n == node {
+				r.nodes = append(r.nodes[:i], r.nodes[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func (r *firstByteRing) Get(key string) string {
+	return r.nodes[int(key[0])%len(r.nodes)]
+}
+
+// GetHashed places a key by the low byte of its hash.
+func (r *firstByteRing) GetHashed(keyHash uint64) string {
+	return r.nodes[int(keyHash&0xff)%len(r.nodes)]
+}
+
+func (r *firstByteRing) IsEmpty() bool {
+	return len(r.nodes) == 0
+}
+
+func TestHTTPPoolRingFactory(t *testing.T) {
+	var rings int
+	p := newHTTPPoolOpts("http://a", &HTTPPoolOptions{RingFactory: func() Ring {
+		rings++
+		return &firstByteRing{}
+	}})
+	p.Set("http://c", "http://a", "http://b")
+	if rings != 2 {
+		t.Errorf("RingFactory called %d times; want once by the constructor and once by Set", rings)
+	}
+
+	// '0' is 48, so keys starting with it map to a, '1' to b and '2' to c.
+	if peer, ok := p.PickPeer("0-key"); ok {
+		t.Errorf("PickPeer(0-key) = %s; want self", peer.GetURL())
+	}
+	for key, want := range map[string]string{"1-key": "http://b", "2-key": "http://c", "3-key": "http://a"} {
+		peer, ok := p.PickPeer(key)
+		if want == "http://a" {
+			if ok {
+				t.Errorf("PickPeer(%s) = %s; want self", key, peer.GetURL())
+			}
+			continue
+		}
+		if !ok || peerID(peer) != want {
+			t.Errorf("PickPeer(%s) = %v, %v; want %s", key, peer, ok, want)
+		}
+	}
+
+	if peer, ok := p.PickPeerHashed(49); !ok || peerID(peer) != "http://b" {
+		t.Errorf("PickPeerHashed(49) = %v, %v; want http://b", peer, ok)
+	}
+	if got := p.RingSize(); got != 0 {
+		t.Errorf("RingSize() = %d; want 0 for a ring without Len", got)
+	}
+	q := newHTTPPoolOpts("http://b", &HTTPPoolOptions{RingFactory: func() Ring { return &firstByteRing{} }})
+	q.Set("http://a", "http://b", "http://c")
+	if p.RingFingerprint() != q.RingFingerprint() {
+		t.Error("pools with the same peers on custom rings have differing fingerprints")
+	}
+}
+
+func TestHTTPPoolLoopback(t *testing.T) {
+	const self, other = "http://10.0.0.1:8080", "http://10.0.0.2:8080"
+	var mu sync.Mutex
+	var requests []string
+	p := newHTTPPoolOpts("http://0.0.0.0:8080", &HTTPPoolOptions{
+		IsSelf: func(peerURL string) bool { return peerURL == self },
+		Transport: func(context.Context) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				mu.Lock()
+				requests = append(requests, req.Method+" "+req.URL.String())
+				mu.Unlock()
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+			})
+		},
+	})
+	p.Set(self, other)
+
+	var loads int
+	g := NewGroupWithOptions("httpPoolLoopbackTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		loads++
+		return dest.SetString("value:" + key)
+	}), &GroupOptions{Peers: p})
+	defer DeregisterGroup("httpPoolLoopbackTest")
+
+	var key string
+	for i := 0; key == ""; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		if _, ok := p.PickPeer(k); !ok {
+			key = k
+		}
+	}
+
+	var s string
+	if err := g.Get(context.Background(), key, StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if s != "value:"+key || loads != 1 {
+		t.Errorf("got %q after %d loads; want a local load of the key", s, loads)
+	}
+	if len(requests) != 0 {
+		t.Errorf("getting a key this process owns made requests %q", requests)
+	}
+
+	// Removals are only sent to the other peer.
+	if err := g.Remove(context.Background(), key); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 || !strings.HasPrefix(requests[0], "DELETE "+other+"/") {
+		t.Errorf("Remove made requests %q; want one to %s", requests, other)
+	}
+}
+
+func TestHTTPPoolPickCounts(t *testing.T) {
+	peers := []string{"http://a:8080", "http://b:8080", "http://c:8080", "http://d:8080"}
+	p := newHTTPPoolOpts(peers[0], &HTTPPoolOptions{Replicas: 200})
+	p.Set(peers...)
+
+	const picks = 10000
+	for i := 0; i < picks; i++ {
+		p.PickPeer(fmt.Sprintf("key-%d", i))
+	}
+	counts := p.PickCounts()
+	var sum int64
+	for _, peer := range peers {
+		n := counts[peer]
+		sum += n
+		if share := float64(n) / picks; share < 0.15 || share > 0.35 {
+			t.Errorf("%s was picked %d times; want about a quarter of %d", peer, n, picks)
+		}
+	}
+	if sum != picks || len(counts) != len(peers) {
+		t.Errorf("counts %v sum to %d; want %d picks of the %d peers", counts, sum, picks, len(peers))
+	}
+
+	// The result is a copy.
+	counts[peers[0]] = 0
+	if p.PickCounts()[peers[0]] == 0 {
+		t.Error("modifying the result changed the pool's counts")
+	}
+}
+
+func TestHTTPPoolCacheHit(t *testing.T) {
+	newGroup("httpPoolCacheHitTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		return dest.SetString("value:" + key)
+	}), NoPeers{})
+	p := newHTTPPoolOpts("http://self", nil)
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	h := p.newHTTPGetter(server.URL)
+	for i, want := range []bool{false, true} {
+		out := &pb.GetResponse{}
+		err := h.Get(context.Background(), &pb.GetRequest{
+			Group: proto.String("httpPoolCacheHitTest"),
+			Key:   proto.String("key"),
+		}, out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.GetCacheHit() != want {
+			t.Errorf("fetch #%d: cache hit = %v; want %v", i, out.GetCacheHit(), want)
+		}
+	}
+}
+
+func TestHTTPPoolGossip(t *testing.T) {
+	const n = 3
+	pools := make([]*HTTPPool, n)
+	urls := make([]string, n)
+	for i := range pools {
+		i := i
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pools[i].ServeHTTP(w, r)
+		}))
+		defer server.Close()
+		urls[i] = server.URL
+	}
+	for i := range pools {
+		// Rounds are run by hand below rather than on the interval.
+		pools[i] = newHTTPPoolOpts(urls[i], &HTTPPoolOptions{Gossip: true, GossipInterval: time.Hour})
+		defer pools[i].Close()
+	}
+	// Only the first pool knows every peer, the others one neighbor.
+	pools[0].Set(urls...)
+	pools[1].Set(urls[0], urls[1])
+	pools[2].Set(urls[1], urls[2])
+
+	full := append([]string(nil), urls...)
+	sort.Strings(full)
+	converged := func() bool {
+		for _, p := range pools {
+			if !reflect.DeepEqual(p.Peers(), full) {
+				return false
+			}
+		}
+		return true
+	}
+	rounds := 0
+	for ; !converged() && rounds < n; rounds++ {
+		for _, p := range pools {
+			p.gossip(context.Background())
+		}
+	}
+	if !converged() {
+		for i, p := range pools {
+			t.Errorf("pool %d has peers %q after %d rounds; want %q", i, p.Peers(), rounds, full)
+		}
+	}
+
+	// The peers learned past MaxGossipPeers are not added.
+	bounded := newHTTPPoolOpts("http://bounded", &HTTPPoolOptions{Gossip: true, GossipInterval: time.Hour, MaxGossipPeers: 3})
+	defer bounded.Close()
+	bounded.Set("http://bounded", urls[0])
+	bounded.gossip(context.Background())
+	others := []string{urls[1], urls[2]}
+	sort.Strings(others)
+	want := []string{"http://bounded", urls[0], others[0]}
+	sort.Strings(want)
+	if got := bounded.Peers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("bounded pool has peers %q; want %q", got, want)
+	}
+
+	// Pools which do not gossip do not list their peers.
+	var res pb.PeersResponse
+	plain := newHTTPPoolOpts("http://plain", nil)
+	server := httptest.NewServer(plain)
+	defer server.Close()
+	if err := plain.newHTTPGetter(server.URL).Peers(context.Background(), &res); err == nil {
+		t.Errorf("got peers %q from a pool which does not gossip", res.Peers)
+	}
+}
+
+func TestHTTPPoolReady(t *testing.T) {
+	const self = "http://a:8080"
+	peers := []string{self, "http://b:8080", "http://c:8080", "http://d:8080"}
+	var mu sync.Mutex
+	down := map[string]bool{}
+	transport := func(context.Context) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if down["http://"+req.URL.Host] {
+				return nil, errors.New("unreachable")
+			}
+			return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader(""))}, nil
+		})
+	}
+	setDown := func(hosts ...string) {
+		mu.Lock()
+		defer mu.Unlock()
+		down = map[string]bool{}
+		for _, h := range hosts {
+			down[h] = true
+		}
+	}
+
+	// Probe on every call.
+	p := newHTTPPoolOpts(self, &HTTPPoolOptions{Transport: transport, ReadyInterval: time.Nanosecond})
+	if !p.Ready() {
+		t.Error("a pool without peers is not ready")
+	}
+	p.Set(peers...)
+	if !p.Ready() {
+		t.Error("not ready while every peer is reachable")
+	}
+	// Self and one other peer out of four is not a quorum.
+	setDown(peers[1], peers[2])
+	if p.Ready() {
+		t.Error("ready while half of the peers are unreachable")
+	}
+	setDown(peers[1])
+	if !p.Ready() {
+		t.Error("not ready after the peers recovered")
+	}
+	p.Close()
+	if p.Ready() {
+		t.Error("ready after Close")
+	}
+
+	// The result is reused for ReadyInterval.
+	setDown()
+	cached := newHTTPPoolOpts(self, &HTTPPoolOptions{Transport: transport, ReadyInterval: time.Hour})
+	cached.Set(peers...)
+	if !cached.Ready() {
+		t.Fatal("not ready while every peer is reachable")
+	}
+	setDown(peers[1:]...)
+	if !cached.Ready() {
+		t.Error("Ready probed again within ReadyInterval")
+	}
+}
+
+func TestHTTPPoolInvalidateTag(t *testing.T) {
+	g := NewGroupWithOptions("httpPoolInvalidateTagTest", 1<<20, nil, &GroupOptions{
+		LocalOnly: true,
+		Tags:      func(key string, value ByteView) []string { return []string{value.String()} },
+	})
+	defer DeregisterGroup("httpPoolInvalidateTagTest")
+	g.localSet("a", []byte("red"), &g.mainCache)
+	g.localSet("b", []byte("blue"), &g.mainCache)
+
+	p := newHTTPPoolOpts("http://self", nil)
+	server := httptest.NewServer(p)
+	defer server.Close()
+	name, tag := g.Name(), "red"
+	if err := p.newHTTPGetter(server.URL).InvalidateTag(context.Background(), &pb.GetRequest{Group: &name, Key: &tag}); err != nil {
+		t.Fatal(err)
+	}
+	if g.Contains("a") || !g.Contains("b") {
+		t.Errorf("after invalidating red, Contains(a) = %v and Contains(b) = %v; want false and true", g.Contains("a"), g.Contains("b"))
+	}
+}
+
+func TestHTTPPoolPickPeers(t *testing.T) {
+	peers := []string{"http://a:8080", "http://b:8080", "http://c:8080", "http://d:8080"}
+	p := newHTTPPoolOpts(peers[0], nil)
+	p.Set(peers...)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		picked := p.PickPeers(key, 3)
+		owner, remote := p.PickPeer(key)
+		if !remote {
+			if picked != nil {
+				t.Errorf("PickPeers(%q) = %v for a key this process owns; want nil", key, picked)
+			}
+			continue
+		}
+		if len(picked) != 3 || picked[0] != owner {
+			t.Fatalf("PickPeers(%q) = %v; want 3 peers starting with the owner", key, picked)
+		}
+		seen := map[string]bool{}
+		for _, peer := range picked {
+			id := peerID(peer)
+			if id == peers[0] || seen[id] {
+				t.Errorf("PickPeers(%q) picked %s twice or this process", key, id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+func TestHTTPPoolGzip(t *testing.T) {
+	newGroup("httpPoolGzipTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		n, err := strconv.Atoi(key)
+		if err != nil {
+			return err
+		}
+		return dest.SetString(strings.Repeat("x", n))
+	}), NoPeers{})
+
+	var mu sync.Mutex
+	encodings := map[string]string{}
+	p := newHTTPPoolOpts("http://self", &HTTPPoolOptions{
+		Gzip:         true,
+		GzipMinBytes: 512,
+		Transport: func(context.Context) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				res, err := http.DefaultTransport.RoundTrip(req)
+				if err == nil {
+					mu.Lock()
+					encodings[path.Base(req.URL.Path)] = res.Header.Get("Content-Encoding")
+					mu.Unlock()
+				}
+				return res, err
+			})
+		},
+	})
+	server := httptest.NewServer(p)
+	defer server.Close()
+	h := p.newHTTPGetter(server.URL)
+
+	for _, size := range []int{10, 10000} {
+		key := strconv.Itoa(size)
+		var res pb.GetResponse
+		if err := h.Get(context.Background(), &pb.GetRequest{Group: proto.String("httpPoolGzipTest"), Key: &key}, &res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Value) != size {
+			t.Errorf("got %d bytes; want %d", len(res.Value), size)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if encodings["10"] != "" || encodings["10000"] != "gzip" {
+		t.Errorf("got Content-Encodings %q; want only the large value compressed", encodings)
+	}
+}
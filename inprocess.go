@@ -0,0 +1,204 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/xdbbe/groupcache/v2/consistenthash"
+	pb "github.com/xdbbe/groupcache/v2/groupcachepb"
+)
+
+// InProcessPicker is a PeerPicker for Groups of one process which act
+// as peers of each other, for testing multi-peer behaviour without HTTP.
+// Peer requests are direct calls into the Group which owns the key
+// according to a consistent hash ring, so they take the same load path
+// as requests to remote peers, including hot cache population.
+//
+// Each Group uses the picker returned by Self with its own peer name,
+// and is added to the ring with Add:
+//
+//	peers := groupcache.NewInProcessPicker()
+//	a := groupcache.NewGroupWithOptions("a", 64<<20, getter, &groupcache.GroupOptions{
+//		Peers: peers.Self("a"),
+//	})
+//	peers.Add("a", a)
+type InProcessPicker struct {
+	self  string
+	peers *inProcessPeers
+}
+
+// inProcessPeers is the state shared by the pickers of every peer.
+type inProcessPeers struct {
+	mu      sync.Mutex
+	ring    *consistenthash.Map
+	getters map[string]*inProcessGetter
+}
+
+// NewInProcessPicker returns a picker for a new empty set of peers.
+func NewInProcessPicker() *InProcessPicker {
+	return &InProcessPicker{
+		peers: &inProcessPeers{
+			ring:    consistenthash.New(defaultReplicas, nil),
+			getters: make(map[string]*inProcessGetter),
+		},
+	}
+}
+
+// Self returns a picker for the same set of peers which treats the peer
+// named self as the current process.
+func (p *InProcessPicker) Self(self string) *InProcessPicker {
+	return &InProcessPicker{self: self, peers: p.peers}
+}
+
+// Add adds g to the ring as the peer named peer.
+func (p *InProcessPicker) Add(peer string, g *Group) {
+	p.peers.mu.Lock()
+	defer p.peers.mu.Unlock()
+	if _, ok := p.peers.getters[peer]; !ok {
+		p.peers.ring.Add(peer)
+	}
+	p.peers.getters[peer] = &inProcessGetter{peer: peer, group: g}
+}
+
+// PickPeer picks the peer which owns key.
+func (p *InProcessPicker) PickPeer(key string) (ProtoGetter, bool) {
+	p.peers.mu.Lock()
+	defer p.peers.mu.Unlock()
+	if p.peers.ring.IsEmpty() {
+		return nil, false
+	}
+	if peer := p.peers.ring.Get(key); peer != p.self {
+		return p.peers.getters[peer].from(p.self), true
+	}
+	return nil, false
+}
+
+// PickPeers implements ReplicaPicker.
+func (p *InProcessPicker) PickPeers(key string, n int) []ProtoGetter {
+	p.peers.mu.Lock()
+	defer p.peers.mu.Unlock()
+	peers := p.peers.ring.GetN(key, n+1)
+	if len(peers) == 0 || peers[0] == p.self {
+		return nil
+	}
+	var res []ProtoGetter
+	for _, peer := range peers {
+		if len(res) < n && peer != p.self {
+			res = append(res, p.peers.getters[peer].from(p.self))
+		}
+	}
+	return res
+}
+
+// GetAll returns all the peers except self.
+func (p *InProcessPicker) GetAll() []ProtoGetter {
+	p.peers.mu.Lock()
+	defer p.peers.mu.Unlock()
+	var res []ProtoGetter
+	for peer, getter := range p.peers.getters {
+		if peer != p.self {
+			res = append(res, getter.from(p.self))
+		}
+	}
+	return res
+}
+
+// inProcessGetter is a ProtoGetter which serves requests from a Group
+// the way HTTPPool.ServeHTTP does.
+type inProcessGetter struct {
+	peer   string
+	group  *Group
+	caller string // the peer making the requests, if known
+}
+
+// from returns a copy of h which makes requests as the peer named caller.
+func (h *inProcessGetter) from(caller string) *inProcessGetter {
+	c := *h
+	c.caller = caller
+	return &c
+}
+
+func (h *inProcessGetter) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	h.group.Stats.ServerRequests.Add(1)
+	var view ByteView
+	var info GetInfo
+	var err error
+	if in.GetOffset() != 0 || in.GetLength() != 0 {
+		err = h.group.GetRange(ctx, in.GetKey(), in.GetOffset(), in.GetLength(), ByteViewSink(&view))
+	} else {
+		info, err = h.group.GetWithInfo(ctx, in.GetKey(), ByteViewSink(&view))
+	}
+	if err != nil {
+		if errors.Is(err, &ErrNotFound{}) {
+			return err
+		}
+		return &ErrRemoteCall{Msg: err.Error()}
+	}
+	h.group.recordFetcher(in.GetKey(), h.caller)
+	out.Value = view.ByteSlice()
+	if v := view.Version(); v != 0 {
+		out.Version = &v
+	}
+	if info.CacheHit {
+		out.CacheHit = &info.CacheHit
+	}
+	return nil
+}
+
+func (h *inProcessGetter) Set(ctx context.Context, in *pb.SetRequest) error {
+	if !h.group.localSetVersion(in.GetKey(), cloneBytes(in.Value), in.GetVersion(), &h.group.mainCache) {
+		return staleVersion(in.GetKey(), in.GetVersion())
+	}
+	if err := h.group.pushInvalidations(ctx, in.GetKey()); err != nil {
+		return err
+	}
+	h.group.recordFetcher(in.GetKey(), h.caller)
+	return nil
+}
+
+func (h *inProcessGetter) Remove(ctx context.Context, in *pb.GetRequest) error {
+	h.group.localRemove(in.GetKey())
+	return h.group.pushInvalidations(ctx, in.GetKey())
+}
+
+func (h *inProcessGetter) RemovePrefix(ctx context.Context, in *pb.GetRequest) error {
+	if err := h.group.fingerprintErr("RemoveByPrefix"); err != nil {
+		return err
+	}
+	h.group.localRemovePrefix(in.GetKey())
+	return nil
+}
+
+func (h *inProcessGetter) InvalidateTag(ctx context.Context, in *pb.GetRequest) error {
+	h.group.localInvalidateTag(in.GetKey())
+	return nil
+}
+
+func (h *inProcessGetter) Keys(ctx context.Context, in *pb.KeysRequest, out *pb.KeysResponse) error {
+	if err := h.group.fingerprintErr("listing keys"); err != nil {
+		return err
+	}
+	out.Keys = h.group.mainCache.keys(in.GetLimit())
+	return nil
+}
+
+func (h *inProcessGetter) GetURL() string {
+	return h.peer
+}
@@ -0,0 +1,508 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xdbbe/groupcache/v2/consistenthash"
+)
+
+func TestInProcessPicker(t *testing.T) {
+	peers := NewInProcessPicker()
+	loads := map[string]int{}
+	var mu sync.Mutex
+	groups := map[string]*Group{}
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		g := NewGroupWithOptions("inProcessPickerTest-"+name, 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+			mu.Lock()
+			loads[name]++
+			mu.Unlock()
+			return dest.SetString("value:" + key)
+		}), &GroupOptions{Peers: peers.Self(name)})
+		defer DeregisterGroup("inProcessPickerTest-" + name)
+		peers.Add(name, g)
+		groups[name] = g
+	}
+
+	// Find a key b owns.
+	var key string
+	for i := 0; key == ""; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		if owner, ok := peers.Self("a").PickPeer(k); ok && owner.GetURL() == "b" {
+			key = k
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		var s string
+		if err := groups["a"].Get(context.Background(), key, StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+		if want := "value:" + key; s != want {
+			t.Fatalf("got %q; want %q", s, want)
+		}
+	}
+
+	if loads["a"] != 0 || loads["b"] != 1 || loads["c"] != 0 {
+		t.Errorf("loads = %v; want only b to load once", loads)
+	}
+	if got := groups["a"].Stats.PeerLoads.Get(); got != 1 {
+		t.Errorf("a made %d peer loads; want 1", got)
+	}
+	if got := groups["b"].Stats.ServerRequests.Get(); got != 1 {
+		t.Errorf("b served %d requests; want 1", got)
+	}
+	if !groups["a"].hotCache.contains(key) {
+		t.Error("expected a to keep a hot copy of the key")
+	}
+	if !groups["b"].mainCache.contains(key) {
+		t.Error("expected b to cache the key in its main cache")
+	}
+
+	// Remove reaches every peer.
+	if err := groups["c"].Remove(context.Background(), key); err != nil {
+		t.Fatal(err)
+	}
+	for name, g := range groups {
+		if g.Contains(key) {
+			t.Errorf("expected %s to have dropped the key", name)
+		}
+	}
+}
+
+func TestPushInvalidations(t *testing.T) {
+	peers := NewInProcessPicker()
+	groups := map[string]*Group{}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		name := name
+		g := NewGroupWithOptions("pushInvalidationsTest-"+name, 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+			return &ErrNotFound{Msg: "not found"}
+		}), &GroupOptions{Peers: peers.Self(name), PushInvalidations: 8})
+		defer DeregisterGroup("pushInvalidationsTest-" + name)
+		peers.Add(name, g)
+		groups[name] = g
+	}
+
+	// Find a key a owns.
+	var key string
+	for i := 0; key == ""; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		if _, ok := peers.Self("a").PickPeer(k); !ok {
+			key = k
+		}
+	}
+
+	ctx := context.Background()
+	// d keeps a hot copy of what it set through the owner.
+	if err := groups["d"].Set(ctx, key, []byte("v1"), true); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"b", "c"} {
+		var s string
+		if err := groups[name].Get(ctx, key, StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+		if s != "v1" {
+			t.Fatalf("%s got %q; want v1", name, s)
+		}
+		if !groups[name].hotCache.contains(key) {
+			t.Fatalf("expected %s to keep a hot copy of the key", name)
+		}
+	}
+
+	if err := groups["a"].Set(ctx, key, []byte("v2"), false); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"b", "c"} {
+		if groups[name].Contains(key) {
+			t.Errorf("expected %s to have dropped the key", name)
+		}
+		var s string
+		if err := groups[name].Get(ctx, key, StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+		if s != "v2" {
+			t.Errorf("%s got %q after Set; want v2", name, s)
+		}
+	}
+	if groups["d"].hotCache.contains(key) {
+		t.Error("expected d, which set the key through its owner, to have dropped its copy")
+	}
+
+	// The latest fetchers are invalidated by a Remove through a peer.
+	if err := groups["d"].Remove(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	for name, g := range groups {
+		if g.Contains(key) {
+			t.Errorf("expected %s to have dropped the key", name)
+		}
+	}
+
+	// A key whose fetchers the owner forgot is removed from every peer.
+	if err := groups["a"].Set(ctx, key, []byte("v3"), false); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"b", "c"} {
+		if _, err := groups[name].GetString(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	groups["a"].fetchers.take(key)
+	if err := groups["a"].Remove(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	for name, g := range groups {
+		if g.Contains(key) {
+			t.Errorf("expected %s to have dropped the untracked key", name)
+		}
+	}
+}
+
+func TestIsLocal(t *testing.T) {
+	peers := NewInProcessPicker()
+	var loads int32
+	groups := map[string]*Group{}
+	for _, name := range []string{"a", "b", "c"} {
+		g := NewGroupWithOptions("isLocalTest-"+name, 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+			atomic.AddInt32(&loads, 1)
+			return dest.SetString("value")
+		}), &GroupOptions{Peers: peers.Self(name)})
+		defer DeregisterGroup("isLocalTest-" + name)
+		peers.Add(name, g)
+		groups[name] = g
+	}
+
+	ring := consistenthash.New(defaultReplicas, nil)
+	ring.Add("a", "b", "c")
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owner := ring.Get(key)
+		for name, g := range groups {
+			if got := g.IsLocal(key); got != (name == owner) {
+				t.Errorf("%s: IsLocal(%q) = %v; %s owns it", name, key, got, owner)
+			}
+		}
+	}
+	if n := atomic.LoadInt32(&loads); n != 0 {
+		t.Errorf("IsLocal made %d loads", n)
+	}
+	for name, g := range groups {
+		if n := g.Stats.Gets.Get() + g.Stats.ServerRequests.Get(); n != 0 {
+			t.Errorf("%s: IsLocal made %d gets", name, n)
+		}
+	}
+}
+
+func TestOwnerCacheHit(t *testing.T) {
+	peers := NewInProcessPicker()
+	groups := map[string]*Group{}
+	for _, name := range []string{"a", "b"} {
+		g := NewGroupWithOptions("ownerCacheHitTest-"+name, 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+			return dest.SetString("value:" + key)
+		}), &GroupOptions{Peers: peers.Self(name)})
+		defer DeregisterGroup("ownerCacheHitTest-" + name)
+		peers.Add(name, g)
+		groups[name] = g
+	}
+	var key string
+	for i := 0; key == ""; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		if owner, ok := peers.Self("a").PickPeer(k); ok && owner.GetURL() == "b" {
+			key = k
+		}
+	}
+
+	a, b := groups["a"], groups["b"]
+	fetch := func() GetInfo {
+		t.Helper()
+		// Drop a's hot copy so that the Get goes to the owner.
+		a.hotCache.remove(key)
+		var s string
+		info, err := a.GetWithInfo(context.Background(), key, StringSink(&s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+	if info := fetch(); info.OwnerCacheHit {
+		t.Error("the first fetch was reported as an owner cache hit; want an owner load")
+	}
+	if info := fetch(); !info.OwnerCacheHit {
+		t.Error("the fetch of the key the owner cached was reported as an owner load")
+	}
+	b.mainCache.remove(key)
+	if info := fetch(); info.OwnerCacheHit {
+		t.Error("the fetch after the owner dropped the key was reported as an owner cache hit")
+	}
+}
+
+func TestInvalidateTag(t *testing.T) {
+	// The tags of "eu,beta/1" are eu and beta.
+	tags := func(key string, value ByteView) []string {
+		return strings.Split(key[:strings.Index(key, "/")], ",")
+	}
+	keys := []string{"eu/1", "eu,beta/2", "us,beta/3", "us/4", "eu,us/5"}
+	peers := NewInProcessPicker()
+	groups := map[string]*Group{}
+	for _, name := range []string{"a", "b"} {
+		g := NewGroupWithOptions("invalidateTagTest-"+name, 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+			return dest.SetString("value")
+		}), &GroupOptions{Peers: peers.Self(name), Tags: tags})
+		defer DeregisterGroup("invalidateTagTest-" + name)
+		peers.Add(name, g)
+		groups[name] = g
+		for i, key := range keys {
+			cache := &g.mainCache
+			if i%2 == 1 {
+				cache = &g.hotCache
+			}
+			g.localSet(key, []byte("value"), cache)
+		}
+	}
+
+	if err := groups["a"].InvalidateTag(context.Background(), "eu"); err != nil {
+		t.Fatal(err)
+	}
+	for name, g := range groups {
+		for _, key := range keys {
+			want := !strings.Contains(key, "eu")
+			if got := g.Contains(key); got != want {
+				t.Errorf("%s: Contains(%q) = %v after invalidating eu; want %v", name, key, got, want)
+			}
+		}
+	}
+
+	// Re-adding an entry with other tags drops it from its old tags.
+	g := groups["a"]
+	g.opts.Tags = func(key string, value ByteView) []string { return []string{"other"} }
+	g.localSet("us/4", []byte("value"), &g.mainCache)
+	g.localInvalidateTag("us")
+	if !g.Contains("us/4") || g.Contains("us,beta/3") {
+		t.Error("invalidating us did not follow the entries' current tags")
+	}
+	if n := len(g.mainCache.tagged) + len(g.hotCache.tagged); n != 1 {
+		t.Errorf("%d tags are indexed; want only other", n)
+	}
+}
+
+func TestHedgeDelay(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	peers := NewInProcessPicker()
+	canceled := make(chan struct{}, 1)
+	groups := map[string]*Group{}
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		g := NewGroupWithOptions("hedgeDelayTest-"+name, 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+			// The owner is slow to load.
+			select {
+			case <-ctx.Done():
+				canceled <- struct{}{}
+				return ctx.Err()
+			case <-time.After(time.Second):
+			}
+			return dest.SetString("owner:" + name)
+		}), &GroupOptions{Peers: peers.Self(name), HedgeDelay: delay})
+		defer DeregisterGroup("hedgeDelayTest-" + name)
+		peers.Add(name, g)
+		groups[name] = g
+	}
+
+	// Find a key which c is not the owner of, and give the next peer a
+	// hot copy of it.
+	var key, replica string
+	for i := 0; key == ""; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		if picked := peers.Self("c").PickPeers(k, 2); len(picked) == 2 {
+			key, replica = k, picked[1].(*inProcessGetter).peer
+		}
+	}
+	groups[replica].localSet(key, []byte("replica:"+replica), &groups[replica].hotCache)
+
+	start := time.Now()
+	s, err := groups["c"].GetString(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); s != "replica:"+replica || elapsed > delay+200*time.Millisecond {
+		t.Errorf("hedged Get = %q after %v; want the replica's copy after about %v", s, elapsed, delay)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("the owner's load was not canceled")
+	}
+}
+
+func TestHotCacheMinFetches(t *testing.T) {
+	peers := NewInProcessPicker()
+	groups := map[string]*Group{}
+	for _, name := range []string{"a", "b"} {
+		g := NewGroupWithOptions("hotCacheMinFetchesTest-"+name, 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+			return dest.SetString("value:" + key)
+		}), &GroupOptions{Peers: peers.Self(name), HotCacheMinFetches: 3})
+		defer DeregisterGroup("hotCacheMinFetchesTest-" + name)
+		peers.Add(name, g)
+		groups[name] = g
+	}
+	a := groups["a"]
+	var remote []string
+	for i := 0; len(remote) < 2; i++ {
+		if key := fmt.Sprintf("key-%d", i); !a.IsLocal(key) {
+			remote = append(remote, key)
+		}
+	}
+	once, repeated := remote[0], remote[1]
+
+	if _, err := a.GetString(context.Background(), once); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.hotCache.peek(once); ok {
+		t.Errorf("%s entered the hot cache after one fetch", once)
+	}
+	for i := 1; i <= 3; i++ {
+		if _, err := a.GetString(context.Background(), repeated); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := a.hotCache.peek(repeated); ok != (i == 3) {
+			t.Errorf("after %d fetches, %s is in the hot cache: %v; want %v", i, repeated, ok, i == 3)
+		}
+	}
+	if s := a.Stats.Snapshot(); s.HotCachePromotions != 1 || s.HotCacheSkips != 3 {
+		t.Errorf("%d promotions and %d skips; want 1 and 3", s.HotCachePromotions, s.HotCacheSkips)
+	}
+}
+
+func TestHotCacheRate(t *testing.T) {
+	peers := NewInProcessPicker()
+	groups := map[string]*Group{}
+	draws := []float64{0.7, 0.2}
+	for _, name := range []string{"a", "b"} {
+		g := NewGroupWithOptions("hotCacheRateTest-"+name, 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+			return dest.SetString("value:" + key)
+		}), &GroupOptions{Peers: peers.Self(name), HotCacheRate: 0.5, Rand: func() float64 {
+			r := draws[0]
+			draws = draws[1:]
+			return r
+		}})
+		defer DeregisterGroup("hotCacheRateTest-" + name)
+		peers.Add(name, g)
+		groups[name] = g
+	}
+	a := groups["a"]
+	var key string
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprintf("key-%d", i); !a.IsLocal(k) {
+			key = k
+		}
+	}
+	// The first draw is above the rate and the second below it.
+	for i, want := range []bool{false, true} {
+		if _, err := a.GetString(context.Background(), key); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := a.hotCache.peek(key); ok != want {
+			t.Errorf("after fetch %d, %s is in the hot cache: %v; want %v", i+1, key, ok, want)
+		}
+	}
+}
+
+func TestOnAccess(t *testing.T) {
+	type userKey struct{}
+	clock := newFakeClock()
+	peers := NewInProcessPicker()
+	var events []AccessEvent
+	groups := map[string]*Group{}
+	for _, name := range []string{"a", "b"} {
+		opts := &GroupOptions{Peers: peers.Self(name), Clock: clock.Now}
+		if name == "a" {
+			opts.OnAccess = func(e AccessEvent) { events = append(events, e) }
+		}
+		g := NewGroupWithOptions("onAccessTest-"+name, 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+			if strings.HasPrefix(key, "missing") {
+				return &ErrNotFound{Msg: "not found"}
+			}
+			return dest.SetString("value:" + key)
+		}), opts)
+		defer DeregisterGroup("onAccessTest-" + name)
+		peers.Add(name, g)
+		groups[name] = g
+	}
+	a := groups["a"]
+	var local, remote, missing string
+	for i := 0; local == "" || remote == "" || missing == ""; i++ {
+		if key := fmt.Sprintf("key-%d", i); a.IsLocal(key) && local == "" {
+			local = key
+		} else if !a.IsLocal(key) && remote == "" {
+			remote = key
+		}
+		if key := fmt.Sprintf("missing-%d", i); a.IsLocal(key) && missing == "" {
+			missing = key
+		}
+	}
+
+	ctx := context.WithValue(context.Background(), userKey{}, "alice")
+	start := clock.Now()
+	for _, key := range []string{remote, remote, local, local, missing} {
+		clock.Advance(time.Second)
+		a.GetString(ctx, key)
+	}
+	if _, err := a.GetInto(ctx, local, make([]byte, 64)); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		key    string
+		source AccessSource
+		hit    bool
+	}{
+		{remote, PeerAccess, false},
+		{remote, HotCacheAccess, true},
+		{local, LoadAccess, false},
+		{local, MainCacheAccess, true},
+		{missing, LoadAccess, false},
+		{local, MainCacheAccess, true},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("%d events; want %d", len(events), len(want))
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.Key != w.key || e.Source != w.source || e.Hit != w.hit {
+			t.Errorf("event %d is of %s from %v with hit %v; want %s from %v with hit %v",
+				i, e.Key, e.Source, e.Hit, w.key, w.source, w.hit)
+		}
+		if user := e.Context.Value(userKey{}); user != "alice" {
+			t.Errorf("event %d has user %v; want alice", i, user)
+		}
+		// The clock stands still for the GetInto.
+		if wantTime := start.Add(time.Duration(i+1) * time.Second); i < 5 && !e.Time.Equal(wantTime) {
+			t.Errorf("event %d at %v; want %v", i, e.Time, wantTime)
+		}
+		if isMissing := w.key == missing; isMissing != errors.Is(e.Err, &ErrNotFound{}) {
+			t.Errorf("event %d has error %v", i, e.Err)
+		}
+	}
+}
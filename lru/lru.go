@@ -86,6 +86,18 @@ func (c *Cache) Get(key Key) (value interface{}, ok bool) {
 	return
 }
 
+// Peek looks up a key's value from the cache without marking it as
+// visited, so it does not affect which entry is evicted next.
+func (c *Cache) Peek(key Key) (value interface{}, ok bool) {
+	if c.cache == nil {
+		return
+	}
+	if ele, hit := c.cache[key]; hit {
+		return ele.Value.(*entry).value, true
+	}
+	return
+}
+
 // Remove removes the provided key from the cache.
 func (c *Cache) Remove(key Key) {
 	if c.cache == nil {
@@ -98,25 +110,78 @@ func (c *Cache) Remove(key Key) {
 
 // RemoveOldest removes the oldest item from the cache.
 func (c *Cache) RemoveOldest() {
-	if c.cache == nil {
+	ele := c.victim()
+	if ele == nil {
+		return
+	}
+	c.ptr = ele.Prev()
+	c.removeElement(ele)
+}
+
+// Victim returns the entry RemoveOldest would evict next without
+// removing it. Like RemoveOldest, it moves the eviction hand past any
+// visited entries, clearing their visited flag.
+func (c *Cache) Victim() (key Key, value interface{}, ok bool) {
+	ele := c.victim()
+	if ele == nil {
 		return
 	}
+	kv := ele.Value.(*entry)
+	return kv.key, kv.value, true
+}
+
+// victim moves the eviction hand to the next unvisited entry, wrapping
+// around from the front of the list to the back.
+func (c *Cache) victim() *list.Element {
+	if c.cache == nil || c.ll.Len() == 0 {
+		return nil
+	}
 	ele := c.ptr
 	if ele == nil {
 		ele = c.ll.Back()
 	}
-	for ele != nil && ele.Value.(*entry).visited {
+	for ele.Value.(*entry).visited {
 		ele.Value.(*entry).visited = false
-		ele = ele.Prev()
+		if ele = ele.Prev(); ele == nil {
+			ele = c.ll.Back()
+		}
 	}
-	if ele == nil {
-		println("Error: RemoveOldest cannot find element")
+	c.ptr = ele
+	return ele
+}
+
+// EachFromHand calls fn with each entry in the order the eviction hand
+// reaches them, starting at the entry it is on, until fn returns false.
+// visited is whether the hand would pass the entry over once. Unlike
+// Victim, it neither moves the hand nor clears visited flags. The cache
+// must not be modified by fn.
+func (c *Cache) EachFromHand(fn func(key Key, value interface{}, visited bool) bool) {
+	if c.cache == nil || c.ll.Len() == 0 {
+		return
+	}
+	start := c.ptr
+	if start == nil {
+		start = c.ll.Back()
+	}
+	ele := start
+	for {
+		kv := ele.Value.(*entry)
+		if !fn(kv.key, kv.value, kv.visited) {
+			return
+		}
+		if ele = ele.Prev(); ele == nil {
+			ele = c.ll.Back()
+		}
+		if ele == start {
+			return
+		}
 	}
-	c.ptr = ele.Prev()
-	c.removeElement(ele)
 }
 
 func (c *Cache) removeElement(e *list.Element) {
+	if c.ptr == e {
+		c.ptr = e.Prev()
+	}
 	c.ll.Remove(e)
 	kv := e.Value.(*entry)
 	delete(c.cache, kv.key)
@@ -125,6 +190,35 @@ func (c *Cache) removeElement(e *list.Element) {
 	}
 }
 
+// Each calls fn for each entry from the most to the least recently
+// added, stopping early if fn returns false. It does not mark entries
+// as visited. The cache must not be modified by fn.
+func (c *Cache) Each(fn func(key Key, value interface{}) bool) {
+	if c.cache == nil {
+		return
+	}
+	for e := c.ll.Front(); e != nil; e = e.Next() {
+		kv := e.Value.(*entry)
+		if !fn(kv.key, kv.value) {
+			return
+		}
+	}
+}
+
+// EachOldest is like Each but visits the entries from the least to the
+// most recently added.
+func (c *Cache) EachOldest(fn func(key Key, value interface{}) bool) {
+	if c.cache == nil {
+		return
+	}
+	for e := c.ll.Back(); e != nil; e = e.Prev() {
+		kv := e.Value.(*entry)
+		if !fn(kv.key, kv.value) {
+			return
+		}
+	}
+}
+
 // Len returns the number of items in the cache.
 func (c *Cache) Len() int {
 	if c.cache == nil {
@@ -143,4 +237,5 @@ func (c *Cache) Clear() {
 	}
 	c.ll = nil
 	c.cache = nil
+	c.ptr = nil
 }
@@ -95,3 +95,123 @@ func TestEvict(t *testing.T) {
 		t.Fatalf("got %v in second evicted key; want %s", evictedKeys[1], "myKey1")
 	}
 }
+
+func TestPeek(t *testing.T) {
+	lru := New(2)
+	lru.Add("myKey1", 1)
+	lru.Add("myKey2", 2)
+	if val, ok := lru.Peek("myKey1"); !ok || val != 1 {
+		t.Fatalf("Peek returned %v, %v; want 1, true", val, ok)
+	}
+	if _, ok := lru.Peek("nonsense"); ok {
+		t.Fatal("Peek returned a match for a missing key")
+	}
+
+	// Peek must not protect myKey1 from eviction like Get would.
+	lru.Add("myKey3", 3)
+	if _, ok := lru.Peek("myKey1"); ok {
+		t.Fatal("expected myKey1 to be evicted after Peek")
+	}
+}
+
+func TestVictim(t *testing.T) {
+	lru := New(0)
+	if _, _, ok := lru.Victim(); ok {
+		t.Fatal("expected no victim in an empty cache")
+	}
+	lru.Add("myKey1", 1)
+	lru.Add("myKey2", 2)
+	lru.Add("myKey3", 3)
+
+	// Visiting every entry makes the hand wrap around.
+	for _, key := range []string{"myKey1", "myKey2", "myKey3"} {
+		lru.Get(key)
+	}
+	key, val, ok := lru.Victim()
+	if !ok || key != Key("myKey1") || val != 1 {
+		t.Fatalf("Victim returned %v, %v, %v; want myKey1, 1, true", key, val, ok)
+	}
+	if lru.Len() != 3 {
+		t.Fatalf("Victim must not remove entries; got %d entries", lru.Len())
+	}
+
+	lru.RemoveOldest()
+	if _, ok := lru.Peek("myKey1"); ok {
+		t.Fatal("expected RemoveOldest to evict the reported victim")
+	}
+
+	// Removing the entry under the hand must not break eviction.
+	key, _, _ = lru.Victim()
+	lru.Remove(key)
+	lru.RemoveOldest()
+	if lru.Len() != 0 {
+		t.Fatalf("got %d entries; want 0", lru.Len())
+	}
+}
+
+func TestEachFromHand(t *testing.T) {
+	lru := New(0)
+	lru.Add("myKey1", 1)
+	lru.Add("myKey2", 2)
+	lru.Add("myKey3", 3)
+	lru.Get("myKey1")
+
+	var keys []Key
+	var visited []bool
+	for i := 0; i < 2; i++ {
+		keys, visited = nil, nil
+		lru.EachFromHand(func(key Key, _ interface{}, v bool) bool {
+			keys = append(keys, key)
+			visited = append(visited, v)
+			return true
+		})
+	}
+	// EachFromHand leaves the hand and visited flags alone, so a second
+	// walk sees the same order.
+	if fmt.Sprint(keys) != "[myKey1 myKey2 myKey3]" || fmt.Sprint(visited) != "[true false false]" {
+		t.Fatalf("EachFromHand walked %v %v", keys, visited)
+	}
+	if key, _, _ := lru.Victim(); key != Key("myKey2") {
+		t.Fatalf("Victim = %v; want myKey2", key)
+	}
+}
+
+func TestEach(t *testing.T) {
+	lru := New(0)
+	for i := 0; i < 5; i++ {
+		lru.Add(fmt.Sprintf("myKey%d", i), i)
+	}
+
+	var keys []Key
+	lru.Each(func(key Key, value interface{}) bool {
+		keys = append(keys, key)
+		return len(keys) < 3
+	})
+	want := []Key{"myKey4", "myKey3", "myKey2"}
+	if fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Fatalf("Each visited %v; want %v", keys, want)
+	}
+
+	// Each must not protect entries from eviction.
+	lru.RemoveOldest()
+	if _, ok := lru.Peek("myKey0"); ok {
+		t.Fatal("expected myKey0 to be evicted after Each")
+	}
+}
+
+func TestEachOldest(t *testing.T) {
+	lru := New(0)
+	for i := 0; i < 5; i++ {
+		lru.Add(fmt.Sprintf("myKey%d", i), i)
+	}
+
+	var keys []Key
+	lru.EachOldest(func(key Key, value interface{}) bool {
+		keys = append(keys, key)
+		return len(keys) < 3
+	})
+	want := []Key{"myKey0", "myKey1", "myKey2"}
+	if fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Fatalf("EachOldest visited %v; want %v", keys, want)
+	}
+}
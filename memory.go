@@ -0,0 +1,120 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+// memoryMu guards the groups of every MemoryCoordinator and the memory
+// field of every Group.
+var memoryMu sync.Mutex
+
+// A MemoryCoordinator caps the sum of the cacheBytes of the groups added
+// to it. While the groups ask for more than the limit in total, each of
+// them is given a share of the limit in proportion to what it asks for,
+// and evicts entries to fit in it; once they fit again, each gets back
+// what it asked for.
+type MemoryCoordinator struct {
+	// limit is accessed atomically.
+	limit  int64
+	groups map[*Group]bool
+}
+
+// NewMemoryCoordinator creates a MemoryCoordinator capping its groups
+// to limit bytes in total. A limit of zero or less sets no cap.
+func NewMemoryCoordinator(limit int64) *MemoryCoordinator {
+	return &MemoryCoordinator{limit: limit, groups: make(map[*Group]bool)}
+}
+
+// Limit returns the cap on the cacheBytes of the groups of c.
+func (c *MemoryCoordinator) Limit() int64 {
+	return atomic.LoadInt64(&c.limit)
+}
+
+// SetLimit changes the cap on the cacheBytes of the groups of c,
+// evicting entries right away if they no longer fit.
+func (c *MemoryCoordinator) SetLimit(n int64) {
+	atomic.StoreInt64(&c.limit, n)
+	c.rebalance()
+}
+
+// Add makes g share the limit of c, moving it from the MemoryCoordinator
+// it was added to before, if any.
+func (c *MemoryCoordinator) Add(g *Group) {
+	memoryMu.Lock()
+	old := g.memory
+	if old != nil {
+		delete(old.groups, g)
+	}
+	g.memory = c
+	c.groups[g] = true
+	memoryMu.Unlock()
+	if old != nil && old != c {
+		old.rebalance()
+	}
+	c.rebalance()
+}
+
+// Remove gives g back the whole cacheBytes it asks for, if it was added
+// to c. Groups stay in c until removed, even after DeregisterGroup.
+func (c *MemoryCoordinator) Remove(g *Group) {
+	memoryMu.Lock()
+	if g.memory != c {
+		memoryMu.Unlock()
+		return
+	}
+	delete(c.groups, g)
+	g.memory = nil
+	atomic.StoreInt64(&g.cacheBytes, atomic.LoadInt64(&g.wantBytes))
+	memoryMu.Unlock()
+	c.rebalance()
+}
+
+// rebalance sets the limit of every group of c from its share of the
+// limit of c, then evicts what no longer fits.
+func (c *MemoryCoordinator) rebalance() {
+	memoryMu.Lock()
+	limit := c.Limit()
+	var total uint64
+	for g := range c.groups {
+		if n := atomic.LoadInt64(&g.wantBytes); n > 0 {
+			total += uint64(n)
+		}
+	}
+	groups := make([]*Group, 0, len(c.groups))
+	for g := range c.groups {
+		n := atomic.LoadInt64(&g.wantBytes)
+		if limit > 0 && n > 0 && total > uint64(limit) {
+			// n*limit/total rounded down, so that the shares add up to no
+			// more than limit. It can't overflow since n <= total.
+			hi, lo := bits.Mul64(uint64(n), uint64(limit))
+			share, _ := bits.Div64(hi, lo, total)
+			n = int64(share)
+		}
+		atomic.StoreInt64(&g.cacheBytes, n)
+		groups = append(groups, g)
+	}
+	memoryMu.Unlock()
+	// Evict outside of memoryMu, so that OnEvict functions may use any
+	// MemoryCoordinator.
+	for _, g := range groups {
+		g.evict()
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xdbbe/groupcache/v2/lru"
+)
+
+// maxTombstones bounds how many keys a tombstones remembers, so that
+// looking up many missing keys costs a bounded amount of memory.
+const maxTombstones = 1 << 16
+
+// tombstones remembers the keys whose load failed with ErrNotFound, see
+// GroupOptions.NegativeTTL.
+type tombstones struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu   sync.Mutex
+	keys *lru.Cache // of tombstone
+}
+
+type tombstone struct {
+	err    error
+	expire time.Time
+}
+
+func newTombstones(ttl time.Duration, now func() time.Time) *tombstones {
+	return &tombstones{ttl: ttl, now: now, keys: lru.New(maxTombstones)}
+}
+
+// get returns the error key was not found with, or nil if it has no
+// tombstone or its tombstone expired.
+func (t *tombstones) get(key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	v, ok := t.keys.Get(key)
+	if !ok {
+		return nil
+	}
+	ts := v.(tombstone)
+	if !t.now().Before(ts.expire) {
+		t.keys.Remove(key)
+		return nil
+	}
+	return ts.err
+}
+
+// add records that key was not found with err, for the TTL.
+func (t *tombstones) add(key string, err error) {
+	expire := t.now().Add(t.ttl)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.keys.Add(key, tombstone{err: err, expire: expire})
+}
+
+// forget drops the tombstone of key, such as when it is set or removed.
+func (t *tombstones) forget(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.keys.Remove(key)
+}
+
+// forgetPrefix drops the tombstones of every key with prefix.
+func (t *tombstones) forgetPrefix(prefix string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var keys []lru.Key
+	t.keys.Each(func(key lru.Key, _ interface{}) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	for _, key := range keys {
+		t.keys.Remove(key)
+	}
+}
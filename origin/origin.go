@@ -0,0 +1,110 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package origin provides Getters which load values from the system of
+// record a group caches.
+package origin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/xdbbe/groupcache/v2"
+)
+
+// HTTPOriginGetter is a Getter which loads each key with a GET request to
+// an HTTP origin and fills the Sink with the response body. A 404 fails
+// the load with ErrNotFound, so that the group treats the key as missing;
+// other statuses but 200 fail it with an error holding the status.
+//
+// Its fields must not be changed once it is in use.
+type HTTPOriginGetter struct {
+	// BaseURL is the URL the path of each key is appended to, such as
+	// "http://origin.example.com/v1".
+	BaseURL string
+
+	// Path returns the path of key, relative to BaseURL. If nil, the
+	// path is "/" and the key, escaped as a path segment.
+	Path func(key string) string
+
+	// Client sends the requests. It is shared by every load, so that
+	// connections to the origin are reused, and its Timeout bounds each
+	// load along with the context of the Get. If nil, http.DefaultClient
+	// is used.
+	Client *http.Client
+
+	// MaxResponseBytes, if positive, fails loads whose body is larger
+	// with groupcache.ErrResponseTooLarge instead of reading it whole.
+	MaxResponseBytes int64
+}
+
+func (o *HTTPOriginGetter) Get(ctx context.Context, key string, dest groupcache.Sink) error {
+	path := "/" + url.PathEscape(key)
+	if o.Path != nil {
+		path = o.Path(key)
+	}
+	u := strings.TrimSuffix(o.BaseURL, "/") + "/" + strings.TrimPrefix(path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		// Drain the body so that the connection is reused.
+		io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+		return &groupcache.ErrNotFound{Msg: fmt.Sprintf("origin: %s not found", u)}
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("origin returned: %v, %s", res.Status, msg)
+	}
+
+	body := io.Reader(res.Body)
+	if o.MaxResponseBytes > 0 {
+		if res.ContentLength > o.MaxResponseBytes {
+			return o.tooLarge(u)
+		}
+		body = io.LimitReader(res.Body, o.MaxResponseBytes+1)
+	}
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("reading response body: %v", err)
+	}
+	if o.MaxResponseBytes > 0 && int64(len(b)) > o.MaxResponseBytes {
+		return o.tooLarge(u)
+	}
+	return dest.SetBytes(b)
+}
+
+func (o *HTTPOriginGetter) tooLarge(u string) error {
+	return &groupcache.ErrResponseTooLarge{
+		Msg: fmt.Sprintf("origin: response from %s exceeds %d bytes", u, o.MaxResponseBytes),
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package origin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/xdbbe/groupcache/v2"
+)
+
+func TestHTTPOriginGetter(t *testing.T) {
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch key := strings.TrimPrefix(r.URL.Path, "/v1/items/"); key {
+		case "missing":
+			http.NotFound(w, r)
+		case "broken":
+			http.Error(w, "origin is down", http.StatusInternalServerError)
+		default:
+			fmt.Fprintf(w, "value:%s", key)
+		}
+	}))
+	var conns int32
+	origin.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	origin.Start()
+	defer origin.Close()
+
+	getter := &HTTPOriginGetter{
+		BaseURL:          origin.URL + "/v1/",
+		Path:             func(key string) string { return "items/" + key },
+		Client:           origin.Client(),
+		MaxResponseBytes: 16,
+	}
+	g := groupcache.NewGroupWithOptions("httpOriginTest", 1<<20, getter, &groupcache.GroupOptions{LocalOnly: true})
+	defer groupcache.DeregisterGroup("httpOriginTest")
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		s, err := g.GetString(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "value:" + key; s != want {
+			t.Errorf("GetString(%q) = %q; want %q", key, s, want)
+		}
+	}
+	if _, err := g.GetString(ctx, "missing"); !errors.Is(err, &groupcache.ErrNotFound{}) {
+		t.Errorf("GetString of a key the origin doesn't have = %v; want ErrNotFound", err)
+	}
+	if _, err := g.GetString(ctx, "broken"); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("GetString of a key the origin fails = %v; want an error with the status", err)
+	}
+	if _, err := g.GetString(ctx, "much-too-long-a-key"); !errors.Is(err, &groupcache.ErrResponseTooLarge{}) {
+		t.Errorf("GetString of a value above MaxResponseBytes = %v; want ErrResponseTooLarge", err)
+	}
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Errorf("the loads opened %d connections to the origin; want 1", n)
+	}
+}
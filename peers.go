@@ -33,6 +33,50 @@ type ProtoGetter interface {
 	GetURL() string
 }
 
+// KeyLister is an optional interface implemented by a ProtoGetter which
+// can list the keys a peer holds in its main cache.
+type KeyLister interface {
+	Keys(context context.Context, in *pb.KeysRequest, out *pb.KeysResponse) error
+}
+
+// PrefixRemover is an optional interface implemented by a ProtoGetter
+// which can remove every key starting with in.Key from a peer.
+type PrefixRemover interface {
+	RemovePrefix(context context.Context, in *pb.GetRequest) error
+}
+
+// TagInvalidator is an optional interface implemented by a ProtoGetter
+// which can remove every entry tagged with in.Key from a peer, see
+// Group.InvalidateTag.
+type TagInvalidator interface {
+	InvalidateTag(context context.Context, in *pb.GetRequest) error
+}
+
+// PeerIdentifier is an optional interface implemented by a ProtoGetter
+// which can name the peer it talks to. The name is added to errors and
+// logs about failed requests to the peer. Peers which don't implement it
+// are named by their GetURL.
+type PeerIdentifier interface {
+	PeerID() string
+}
+
+func peerID(peer ProtoGetter) string {
+	if p, ok := peer.(PeerIdentifier); ok {
+		return p.PeerID()
+	}
+	return peer.GetURL()
+}
+
+// MultiGetter is an optional interface implemented by a ProtoGetter
+// which can fetch many keys of a group from a peer in one request. It
+// calls fn with each key as its response arrives, with ErrNotFound or
+// ErrRemoteCall if the peer could not load it. If the request fails
+// part way it returns the error, and fn is not called for the keys
+// which were not answered.
+type MultiGetter interface {
+	GetMulti(context context.Context, in *pb.GetMultiRequest, fn func(key string, out *pb.GetResponse, err error)) error
+}
+
 // PeerPicker is the interface that must be implemented to locate
 // the peer that owns a specific key.
 type PeerPicker interface {
@@ -40,10 +84,21 @@ type PeerPicker interface {
 	// and true to indicate that a remote peer was nominated.
 	// It returns nil, false if the key owner is the current peer.
 	PickPeer(key string) (peer ProtoGetter, ok bool)
-	// GetAll returns all the peers in the group
+	// GetAll returns all the peers in the group other than the
+	// current peer, which the group serves locally.
 	GetAll() []ProtoGetter
 }
 
+// ReplicaPicker is an optional interface implemented by a PeerPicker
+// which can pick the peers following the owner of a key on the ring,
+// see GroupOptions.HedgeDelay.
+type ReplicaPicker interface {
+	// PickPeers returns up to n peers for key: the one PickPeer picks,
+	// then the next ones on the ring other than the current peer. It
+	// returns nil if the current peer owns the key.
+	PickPeers(key string, n int) []ProtoGetter
+}
+
 // NoPeers is an implementation of PeerPicker that never finds a peer.
 type NoPeers struct{}
 
@@ -0,0 +1,128 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import "container/heap"
+
+// An EvictionPolicy chooses which entry a cache evicts next, replacing
+// the default, which evicts the least recently used entry. The main and
+// hot caches of a group can each have their own, see
+// GroupOptions.MainCachePolicy and GroupOptions.HotCachePolicy.
+//
+// The cache calls the methods with its lock held, so they need not be
+// safe for concurrent use but must not call back into the Group. An
+// EvictionPolicy must not be shared between caches.
+type EvictionPolicy interface {
+	// Added is called when key is added to the cache, or replaced.
+	Added(key string)
+
+	// Accessed is called when key is read from the cache.
+	Accessed(key string)
+
+	// Removed is called when key leaves the cache for any reason.
+	Removed(key string)
+
+	// Victim returns the key to evict next, which must be in the
+	// cache, or false if the cache is empty.
+	Victim() (key string, ok bool)
+}
+
+// NewLFUPolicy returns an EvictionPolicy which evicts the least
+// frequently accessed entry, and of those the least recently added one.
+// Unlike the default policy, an entry read often long ago outlives one
+// read once just now, so it suits caches of steadily popular keys.
+func NewLFUPolicy() EvictionPolicy {
+	return &lfuPolicy{entries: make(map[string]*lfuEntry)}
+}
+
+type lfuEntry struct {
+	key   string
+	count int64
+	seq   int64 // when the entry was added, to break ties
+	index int   // in the heap
+}
+
+// lfuPolicy keeps the entries in a min-heap by access count.
+type lfuPolicy struct {
+	entries map[string]*lfuEntry
+	heap    lfuHeap
+	seq     int64
+}
+
+func (p *lfuPolicy) Added(key string) {
+	if e, ok := p.entries[key]; ok {
+		e.count++
+		heap.Fix(&p.heap, e.index)
+		return
+	}
+	p.seq++
+	e := &lfuEntry{key: key, seq: p.seq}
+	p.entries[key] = e
+	heap.Push(&p.heap, e)
+}
+
+func (p *lfuPolicy) Accessed(key string) {
+	if e, ok := p.entries[key]; ok {
+		e.count++
+		heap.Fix(&p.heap, e.index)
+	}
+}
+
+func (p *lfuPolicy) Removed(key string) {
+	if e, ok := p.entries[key]; ok {
+		heap.Remove(&p.heap, e.index)
+		delete(p.entries, key)
+	}
+}
+
+func (p *lfuPolicy) Victim() (string, bool) {
+	if len(p.heap) == 0 {
+		return "", false
+	}
+	return p.heap[0].key, true
+}
+
+type lfuHeap []*lfuEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	e := x.(*lfuEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
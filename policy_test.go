@@ -0,0 +1,83 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCachePolicies(t *testing.T) {
+	g := newGroupOpts("cachePoliciesTest", 1<<20, nil, NoPeers{}, &GroupOptions{
+		HotCachePolicy: NewLFUPolicy(),
+	})
+
+	for _, c := range []*cache{&g.mainCache, &g.hotCache} {
+		for i := 0; i < 4; i++ {
+			c.add(fmt.Sprintf("key-%d", i), ByteView{s: "value"})
+		}
+		// key-0 is popular but was last read before the others.
+		for i := 0; i < 5; i++ {
+			c.get("key-0")
+		}
+		c.get("key-1")
+		c.get("key-2")
+		c.get("key-3")
+		c.removeOldest()
+		c.removeOldest()
+	}
+
+	// The main cache evicts by recency: every entry was read since the
+	// hand last passed, so it evicts the first two entries it reaches.
+	for key, want := range map[string]bool{"key-0": false, "key-1": false, "key-2": true, "key-3": true} {
+		if got := g.mainCache.contains(key); got != want {
+			t.Errorf("main cache contains %s = %v; want %v", key, got, want)
+		}
+	}
+	// The hot cache evicts the least frequently read entries.
+	for key, want := range map[string]bool{"key-0": true, "key-1": false, "key-2": false, "key-3": true} {
+		if got := g.hotCache.contains(key); got != want {
+			t.Errorf("hot cache contains %s = %v; want %v", key, got, want)
+		}
+	}
+}
+
+func TestLFUPolicy(t *testing.T) {
+	p := NewLFUPolicy()
+	if _, ok := p.Victim(); ok {
+		t.Error("expected no victim in an empty policy")
+	}
+	p.Added("a")
+	p.Added("b")
+	p.Added("c")
+	p.Accessed("a")
+	p.Accessed("a")
+	p.Accessed("b")
+
+	var got []string
+	for {
+		key, ok := p.Victim()
+		if !ok {
+			break
+		}
+		got = append(got, key)
+		p.Removed(key)
+	}
+	if want := "[c b a]"; fmt.Sprint(got) != want {
+		t.Errorf("evicted %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"bytes"
+	"io"
+	"sync/atomic"
+)
+
+// A BufferPool receives buffers which are no longer referenced, for
+// example to put them back into a sync.Pool.
+type BufferPool interface {
+	Put(b []byte)
+}
+
+// A PooledByteView is a reference counted view of a buffer which is
+// returned to a BufferPool once every reference has been released.
+//
+// Unlike a ByteView, a PooledByteView is used as a pointer. Reader and
+// Slice take a new reference, and every reference, including the one
+// returned by NewPooledByteView, must be released exactly once. Bytes
+// obtained from a released view must not be used.
+type PooledByteView struct {
+	buf      *pooledBuffer
+	b        []byte
+	released int32
+}
+
+type pooledBuffer struct {
+	b    []byte
+	refs int32
+	pool BufferPool
+}
+
+// NewPooledByteView returns a view of b holding a single reference.
+// b is handed to pool when the last reference is released.
+func NewPooledByteView(b []byte, pool BufferPool) *PooledByteView {
+	buf := &pooledBuffer{b: b, refs: 1, pool: pool}
+	return &PooledByteView{buf: buf, b: b}
+}
+
+func (v *PooledByteView) ref(b []byte) *PooledByteView {
+	atomic.AddInt32(&v.buf.refs, 1)
+	return &PooledByteView{buf: v.buf, b: b}
+}
+
+// Release drops the reference held by v. Releasing a view more than
+// once has no effect.
+func (v *PooledByteView) Release() {
+	if !atomic.CompareAndSwapInt32(&v.released, 0, 1) {
+		return
+	}
+	if atomic.AddInt32(&v.buf.refs, -1) == 0 && v.buf.pool != nil {
+		v.buf.pool.Put(v.buf.b)
+	}
+}
+
+// Len returns the view's length.
+func (v *PooledByteView) Len() int {
+	return len(v.b)
+}
+
+// ByteSlice returns a copy of the data as a byte slice. The copy
+// remains valid after the view is released.
+func (v *PooledByteView) ByteSlice() []byte {
+	return cloneBytes(v.b)
+}
+
+// View returns a ByteView sharing the pooled buffer. The ByteView must
+// not be used after v is released.
+func (v *PooledByteView) View() ByteView {
+	return ByteView{b: v.b}
+}
+
+// Slice returns a new reference to the bytes between the provided from
+// and to indices.
+func (v *PooledByteView) Slice(from, to int) *PooledByteView {
+	return v.ref(v.b[from:to])
+}
+
+// Reader returns an io.ReadSeekCloser for the bytes in v holding a new
+// reference, which is released by Close.
+func (v *PooledByteView) Reader() io.ReadSeekCloser {
+	r := v.ref(v.b)
+	return &pooledReader{Reader: bytes.NewReader(r.b), v: r}
+}
+
+type pooledReader struct {
+	*bytes.Reader
+	v *PooledByteView
+}
+
+func (r *pooledReader) Close() error {
+	r.v.Release()
+	return nil
+}
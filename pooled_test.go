@@ -0,0 +1,66 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+type countingPool struct {
+	puts [][]byte
+}
+
+func (p *countingPool) Put(b []byte) {
+	p.puts = append(p.puts, b)
+}
+
+func TestPooledByteView(t *testing.T) {
+	pool := &countingPool{}
+	v := NewPooledByteView([]byte("hello world"), pool)
+
+	r1 := v.Reader()
+	r2 := v.Reader()
+	s := v.Slice(6, 11)
+	v.Release()
+	v.Release()
+	if len(pool.puts) != 0 {
+		t.Fatal("buffer returned to the pool while still referenced")
+	}
+
+	got, err := ioutil.ReadAll(r1)
+	if err != nil || string(got) != "hello world" {
+		t.Errorf("Reader = %q, %v; want %q", got, err, "hello world")
+	}
+	if got := s.View().String(); got != "world" {
+		t.Errorf("Slice = %q; want %q", got, "world")
+	}
+
+	r1.Close()
+	r1.Close()
+	s.Release()
+	if len(pool.puts) != 0 {
+		t.Fatal("buffer returned to the pool while still referenced")
+	}
+	r2.Close()
+	if len(pool.puts) != 1 {
+		t.Fatalf("expected the buffer to return to the pool exactly once; got %d", len(pool.puts))
+	}
+	if string(pool.puts[0]) != "hello world" {
+		t.Errorf("pool received %q; want the original buffer", pool.puts[0])
+	}
+}
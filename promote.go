@@ -0,0 +1,75 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"sync"
+
+	"github.com/xdbbe/groupcache/v2/lru"
+)
+
+// maxFetchCountKeys bounds how many keys a fetchCounter counts the
+// fetches of, so that counting costs a bounded amount of memory.
+const maxFetchCountKeys = 1 << 16
+
+// fetchCounter counts the fetches of each key from peers which did not
+// enter the hot cache yet, see GroupOptions.HotCacheMinFetches.
+type fetchCounter struct {
+	min int
+
+	mu   sync.Mutex
+	keys *lru.Cache // of int
+}
+
+func newFetchCounter(min int) *fetchCounter {
+	return &fetchCounter{min: min, keys: lru.New(maxFetchCountKeys)}
+}
+
+// add counts a fetch of key, reporting whether it reached the minimum,
+// in which case the count starts over.
+func (c *fetchCounter) add(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 1
+	if v, ok := c.keys.Get(key); ok {
+		n += v.(int)
+	}
+	if n >= c.min {
+		c.keys.Remove(key)
+		return true
+	}
+	c.keys.Add(key, n)
+	return false
+}
+
+// promote caches value, which was fetched from a peer, in the hot cache
+// if HotCacheMinFetches, HotCacheRate and the admission filter let it.
+func (g *Group) promote(key string, value ByteView) {
+	if g.fetchCounts != nil && !g.fetchCounts.add(key) {
+		g.Stats.HotCacheSkips.Add(1)
+		return
+	}
+	if r := g.opts.HotCacheRate; r > 0 && r < 1 && g.opts.Rand() >= r {
+		g.Stats.HotCacheSkips.Add(1)
+		return
+	}
+	if !g.admit(key, value) {
+		return
+	}
+	g.Stats.HotCachePromotions.Add(1)
+	g.populateCache(key, value, &g.hotCache)
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"sync"
+	"time"
+)
+
+// rateBuckets is how many seconds of events a rateCounter remembers.
+const rateBuckets = 60
+
+// rateCounter counts events in a ring of one second buckets, to report
+// their rate over a recent window.
+type rateCounter struct {
+	mu      sync.Mutex
+	buckets [rateBuckets]struct {
+		sec int64 // unix second the count is for
+		n   int64
+	}
+}
+
+func (r *rateCounter) add(now time.Time, n int64) {
+	sec := now.Unix()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := &r.buckets[sec%rateBuckets]
+	if b.sec != sec {
+		b.sec, b.n = sec, 0
+	}
+	b.n += n
+}
+
+// rate returns the events per second over the window before now, which
+// is rounded to whole seconds between 1 and rateBuckets.
+func (r *rateCounter) rate(now time.Time, window time.Duration) float64 {
+	secs := int64(window / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	if secs > rateBuckets {
+		secs = rateBuckets
+	}
+	sec := now.Unix()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for _, b := range r.buckets {
+		if b.sec > sec-secs && b.sec <= sec {
+			n += b.n
+		}
+	}
+	return float64(n) / float64(secs)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ServeValue answers r with the value of key in g. The response has a
+// strong ETag derived from a hash of the value, so a request whose
+// If-None-Match lists it is answered with 304 Not Modified and no body.
+// Keys which are not found are answered with 404 Not Found, and other
+// errors with 500 Internal Server Error. The value is written straight
+// from the cache.
+func ServeValue(w http.ResponseWriter, r *http.Request, g *Group, key string) {
+	var value ByteView
+	if err := g.Get(r.Context(), key, ByteViewSink(&value)); err != nil {
+		if errors.Is(err, &ErrNotFound{}) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	etag := `"` + g.etag(value) + `"`
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(value.Len()))
+	if r.Method == http.MethodHead {
+		return
+	}
+	value.WriteTo(w)
+}
+
+// etagMatches reports whether the If-None-Match header value list
+// includes etag, comparing weakly as RFC 9110 requires.
+func etagMatches(list, etag string) bool {
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
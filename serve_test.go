@@ -0,0 +1,82 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeValue(t *testing.T) {
+	var loads int
+	g := newGroup("serveValueTest", 1<<20, GetterFunc(func(ctx context.Context, key string, dest Sink) error {
+		loads++
+		if key == "missing" {
+			return &ErrNotFound{Msg: "not found"}
+		}
+		return dest.SetString("value:" + key)
+	}), NoPeers{})
+	serve := func(method, key, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/"+key, nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		ServeValue(w, r, g, key)
+		return w
+	}
+
+	w := serve(http.MethodGet, "key", "")
+	if w.Code != http.StatusOK || w.Body.String() != "value:key" {
+		t.Fatalf("got %d %q; want 200 value:key", w.Code, w.Body.String())
+	}
+	etag := w.Header().Get("ETag")
+	if want := `"` + (ByteView{s: "value:key"}).ETag() + `"`; etag != want {
+		t.Errorf("ETag = %s; want %s", etag, want)
+	}
+	if got := w.Header().Get("Content-Length"); got != "9" {
+		t.Errorf("Content-Length = %s; want 9", got)
+	}
+
+	for _, inm := range []string{etag, `"other", ` + etag, "W/" + etag, "*"} {
+		w = serve(http.MethodGet, "key", inm)
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: got %d with %d bytes; want 304 without a body", inm, w.Code, w.Body.Len())
+		}
+		if w.Header().Get("ETag") != etag {
+			t.Errorf("If-None-Match %s: 304 has ETag %q; want %s", inm, w.Header().Get("ETag"), etag)
+		}
+	}
+
+	w = serve(http.MethodGet, "key", `"stale"`)
+	if w.Code != http.StatusOK || w.Body.String() != "value:key" {
+		t.Errorf("stale If-None-Match: got %d %q; want 200 value:key", w.Code, w.Body.String())
+	}
+	w = serve(http.MethodHead, "key", "")
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+		t.Errorf("HEAD: got %d with %d bytes and ETag %q; want 200 with the ETag only", w.Code, w.Body.Len(), w.Header().Get("ETag"))
+	}
+	if loads != 1 {
+		t.Errorf("loaded %d times; want 1", loads)
+	}
+
+	if w = serve(http.MethodGet, "missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("missing key: got %d; want 404", w.Code)
+	}
+}
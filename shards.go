@@ -0,0 +1,142 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"sort"
+	"time"
+
+	"github.com/zeebo/xxh3"
+)
+
+// split spreads the entries of c over n shards, each with its own lock
+// and the configuration of c. Tenant budgets are divided between the
+// shards. It must be called before c is used.
+func (c *cache) split(n int) {
+	c.shards = make([]*cache, n)
+	for i := range c.shards {
+		shard := &cache{
+			onEvict:   c.onEvict,
+			evicted:   c.evicted,
+			now:       c.now,
+			maxStale:  c.maxStale,
+			slide:     c.slide,
+			evictions: c.evictions,
+			pins:      c.pins,
+			tenant:    c.tenant,
+		}
+		if budget := c.tenantBudget; budget != nil {
+			shard.tenantBudget = func(tenant string) int64 { return budget(tenant) / int64(n) }
+		}
+		c.shards[i] = shard
+	}
+}
+
+// shard returns the shard holding key.
+func (c *cache) shard(key string) *cache {
+	return c.shards[xxh3.HashString(key)%uint64(len(c.shards))]
+}
+
+// largestShard returns the shard holding the most bytes, which is the
+// one furthest over its share of the budget.
+func (c *cache) largestShard() *cache {
+	largest, most := c.shards[0], c.shards[0].bytes()
+	for _, shard := range c.shards[1:] {
+		if n := shard.bytes(); n > most {
+			largest, most = shard, n
+		}
+	}
+	return largest
+}
+
+// shardRemoveOldest evicts the next victim of the largest shard which
+// has an entry that may be evicted.
+func (c *cache) shardRemoveOldest() bool {
+	shards := append([]*cache(nil), c.shards...)
+	bytes := make(map[*cache]int64, len(shards))
+	for _, shard := range shards {
+		bytes[shard] = shard.bytes()
+	}
+	sort.Slice(shards, func(i, j int) bool { return bytes[shards[i]] > bytes[shards[j]] })
+	for _, shard := range shards {
+		if bytes[shard] > 0 && shard.removeOldest() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *cache) shardStats() CacheStats {
+	var res CacheStats
+	for _, shard := range c.shards {
+		s := shard.stats()
+		res.Bytes += s.Bytes
+		res.Items += s.Items
+		res.Gets += s.Gets
+		res.Hits += s.Hits
+		res.Evictions += s.Evictions
+	}
+	return res
+}
+
+// shardKeys returns up to limit keys, shard by shard.
+func (c *cache) shardKeys(limit int64) []string {
+	var res []string
+	for _, shard := range c.shards {
+		if limit > 0 && int64(len(res)) >= limit {
+			break
+		}
+		left := int64(0)
+		if limit > 0 {
+			left = limit - int64(len(res))
+		}
+		res = append(res, shard.keys(left)...)
+	}
+	return res
+}
+
+func (c *cache) shardAges(now time.Time) []time.Duration {
+	var res []time.Duration
+	for _, shard := range c.shards {
+		res = append(res, shard.ages(now)...)
+	}
+	return res
+}
+
+func (c *cache) shardRemoveFunc(fn func(key string) bool) int {
+	var n int
+	for _, shard := range c.shards {
+		n += shard.removeFunc(fn)
+	}
+	return n
+}
+
+func (c *cache) shardBytes() int64 {
+	var n int64
+	for _, shard := range c.shards {
+		n += shard.bytes()
+	}
+	return n
+}
+
+func (c *cache) shardItems() int64 {
+	var n int64
+	for _, shard := range c.shards {
+		n += shard.items()
+	}
+	return n
+}
@@ -28,6 +28,17 @@ type call struct {
 	wg  sync.WaitGroup
 	val interface{}
 	err error
+
+	// dups counts the callers which waited on this call, and chans
+	// holds those of DoChan, protected by Group.mu.
+	dups  int
+	chans []chan<- Result
+}
+
+// Result holds the results of a call, as DoChan gives them.
+type Result struct {
+	Val interface{}
+	Err error
 }
 
 // Group represents a class of work and forms a namespace in which
@@ -42,33 +53,84 @@ type Group struct {
 // time. If a duplicate comes in, the duplicate caller waits for the
 // original to complete and receives the same results.
 func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	v, err, _ := g.DoShared(key, fn)
+	return v, err
+}
+
+// DoShared is like Do but also reports whether the results were given
+// to more than one caller. Callers which waited on another's call always
+// get shared == true; the caller which ran fn gets true only if another
+// caller waited on it.
+func (g *Group) DoShared(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
 	g.mu.Lock()
 	if g.m == nil {
 		g.m = make(map[string]*call)
 	}
 	if c, ok := g.m[key]; ok {
+		c.dups++
 		g.mu.Unlock()
 		c.wg.Wait()
-		return c.val, c.err
+		return c.val, c.err, true
 	}
+	c := g.startLocked(key)
+	g.mu.Unlock()
+
+	shared = g.run(c, key, fn)
+	return c.val, c.err, shared
+}
+
+// DoChan is like Do, but gives the results on a channel, so that a
+// caller can stop waiting on a duplicate call. If no call of key is in
+// flight, the caller runs fn itself and the channel holds its results
+// when DoChan returns. Otherwise the channel receives the results of the
+// call in flight once it completes, even if the caller stopped waiting.
+func (g *Group) DoChan(key string, fn func() (interface{}, error)) <-chan Result {
+	ch := make(chan Result, 1)
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	if c, ok := g.m[key]; ok {
+		c.dups++
+		c.chans = append(c.chans, ch)
+		g.mu.Unlock()
+		return ch
+	}
+	c := g.startLocked(key)
+	g.mu.Unlock()
+
+	g.run(c, key, fn)
+	ch <- Result{Val: c.val, Err: c.err}
+	return ch
+}
+
+// startLocked records a new call of key, which the caller runs.
+func (g *Group) startLocked(key string) *call {
 	c := &call{
 		err: fmt.Errorf("singleflight leader panicked"),
 	}
 	c.wg.Add(1)
 	g.m[key] = c
-	g.mu.Unlock()
+	return c
+}
 
+// run runs fn for c, the call of key, and hands its results to the
+// callers waiting on it. It reports whether there were any.
+func (g *Group) run(c *call, key string, fn func() (interface{}, error)) (shared bool) {
 	defer func() {
 		c.wg.Done()
 
 		g.mu.Lock()
 		delete(g.m, key)
+		shared = c.dups > 0
+		for _, ch := range c.chans {
+			ch <- Result{Val: c.val, Err: c.err}
+		}
 		g.mu.Unlock()
 	}()
 
 	c.val, c.err = fn()
-
-	return c.val, c.err
+	return false
 }
 
 // Lock prevents single flights from occurring for the duration
@@ -149,3 +149,72 @@ func TestDoConcurrentPanic(t *testing.T) {
 		t.Errorf("number of calls = %d; want 1", got)
 	}
 }
+
+func TestDoShared(t *testing.T) {
+	var g Group
+	if _, _, shared := g.DoShared("key", func() (interface{}, error) {
+		return "bar", nil
+	}); shared {
+		t.Error("lone call reported shared results")
+	}
+
+	c := make(chan string)
+	const n = 10
+	var wg sync.WaitGroup
+	var sharedCount int32
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, shared := g.DoShared("key", func() (interface{}, error) {
+				return <-c, nil
+			})
+			if err != nil || v.(string) != "bar" {
+				t.Errorf("DoShared = %v, %v", v, err)
+			}
+			if shared {
+				atomic.AddInt32(&sharedCount, 1)
+			}
+		}()
+	}
+	time.Sleep(100 * time.Millisecond) // let goroutines above block
+	c <- "bar"
+	wg.Wait()
+	if got := atomic.LoadInt32(&sharedCount); got != n {
+		t.Errorf("%d callers reported shared results; want %d", got, n)
+	}
+}
+
+func TestDoChan(t *testing.T) {
+	var g Group
+	if r := <-g.DoChan("key", func() (interface{}, error) {
+		return "bar", nil
+	}); r.Val != "bar" || r.Err != nil {
+		t.Errorf("DoChan = %v, %v; want bar", r.Val, r.Err)
+	}
+
+	c := make(chan string)
+	led := make(chan Result)
+	go func() {
+		led <- <-g.DoChan("key", func() (interface{}, error) {
+			return <-c, nil
+		})
+	}()
+	time.Sleep(100 * time.Millisecond) // let the goroutine above lead
+	ch := g.DoChan("key", func() (interface{}, error) {
+		t.Error("DoChan ran fn while a call was in flight")
+		return nil, nil
+	})
+	select {
+	case r := <-ch:
+		t.Fatalf("got %v before the call in flight completed", r)
+	default:
+	}
+	c <- "baz"
+	if r := <-ch; r.Val != "baz" {
+		t.Errorf("duplicate DoChan = %v; want baz", r.Val)
+	}
+	if r := <-led; r.Val != "baz" {
+		t.Errorf("leading DoChan = %v; want baz", r.Val)
+	}
+}
@@ -18,6 +18,7 @@ package groupcache
 
 import (
 	"errors"
+	"io"
 
 	"github.com/golang/protobuf/proto"
 )
@@ -27,6 +28,7 @@ var _ Sink = &allocBytesSink{}
 var _ Sink = &protoSink{}
 var _ Sink = &truncBytesSink{}
 var _ Sink = &byteViewSink{}
+var _ Sink = &writerSink{}
 
 // A Sink receives data from a Get call.
 //
@@ -44,6 +46,12 @@ type Sink interface {
 	// The caller retains ownership of m.
 	SetProto(m proto.Message) error
 
+	// Reset clears the value held by the sink, along with the value
+	// it populated, so the sink can be pooled and reused across Gets.
+	// A reused sink must not be read after Reset until a subsequent
+	// Get has populated it again.
+	Reset()
+
 	// view returns a frozen view of the bytes for caching.
 	view() (ByteView, error)
 }
@@ -71,6 +79,28 @@ func setSinkView(s Sink, v ByteView) error {
 	return s.SetString(v.s)
 }
 
+// A flushSink is a Sink which only hands its value on once the Get
+// filling it succeeded, such as WriterSink.
+type flushSink interface {
+	flush() error
+}
+
+// flushSinkView hands the value of s on if it is a flushSink.
+func flushSinkView(s Sink) error {
+	if fs, ok := s.(flushSink); ok {
+		return fs.flush()
+	}
+	return nil
+}
+
+// setFinalView sets the value of s to v as the result of a Get.
+func setFinalView(s Sink, v ByteView) error {
+	if err := setSinkView(s, v); err != nil {
+		return err
+	}
+	return flushSinkView(s)
+}
+
 // StringSink returns a Sink that populates the provided string pointer.
 func StringSink(sp *string) Sink {
 	return &stringSink{sp: sp}
@@ -87,6 +117,11 @@ func (s *stringSink) view() (ByteView, error) {
 	return s.v, nil
 }
 
+func (s *stringSink) Reset() {
+	s.v = ByteView{}
+	*s.sp = ""
+}
+
 func (s *stringSink) SetString(v string) error {
 	s.v.b = nil
 	s.v.s = v
@@ -138,6 +173,10 @@ func (s *byteViewSink) view() (ByteView, error) {
 	return *s.dst, nil
 }
 
+func (s *byteViewSink) Reset() {
+	*s.dst = ByteView{}
+}
+
 func (s *byteViewSink) SetProto(m proto.Message) error {
 	b, err := proto.Marshal(m)
 	if err != nil {
@@ -158,6 +197,11 @@ func (s *byteViewSink) SetString(v string) error {
 }
 
 // ProtoSink returns a sink that unmarshals binary proto values into m.
+//
+// Each value is decoded once: a cached value is unmarshaled straight
+// from the cache without copying it first, and a Getter which calls
+// SetProto with a message of the same type as m marshals it once for
+// the cache and copies it into m without unmarshaling.
 func ProtoSink(m proto.Message) Sink {
 	return &protoSink{
 		dst: m,
@@ -175,6 +219,25 @@ func (s *protoSink) view() (ByteView, error) {
 	return s.v, nil
 }
 
+func (s *protoSink) setView(v ByteView) error {
+	var err error
+	if v.b != nil {
+		err = proto.Unmarshal(v.b, s.dst)
+	} else {
+		err = proto.Unmarshal([]byte(v.s), s.dst)
+	}
+	if err != nil {
+		return err
+	}
+	s.v = v
+	return nil
+}
+
+func (s *protoSink) Reset() {
+	s.v = ByteView{}
+	s.dst.Reset()
+}
+
 func (s *protoSink) SetBytes(b []byte) error {
 	err := proto.Unmarshal(b, s.dst)
 	if err != nil {
@@ -201,12 +264,11 @@ func (s *protoSink) SetProto(m proto.Message) error {
 	if err != nil {
 		return err
 	}
-	// TODO(bradfitz): optimize for same-task case more and write
-	// right through? would need to document ownership rules at
-	// the same time. but then we could just assign *dst = *m
-	// here. This works for now:
-	err = proto.Unmarshal(b, s.dst)
-	if err != nil {
+	if proto.MessageName(m) == proto.MessageName(s.dst) {
+		// Copy m rather than decoding what we just encoded.
+		s.dst.Reset()
+		proto.Merge(s.dst, m)
+	} else if err = proto.Unmarshal(b, s.dst); err != nil {
 		return err
 	}
 	s.v.b = b
@@ -240,6 +302,13 @@ func (s *allocBytesSink) setView(v ByteView) error {
 	return nil
 }
 
+func (s *allocBytesSink) Reset() {
+	s.v = ByteView{}
+	if s.dst != nil {
+		*s.dst = nil
+	}
+}
+
 func (s *allocBytesSink) SetProto(m proto.Message) error {
 	b, err := proto.Marshal(m)
 	if err != nil {
@@ -289,6 +358,12 @@ func (s *truncBytesSink) view() (ByteView, error) {
 	return s.v, nil
 }
 
+// Reset clears the view held by the sink. The caller owned *dst is
+// left untouched; restore its length before reusing the sink.
+func (s *truncBytesSink) Reset() {
+	s.v = ByteView{}
+}
+
 func (s *truncBytesSink) SetProto(m proto.Message) error {
 	b, err := proto.Marshal(m)
 	if err != nil {
@@ -326,3 +401,53 @@ func (s *truncBytesSink) SetString(v string) error {
 	s.v.s = v
 	return nil
 }
+
+// WriterSink returns a Sink that writes the value to w, such as an
+// http.ResponseWriter. Cached values are written straight from the
+// cache, without copying them first. The value is written once, after
+// the Get succeeded, so nothing is written when the load or its
+// validation fails, and a value served by a peer is received in full
+// before it is written. An error writing to w fails the Get, but not
+// the load, which other Gets of the key may share.
+func WriterSink(w io.Writer) Sink {
+	return &writerSink{w: w}
+}
+
+type writerSink struct {
+	w io.Writer
+	v ByteView
+}
+
+func (s *writerSink) view() (ByteView, error) {
+	return s.v, nil
+}
+
+func (s *writerSink) setView(v ByteView) error {
+	s.v = v
+	return nil
+}
+
+func (s *writerSink) flush() error {
+	_, err := s.v.WriteTo(s.w)
+	return err
+}
+
+func (s *writerSink) Reset() {
+	s.v = ByteView{}
+}
+
+func (s *writerSink) SetProto(m proto.Message) error {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return s.setView(ByteView{b: b})
+}
+
+func (s *writerSink) SetBytes(b []byte) error {
+	return s.setView(ByteView{b: cloneBytes(b)})
+}
+
+func (s *writerSink) SetString(v string) error {
+	return s.setView(ByteView{s: v})
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import "github.com/xdbbe/groupcache/v2/lru"
+
+// tagLocked indexes the entry of key under each of its tags, see
+// GroupOptions.Tags.
+func (c *cache) tagLocked(key string, value ByteView) {
+	for _, tag := range value.tags() {
+		if c.tagged == nil {
+			c.tagged = make(map[string]map[string]bool)
+		}
+		keys := c.tagged[tag]
+		if keys == nil {
+			keys = make(map[string]bool)
+			c.tagged[tag] = keys
+		}
+		keys[key] = true
+	}
+}
+
+// untagLocked drops the entry of key from the index of its tags.
+func (c *cache) untagLocked(key string, value ByteView) {
+	for _, tag := range value.tags() {
+		keys := c.tagged[tag]
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(c.tagged, tag)
+		}
+	}
+}
+
+// removeTag removes every entry tagged with tag and returns how many it
+// removed.
+func (c *cache) removeTag(tag string) int {
+	if c.shards != nil {
+		var n int
+		for _, shard := range c.shards {
+			n += shard.removeTag(tag)
+		}
+		return n
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := c.tagged[tag]
+	if c.lru == nil || len(keys) == 0 {
+		return 0
+	}
+	removed := make([]lru.Key, 0, len(keys))
+	for key := range keys {
+		removed = append(removed, key)
+	}
+	// Removing the entries drops them from the index.
+	for _, key := range removed {
+		c.lru.Remove(key)
+	}
+	return len(removed)
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tiered composes a small local groupcache Group in front of a
+// larger shared one.
+package tiered
+
+import (
+	"context"
+	"time"
+
+	"github.com/xdbbe/groupcache/v2"
+)
+
+// Group is a two-tier cache: a local only L1 group in front of a shared
+// L2 group, usually one whose keys are spread over peers. A Get checks
+// L1, then L2, then the Getter of L2, and keeps what it got in L1.
+type Group struct {
+	l1, l2 *groupcache.Group
+}
+
+// New creates a Group named name in front of l2. Its L1 holds up to
+// cacheBytes and keeps each value for ttl, after which it is fetched
+// from l2 again; a ttl of zero keeps values until they are evicted or
+// removed. Since name names the L1 group, it must not be the name of
+// another group.
+func New(name string, cacheBytes int64, ttl time.Duration, l2 *groupcache.Group) *Group {
+	return &Group{
+		l1: groupcache.NewGroupWithOptions(name, cacheBytes, l2.AsGetter(),
+			&groupcache.GroupOptions{LocalOnly: true, TTL: ttl}),
+		l2: l2,
+	}
+}
+
+// L1 returns the local group of t.
+func (t *Group) L1() *groupcache.Group {
+	return t.l1
+}
+
+// L2 returns the shared group of t.
+func (t *Group) L2() *groupcache.Group {
+	return t.l2
+}
+
+// Get fills dest with the value of key, from L1 if it has it and from
+// L2 otherwise.
+func (t *Group) Get(ctx context.Context, key string, dest groupcache.Sink) error {
+	return t.l1.Get(ctx, key, dest)
+}
+
+// Remove removes key from both tiers. L2 goes first, so that an L1 miss
+// racing with the removal can't bring the old value back from L2. The
+// L1 copies other processes hold of key stay until their TTL passes.
+func (t *Group) Remove(ctx context.Context, key string) error {
+	if err := t.l2.Remove(ctx, key); err != nil {
+		return err
+	}
+	return t.l1.Remove(ctx, key)
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2026 The groupcache Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tiered
+
+import (
+	"context"
+	"testing"
+
+	"github.com/xdbbe/groupcache/v2"
+)
+
+func TestGroup(t *testing.T) {
+	loads := 0
+	l2 := groupcache.NewGroupWithOptions("tieredTestL2", 1<<20, groupcache.GetterFunc(
+		func(ctx context.Context, key string, dest groupcache.Sink) error {
+			loads++
+			return dest.SetString("value:" + key)
+		}), &groupcache.GroupOptions{LocalOnly: true})
+	defer groupcache.DeregisterGroup("tieredTestL2")
+	g := New("tieredTestL1", 1<<20, 0, l2)
+	defer groupcache.DeregisterGroup("tieredTestL1")
+	ctx := context.Background()
+
+	// An L2 hit fills L1 without loading.
+	if _, err := l2.GetString(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := g.Get(ctx, "a", groupcache.StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if s != "value:a" || loads != 1 {
+		t.Errorf("Get = %q after %d loads; want %q after 1", s, loads, "value:a")
+	}
+	if !g.L1().Contains("a") {
+		t.Error("L1 doesn't hold the value served by L2")
+	}
+	if err := g.Get(ctx, "a", groupcache.StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if loads != 1 {
+		t.Errorf("%d loads after an L1 hit; want 1", loads)
+	}
+
+	if err := g.Remove(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if g.L1().Contains("a") {
+		t.Error("L1 still holds the removed key")
+	}
+	if l2.Contains("a") {
+		t.Error("L2 still holds the removed key")
+	}
+	if err := g.Get(ctx, "a", groupcache.StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if loads != 2 {
+		t.Errorf("%d loads after a Get of the removed key; want 2", loads)
+	}
+}